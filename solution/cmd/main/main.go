@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
 	"os/signal"
 	"reflect"
@@ -12,19 +13,19 @@ import (
 	redisrepository "solution/internal/repository/redisRepository"
 	"solution/internal/service"
 	"solution/internal/utils"
+	migrationsfs "solution/migrations"
 	"solution/pkg/db/cache"
 	"solution/pkg/db/postgres"
 	"solution/pkg/logger"
+	"solution/pkg/mailer"
 	"strings"
 	"syscall"
+	// Promo schedules name IANA zones, which slim images don't ship.
+	_ "time/tzdata"
 
 	"go.uber.org/zap"
 )
 
-const SigningKey = "my-secret-key"
-
-var CryptoKey = []byte("12345678901234567890123456789012")
-
 func main() {
 	ctx := context.Background()
 	mainLogger := logger.New()
@@ -51,84 +52,61 @@ func main() {
 	if err != nil {
 		mainLogger.Fatal(ctx, "failed read env", zap.Error(err))
 	}
-	db.Db.Exec(`CREATE TABLE if not exists companies
-	(
-		company_id uuid NOT NULL,
-		name character varying(50) NOT NULL,
-		email character varying(120) NOT NULL,
-		password bytea NOT NULL,
-		PRIMARY KEY (company_id, email)
-	);`)
-	db.Db.Exec(`CREATE TABLE if not exists users
-	(
-		id uuid NOT NULL,
-		name character varying(120) NOT NULL,
-		surname character varying(140) NOT NULL,
-		email character varying(120) NOT NULL,
-		avatar_url text,
-		other jsonb NOT NULL,
-		password bytea NOT NULL,
-		PRIMARY KEY (id, email)
-	);`)
-	db.Db.Exec(`CREATE TABLE if not exists promos
-	(
-		id serial NOT NULL,
-		description text NOT NULL,
-		image_url text,
-		target jsonb,
-		max_count integer NOT NULL,
-		active_from bigint,
-		active_until bigint,
-		mode character varying(16) NOT NULL,
-		promo_common character varying(64),
-		promo_unique character varying(64)[],
-		used_promo_unique character varying(64)[],
-		promo_id uuid NOT NULL,
-		company_id uuid NOT NULL,
-		company_name character varying(64) NOT NULL,
-		like_count integer NOT NULL,
-		used_count integer NOT NULL,
-		comment_count int NOT NULL,
-		active boolean NOT NULL,
-		PRIMARY KEY (promo_id)
-	);`)
-	db.Db.Exec(`CREATE TABLE if not exists activations
-	(
-		seq_id serial NOT NULL,
-		activate_time bigint,
-		country character varying(4) NOT NULL,
-		promo_id uuid NOT NULL,
-		id uuid NOT NULL,
-		PRIMARY KEY(seq_id)
-	);`)
-	db.Db.Exec(`CREATE TABLE if not exists promosstat
-	(
-		promo_id uuid NOT NULL,
-		id uuid NOT NULL,
-		is_liked_by_user boolean NOT NULL,
-		PRIMARY KEY (id)
-	);`)
-	db.Db.Exec(`CREATE TABLE if not exists comments
-	(
-
-		serial_number serial NOT NULL,
-		id uuid NOT NULL,
-		user_id uuid NOT NULL,
-		promo_id uuid NOT NULL,
-		text text NOT NULL,
-		date varchar(50) NOT NULL,
-		author jsonb NOT NULL,
-		PRIMARY KEY(serial_number,id)
-	);`)
+	migrations, err := postgres.LoadMigrations(migrationsfs.FS)
+	if err != nil {
+		mainLogger.Fatal(ctx, "failed load migrations", zap.Error(err))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(ctx, cfg, db, migrations, os.Args[2:]); err != nil {
+			mainLogger.Fatal(ctx, "migrate failed", zap.Error(err))
+		}
+		return
+	}
+	if cfg.MigrateOnStart {
+		applied, err := db.MigrateUp(ctx, migrations)
+		if err != nil {
+			mainLogger.Fatal(ctx, "failed apply migrations", zap.Error(err))
+		}
+		for _, m := range applied {
+			mainLogger.Info(ctx, "migration applied", zap.Int64("version", m.Version), zap.String("name", m.Name))
+		}
+	}
 	postgresRepo := postgresrepository.New(db)
 	client := cache.New(cfg.RedisConfig)
 
 	redsiRepo := redisrepository.New(client)
 
 	srv := service.New(redsiRepo, postgresRepo)
+	if err := srv.ResumeGenerationJobs(ctx); err != nil {
+		mainLogger.Error(ctx, "failed resume code generation jobs", zap.Error(err))
+	}
+	schedulerCtx, stopScheduler := context.WithCancel(ctx)
+	defer stopScheduler()
+	go srv.RunScheduler(schedulerCtx)
 
-	handelrs := handlers.New(srv, SigningKey, cfg.AntifraudAddress, CryptoKey, utils.Validate, mainLogger)
-	server, err := http.New(ctx, handelrs, SigningKey, cfg.ServerAddress)
+	keys, err := loadKeySet(cfg)
+	if err != nil {
+		mainLogger.Fatal(ctx, "failed load jwt keys", zap.Error(err))
+	}
+	if cfg.JWTPrivateKeyFile == "" {
+		mainLogger.Warn(ctx, "JWT_EPHEMERAL_KEY is set, using ephemeral signing key", zap.String("kid", keys.KeyID()))
+	}
+
+	mail, err := mailer.New(cfg.MailerConfig, mainLogger)
+	if err != nil {
+		mainLogger.Fatal(ctx, "failed init mailer", zap.Error(err))
+	}
+
+	handelrs := handlers.New(srv, keys, handlers.Config{
+		AntifraudAddress: cfg.AntifraudAddress,
+		CryptoKey:        []byte(cfg.LegacyCryptoKey),
+		AccessTokenTTL:   cfg.AccessTokenTTL,
+		RefreshTokenTTL:  cfg.RefreshTokenTTL,
+		ReservationTTL:   cfg.ReservationTTL,
+		PublicURL:        cfg.PublicURL,
+		AdminToken:       cfg.AdminToken,
+	}, mail, utils.Validate, mainLogger)
+	server, err := http.New(ctx, handelrs, cfg.ServerAddress, cfg.TrustedProxies)
 
 	if err != nil {
 		mainLogger.Error(ctx, "", zap.Error(err))
@@ -151,3 +129,13 @@ func main() {
 
 	mainLogger.Info(ctx, "server stopped")
 }
+
+func loadKeySet(cfg *config.Config) (*utils.KeySet, error) {
+	if cfg.JWTPrivateKeyFile == "" {
+		if !cfg.JWTEphemeralKey {
+			return nil, errors.New("JWT_PRIVATE_KEY_FILE is not set, set JWT_EPHEMERAL_KEY=true to sign with a throwaway key")
+		}
+		return utils.GenerateKeySet()
+	}
+	return utils.LoadKeySet(cfg.JWTPrivateKeyFile, cfg.JWTKeyID, cfg.JWTVerifyKeys)
+}
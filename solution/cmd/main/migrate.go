@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"solution/internal/config"
+	postgresrepository "solution/internal/repository/postgresRepository"
+	redisrepository "solution/internal/repository/redisRepository"
+	"solution/internal/service"
+	"solution/pkg/db/cache"
+	"solution/pkg/db/postgres"
+	"strconv"
+	"time"
+)
+
+const migrateUsage = "usage: application migrate [up | down [steps] | status | rehash-passwords]"
+
+// runMigrate implements the "migrate" subcommand, so the schema can be changed
+// without starting the HTTP server (e.g. as a separate deploy step).
+// "rehash-passwords" moves the passwords still encrypted with
+// LEGACY_CRYPTO_KEY to bcrypt, after which the key can be dropped.
+func runMigrate(ctx context.Context, cfg *config.Config, db *postgres.DB, migrations []postgres.Migration, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		applied, err := db.MigrateUp(ctx, migrations)
+		if err != nil {
+			return err
+		}
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid steps %q: %s", args[1], migrateUsage)
+			}
+			steps = n
+		}
+		reverted, err := db.MigrateDown(ctx, migrations, steps)
+		if err != nil {
+			return err
+		}
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+	case "status":
+		statuses, err := db.MigrationsStatus(ctx, migrations)
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", st.Version, st.Name, applied)
+		}
+	case "rehash-passwords":
+		if cfg.LegacyCryptoKey == "" {
+			return errors.New("LEGACY_CRYPTO_KEY is not set")
+		}
+		srv := service.New(redisrepository.New(cache.New(cfg.RedisConfig)), postgresrepository.New(db))
+		rehashed, failed, err := srv.RehashLegacyPasswords(ctx, []byte(cfg.LegacyCryptoKey))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rehashed %d passwords\n", rehashed)
+		if failed > 0 {
+			return fmt.Errorf("%d passwords could not be decrypted with LEGACY_CRYPTO_KEY", failed)
+		}
+	default:
+		return fmt.Errorf("unknown command %q: %s", command, migrateUsage)
+	}
+	return nil
+}
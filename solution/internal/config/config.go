@@ -3,6 +3,8 @@ package config
 import (
 	"solution/pkg/db/cache"
 	"solution/pkg/db/postgres"
+	"solution/pkg/mailer"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
@@ -11,8 +13,37 @@ type Config struct {
 	ServerAddress    string `env:"SERVER_ADDRESS"`
 	AntifraudAddress string `env:"ANTIFRAUD_ADDRESS"`
 	RandomSecret     string `env:"RANDOM_SECRET"`
+	MigrateOnStart   bool   `env:"MIGRATE_ON_START" env-default:"true"`
+	// LegacyCryptoKey decrypts passwords stored before bcrypt hashing so they
+	// can be rehashed on the next sign-in or by "migrate rehash-passwords".
+	// Without it such passwords are refused.
+	LegacyCryptoKey string `env:"LEGACY_CRYPTO_KEY"`
+	// JWTPrivateKeyFile is a PEM encoded RSA or Ed25519 key used to sign access
+	// tokens. JWTVerifyKeys maps kid to PEM public keys that are still accepted,
+	// e.g. "old-key:/keys/old.pub".
+	JWTPrivateKeyFile string            `env:"JWT_PRIVATE_KEY_FILE"`
+	JWTKeyID          string            `env:"JWT_KEY_ID"`
+	JWTVerifyKeys     map[string]string `env:"JWT_VERIFY_KEYS"`
+	// JWTEphemeralKey signs with a key generated on start when no key file is
+	// set. Sessions do not survive a restart, so it is meant for development.
+	JWTEphemeralKey bool          `env:"JWT_EPHEMERAL_KEY"`
+	AccessTokenTTL  time.Duration `env:"ACCESS_TOKEN_TTL" env-default:"15m"`
+	RefreshTokenTTL time.Duration `env:"REFRESH_TOKEN_TTL" env-default:"720h"`
+	// ReservationTTL is how long a reserved code is held before it returns to
+	// the pool.
+	ReservationTTL time.Duration `env:"RESERVATION_TTL" env-default:"15m"`
+	// PublicURL is the base of links sent in emails.
+	PublicURL string `env:"PUBLIC_URL" env-default:"http://localhost:8080"`
+	// AdminToken protects /internal admin endpoints, which are disabled when
+	// it is empty.
+	AdminToken string `env:"ADMIN_TOKEN"`
+	// TrustedProxies lists the CIDR ranges of proxies whose X-Forwarded-For
+	// is trusted for the client address. Without them the peer address is
+	// used as is.
+	TrustedProxies []string `env:"TRUSTED_PROXIES" env-separator:","`
 	postgres.PostgresConfig
 	cache.RedisConfig
+	mailer.MailerConfig
 }
 
 func Read() (*Config, error) {
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"solution/internal/models"
+	"solution/internal/service"
+	"solution/internal/utils"
+	"solution/pkg/mailer"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+	passwordResetTTL     = time.Hour
+	mailTimeout          = 30 * time.Second
+)
+
+// sendMail delivers msg in the background so slow mail servers neither delay
+// the response nor reveal whether an account exists.
+func (h *Handlers) sendMail(msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+		if err := h.mailer.Send(ctx, msg); err != nil {
+			h.Error(ctx, "failed send mail", zap.Error(err), zap.String("subject", msg.Subject))
+		}
+	}()
+}
+
+// issueToken stores a new one-time token and returns the value to mail.
+func (h *Handlers) issueToken(ctx context.Context, purpose, accountID, role string, ttl time.Duration) (string, error) {
+	secret, hash, err := utils.NewSecretToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	err = h.service.CreateOneTimeToken(ctx, models.OneTimeToken{
+		Hash:      hash,
+		Purpose:   purpose,
+		AccountID: accountID,
+		Role:      role,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+func (h *Handlers) sendVerificationEmail(ctx context.Context, accountID, role, email string) error {
+	token, err := h.issueToken(ctx, models.TokenEmailVerification, accountID, role, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	h.sendMail(mailer.Message{
+		To:      email,
+		Subject: "Подтверждение email",
+		Body: fmt.Sprintf("Чтобы подтвердить email, перейдите по ссылке:\n%s/verify-email?token=%s\n\nКод подтверждения: %s\nСсылка действует 24 часа.",
+			h.PublicURL, token, token),
+	})
+	return nil
+}
+func (h *Handlers) sendPasswordResetEmail(ctx context.Context, accountID, role, email string) error {
+	token, err := h.issueToken(ctx, models.TokenPasswordReset, accountID, role, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+	h.sendMail(mailer.Message{
+		To:      email,
+		Subject: "Сброс пароля",
+		Body: fmt.Sprintf("Чтобы задать новый пароль, перейдите по ссылке:\n%s/reset-password?token=%s\n\nКод сброса: %s\nСсылка действует 1 час. Если вы не запрашивали сброс, проигнорируйте это письмо.",
+			h.PublicURL, token, token),
+	})
+	return nil
+}
+func (h *Handlers) BusinessVerifyEmail(c echo.Context) error {
+	return h.verifyEmail(c, "company")
+}
+func (h *Handlers) UserVerifyEmail(c echo.Context) error {
+	return h.verifyEmail(c, "user")
+}
+func (h *Handlers) verifyEmail(c echo.Context, roles ...string) error {
+	var req models.VerifyEmailRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	err := h.service.VerifyEmail(c.Request().Context(), utils.HashSecretToken(*req.Token), roles)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		if err == service.ErrTokenInvalid {
+			return echo.NewHTTPError(http.StatusNotFound, echo.Map{
+				"status":  "error",
+				"message": "Ссылка недействительна или устарела.",
+			})
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	return c.JSON(200, echo.Map{"status": "ok"})
+}
+func (h *Handlers) ResendVerificationEmail(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+
+	if user.MemberID != "" {
+		h.Error(c.Request().Context(), "members are verified by invite", zap.String("member_id", user.MemberID))
+		return echo.NewHTTPError(http.StatusConflict, echo.Map{
+			"status":  "error",
+			"message": "Email уже подтверждён.",
+		})
+	}
+	email, err := h.service.GetUnverifiedEmail(c.Request().Context(), user.ID, user.Role)
+	if err == nil {
+		err = h.sendVerificationEmail(c.Request().Context(), user.ID, user.Role, email)
+	}
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		if err == service.ErrEmailVerified {
+			return echo.NewHTTPError(http.StatusConflict, echo.Map{
+				"status":  "error",
+				"message": "Email уже подтверждён.",
+			})
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	return c.JSON(200, echo.Map{"status": "ok"})
+}
+
+// BusinessPasswordReset and UserPasswordReset always answer 200 so the
+// endpoint cannot be used to probe which emails are registered.
+func (h *Handlers) BusinessPasswordReset(c echo.Context) error {
+	return h.passwordReset(c, func(ctx context.Context, email string) (string, string, error) {
+		cmp, err := h.service.CompanySignIn(ctx, models.Company{Email: email})
+		if err == nil {
+			return cmp.CompanyID, "company", nil
+		}
+		if err != service.ErrEmailNotRegistrated {
+			return "", "", err
+		}
+		member, err := h.service.MemberSignIn(ctx, email)
+		if err != nil {
+			return "", "", err
+		}
+		return member.ID, "member", nil
+	})
+}
+func (h *Handlers) UserPasswordReset(c echo.Context) error {
+	return h.passwordReset(c, func(ctx context.Context, email string) (string, string, error) {
+		usr, err := h.service.UserSignIn(ctx, models.User{Email: &email})
+		if err != nil {
+			return "", "", err
+		}
+		return *usr.ID, "user", nil
+	})
+}
+func (h *Handlers) passwordReset(c echo.Context, lookup func(ctx context.Context, email string) (string, string, error)) error {
+	var req models.PasswordResetRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	accountID, role, err := lookup(c.Request().Context(), *req.Email)
+	if err == nil {
+		err = h.sendPasswordResetEmail(c.Request().Context(), accountID, role, *req.Email)
+	}
+	if err != nil {
+		h.Error(c.Request().Context(), "password reset not sent", zap.Error(err))
+	}
+	return c.JSON(200, echo.Map{"status": "ok"})
+}
+func (h *Handlers) BusinessPasswordResetConfirm(c echo.Context) error {
+	return h.passwordResetConfirm(c, "company", "member")
+}
+func (h *Handlers) UserPasswordResetConfirm(c echo.Context) error {
+	return h.passwordResetConfirm(c, "user")
+}
+func (h *Handlers) passwordResetConfirm(c echo.Context, roles ...string) error {
+	var req models.PasswordResetConfirmRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	hashedPassword, err := utils.HashPassword(*req.Password)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	err = h.service.ResetPassword(c.Request().Context(), utils.HashSecretToken(*req.Token), roles, hashedPassword)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		if err == service.ErrTokenInvalid {
+			return echo.NewHTTPError(http.StatusNotFound, echo.Map{
+				"status":  "error",
+				"message": "Ссылка недействительна или устарела.",
+			})
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	return c.JSON(200, echo.Map{"status": "ok"})
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"solution/internal/models"
+	"solution/internal/service"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+var activationDeniedMessages = map[string]string{
+	models.ActivationLimitReached: "Вы уже использовали этот промокод максимальное число раз.",
+	models.WindowLimitReached:     "Лимит активаций этого промокода за период исчерпан.",
+	models.ActivationCooldown:     "Повторная активация этого промокода пока недоступна.",
+	models.OutsideSchedule:        "Промокод сейчас не действует по расписанию.",
+}
+
+func (h *Handlers) activationDenied(c echo.Context, err *service.ActivationDeniedError) error {
+	if err.RetryAfter > 0 {
+		c.Response().Header().Set("Retry-After", strconv.FormatInt(err.RetryAfter, 10))
+	}
+	return echo.NewHTTPError(http.StatusForbidden, echo.Map{
+		"status":  "error",
+		"message": activationDeniedMessages[err.Reason],
+		"reason":  err.Reason,
+	})
+}
+func (h *Handlers) activationError(c echo.Context, err error) error {
+	h.Error(c.Request().Context(), "", zap.Error(err))
+	var denied *service.ActivationDeniedError
+	if errors.As(err, &denied) {
+		return h.activationDenied(c, denied)
+	}
+	if err == service.ErrPromoNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, echo.Map{
+			"status":  "error",
+			"message": "Промокод не найден.",
+		})
+	}
+	if err == service.ErrNoPermission {
+		return echo.NewHTTPError(http.StatusForbidden, echo.Map{
+			"status":  "error",
+			"message": "Вы не можете использовать этот промокод.",
+		})
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+		"status":  "error",
+		"message": "Ошибка в данных запроса.",
+	})
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"io"
+	"net/http"
+	"solution/internal/models"
+	"solution/internal/utils"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// csvPart returns the "file" part of a multipart request without buffering
+// the upload.
+func csvPart(c echo.Context) (io.Reader, error) {
+	reader, err := c.Request().MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+func (h *Handlers) BussinessImportCodes(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	var req models.PromoCodesRequest
+	if err := (&echo.DefaultBinder{}).BindPathParams(c, &req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	file, err := csvPart(c)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	report, err := h.service.ImportCodes(c.Request().Context(), user.ID, *req.PromoID, file)
+	if err != nil {
+		return h.generationError(c, err)
+	}
+	h.audit(c, "promo.codes.import", *req.PromoID)
+	return c.JSON(200, report)
+}
+func (h *Handlers) BussinessExportCodes(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	var req models.PromoCodesRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	var w *csv.Writer
+	rows := 0
+	err := h.service.ExportCodes(c.Request().Context(), user.ID, *req.PromoID, func(code models.PromoCode) error {
+		if w == nil {
+			w = h.startCSV(c, "promo-"+*req.PromoID+"-codes.csv")
+		}
+		record := []string{code.Code, code.Status, "", ""}
+		if code.UserID != nil {
+			record[2] = *code.UserID
+		}
+		if code.ActivatedAt != nil {
+			record[3] = time.Unix(*code.ActivatedAt, 0).UTC().Format(time.RFC3339)
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+		if rows++; rows%1000 == 0 {
+			w.Flush()
+			c.Response().Flush()
+		}
+		return w.Error()
+	})
+	if err != nil && w == nil {
+		return h.generationError(c, err)
+	}
+	if err != nil {
+		// The status line is already sent, all we can do is cut the file short.
+		h.Error(c.Request().Context(), "failed export codes", zap.Error(err), zap.Int("rows", rows))
+		return nil
+	}
+	if w == nil {
+		w = h.startCSV(c, "promo-"+*req.PromoID+"-codes.csv")
+	}
+	w.Flush()
+	h.Info(c.Request().Context(), "codes exported", zap.String("promo_id", *req.PromoID), zap.Int("rows", rows))
+	return w.Error()
+}
+func (h *Handlers) startCSV(c echo.Context, filename string) *csv.Writer {
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	c.Response().WriteHeader(http.StatusOK)
+	w := csv.NewWriter(c.Response())
+	w.Write([]string{"code", "status", "user_id", "activated_at"})
+	return w
+}
+func (h *Handlers) BussinessAppendCodes(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	var req models.AppendCodesRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	report, err := h.service.AppendCodes(c.Request().Context(), user.ID, *req.PromoID, req.Codes)
+	if err != nil {
+		return h.generationError(c, err)
+	}
+	h.audit(c, "promo.codes.append", *req.PromoID)
+	return c.JSON(200, report)
+}
+func (h *Handlers) BussinessInvalidateCodes(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	var req models.InvalidateCodesRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	res, err := h.service.InvalidateCodes(c.Request().Context(), user.ID, *req.PromoID, req.Codes)
+	if err != nil {
+		return h.generationError(c, err)
+	}
+	h.audit(c, "promo.codes.invalidate", *req.PromoID)
+	return c.JSON(200, res)
+}
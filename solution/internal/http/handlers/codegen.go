@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"net/http"
+	"solution/internal/models"
+	"solution/internal/service"
+	"solution/internal/utils"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// maxCodeLength matches the longest code accepted in promo_unique.
+const maxCodeLength = 30
+
+func codePattern(req models.GenerateCodesRequest) (utils.CodePattern, error) {
+	var prefix, alphabet string
+	if req.Prefix != nil {
+		prefix = *req.Prefix
+	}
+	if req.Alphabet != nil {
+		alphabet = *req.Alphabet
+	}
+	pattern, err := utils.NewCodePattern(prefix, *req.Length, alphabet, req.CheckDigit)
+	if err != nil {
+		return pattern, err
+	}
+	if pattern.CodeLength() > maxCodeLength {
+		return pattern, utils.ErrInvalidCodePattern
+	}
+	return pattern, nil
+}
+func generationJobResponse(job models.CodeGenerationJob) models.CodeGenerationJobResponse {
+	res := models.CodeGenerationJobResponse{
+		ID:        job.ID,
+		PromoID:   job.PromoID,
+		Status:    job.Status,
+		Total:     job.Total,
+		Generated: job.Generated,
+		CreatedAt: time.Unix(job.CreatedAt, 0).UTC().Format(time.RFC3339),
+	}
+	if job.Error != nil {
+		res.Error = *job.Error
+	}
+	if job.FinishedAt != nil {
+		res.FinishedAt = time.Unix(*job.FinishedAt, 0).UTC().Format(time.RFC3339)
+	}
+	return res
+}
+func (h *Handlers) generationError(c echo.Context, err error) error {
+	h.Error(c.Request().Context(), "", zap.Error(err))
+	switch err {
+	case service.ErrNoPermission:
+		return echo.NewHTTPError(http.StatusForbidden, echo.Map{
+			"status":  "error",
+			"message": "Промокод не принадлежит этой компании.",
+		})
+	case service.ErrPromoNotFound, service.ErrJobNotFound:
+		return echo.NewHTTPError(http.StatusNotFound, echo.Map{
+			"status":  "error",
+			"message": "Промокод не найден.",
+		})
+	case service.ErrPromoNotUnique:
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Коды можно добавлять только к промокоду с режимом UNIQUE.",
+		})
+	case service.ErrCodeSpaceExhausted:
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Шаблон не позволяет создать столько уникальных кодов.",
+		})
+	case service.ErrCodeTaken:
+		return echo.NewHTTPError(http.StatusConflict, echo.Map{
+			"status":  "error",
+			"message": "Код уже используется другим промокодом компании.",
+		})
+	case service.ErrGenerationFailed:
+		return echo.NewHTTPError(http.StatusInternalServerError, echo.Map{
+			"status":  "error",
+			"message": "Не удалось сгенерировать коды.",
+		})
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+		"status":  "error",
+		"message": "Ошибка в данных запроса.",
+	})
+}
+func (h *Handlers) BussinessGenerateCodes(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	var req models.GenerateCodesParams
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	pattern, err := codePattern(req.GenerateCodesRequest)
+	if err != nil {
+		return h.generationError(c, err)
+	}
+	job, err := h.service.GenerateCodes(c.Request().Context(), user.ID, *req.PromoID, pattern, *req.Count)
+	if err != nil {
+		return h.generationError(c, err)
+	}
+	h.audit(c, "promo.codes.generate", *req.PromoID)
+	if job.Status == models.JobPending || job.Status == models.JobRunning {
+		return c.JSON(http.StatusAccepted, generationJobResponse(*job))
+	}
+	return c.JSON(http.StatusCreated, generationJobResponse(*job))
+}
+func (h *Handlers) BussinessGetGenerationJob(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	var req models.CodeGenerationJobRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	job, err := h.service.GetGenerationJob(c.Request().Context(), user.ID, *req.PromoID, *req.JobID)
+	if err != nil {
+		return h.generationError(c, err)
+	}
+	return c.JSON(200, generationJobResponse(*job))
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"solution/internal/models"
+	"solution/internal/utils"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func (h *Handlers) BussinessGetProfile(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	profile, err := h.service.GetCompanyProfile(c.Request().Context(), user.ID)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
+			"status":  "error",
+			"message": "Пользователь не авторизован.",
+		})
+	}
+	return c.JSON(200, profile)
+}
+func (h *Handlers) BussinessUpdateProfile(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	var req models.UpdateCompanyProfileRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	profile, err := h.service.UpdateCompanyTimeZone(c.Request().Context(), user.ID, *req.TimeZone)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	h.audit(c, "company.update", user.ID)
+	return c.JSON(200, profile)
+}
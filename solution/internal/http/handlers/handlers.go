@@ -11,6 +11,7 @@ import (
 	"solution/internal/service"
 	"solution/internal/utils"
 	"solution/pkg/logger"
+	"solution/pkg/mailer"
 	"strings"
 	"time"
 
@@ -24,13 +25,66 @@ import (
 type Service interface {
 	CompanySignUp(ctx context.Context, company models.Company) error
 	CompanySignIn(ctx context.Context, company models.Company) (*models.Company, error)
-	UpdateToken(ctx context.Context, id, token string) error
-	GetToken(ctx context.Context, id string) (string, error)
-	CreatePromo(ctx context.Context, promo *models.Promo) error
+	UpdateCompanyPassword(ctx context.Context, company models.Company) error
+	CreateSession(ctx context.Context, session models.Session) error
+	RefreshSession(ctx context.Context, id string, roles []string, oldHash, newHash []byte, expiresAt int64) (*models.Session, error)
+	CheckSession(ctx context.Context, id, accountID, role string) error
+	GetSessions(ctx context.Context, accountID, role string) ([]models.Session, error)
+	RevokeSession(ctx context.Context, id, accountID, role string) error
+	RevokeAllSessions(ctx context.Context, accountID, role string) error
+	CreateAPIKey(ctx context.Context, key models.APIKey) error
+	GetAPIKeys(ctx context.Context, companyID string) ([]models.APIKey, error)
+	AuthenticateAPIKey(ctx context.Context, hash []byte) (*models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id, companyID string) error
+	InviteMember(ctx context.Context, member models.CompanyMember) error
+	AcceptInvite(ctx context.Context, inviteHash, password []byte) (*models.CompanyMember, error)
+	MemberSignIn(ctx context.Context, email string) (*models.CompanyMember, error)
+	GetMember(ctx context.Context, id string) (*models.CompanyMember, error)
+	GetMembers(ctx context.Context, companyID string) ([]models.CompanyMember, error)
+	UpdateMemberRole(ctx context.Context, companyID, id, role string) (*models.CompanyMember, error)
+	RemoveMember(ctx context.Context, companyID, id string) error
+	AddAuditRecord(ctx context.Context, record models.AuditRecord) error
+	GetAuditLog(ctx context.Context, companyID string, limit, offset int) ([]models.AuditRecord, int, error)
+	CreateOneTimeToken(ctx context.Context, token models.OneTimeToken) error
+	GetUnverifiedEmail(ctx context.Context, accountID, role string) (string, error)
+	VerifyEmail(ctx context.Context, hash []byte, roles []string) error
+	ResetPassword(ctx context.Context, hash []byte, roles []string, password []byte) error
+	CheckSignIn(ctx context.Context, scope, email, ip string) (time.Duration, error)
+	SignInFailed(ctx context.Context, scope, email, ip string) (*models.SecurityEvent, error)
+	SignInSucceeded(ctx context.Context, scope, email string) error
+	UnlockAccount(ctx context.Context, scope, email, ip, adminIP string) error
+	TwoFactorEnabled(ctx context.Context, accountID, role string) (bool, error)
+	EnrollTwoFactor(ctx context.Context, accountID, role string) (*models.TwoFactorEnrollResponse, error)
+	EnableTwoFactor(ctx context.Context, accountID, role, code string, recoveryHashes [][]byte) error
+	VerifyTwoFactor(ctx context.Context, accountID, role, code string) error
+	DisableTwoFactor(ctx context.Context, accountID, role, code string) error
+	RegenerateRecoveryCodes(ctx context.Context, accountID, role, code string, recoveryHashes [][]byte) error
+	TwoFactorSignIn(ctx context.Context, hash []byte, code string) (*models.OneTimeToken, error)
+	ConfirmSession(ctx context.Context, id string) error
+	CheckStepUp(ctx context.Context, sessionID, accountID, role string) error
+	GenerateCodes(ctx context.Context, companyID, promoID string, pattern utils.CodePattern, count int) (*models.CodeGenerationJob, error)
+	GetGenerationJob(ctx context.Context, companyID, promoID, jobID string) (*models.CodeGenerationJob, error)
+	ImportCodes(ctx context.Context, companyID, promoID string, r io.Reader) (*models.ImportCodesReport, error)
+	ExportCodes(ctx context.Context, companyID, promoID string, fn func(models.PromoCode) error) error
+	AppendCodes(ctx context.Context, companyID, promoID string, codes []string) (*models.ImportCodesReport, error)
+	InvalidateCodes(ctx context.Context, companyID, promoID string, codes []string) (*models.InvalidateCodesResponse, error)
+	ArchivePromo(ctx context.Context, companyID, promoID string) (*models.GetPromoResponse, error)
+	UnarchivePromo(ctx context.Context, companyID, promoID string) (*models.GetPromoResponse, error)
+	DeletePromo(ctx context.Context, companyID, promoID string) error
+	PublishPromo(ctx context.Context, companyID, promoID string, publishAt *int64) (*models.GetPromoResponse, error)
+	UnpublishPromo(ctx context.Context, companyID, promoID string) (*models.GetPromoResponse, error)
+	GetCompanyProfile(ctx context.Context, companyID string) (*models.CompanyProfileResponse, error)
+	UpdateCompanyTimeZone(ctx context.Context, companyID, timeZone string) (*models.CompanyProfileResponse, error)
+	CompanyLocation(ctx context.Context, companyID string) (*time.Location, error)
+	GetPromoTransitions(ctx context.Context, companyID, promoID string, limit, offset int) ([]models.PromoTransition, int, error)
+	CreatePromo(ctx context.Context, promo *models.Promo, pattern utils.CodePattern, count int) (*models.CodeGenerationJob, error)
 	GetPromos(ctx context.Context, sortRules *models.CompanySort) ([]models.GetPromoResponse, int, error)
 	GetPromo(ctx context.Context, promo models.Promo) (*models.GetPromoResponse, error)
 	GetPromoStat(ctx context.Context, promo models.GetPromoStatRequest) (*models.GetPromoStatResponse, error)
 	EditPromo(ctx context.Context, promo *models.Promo) (*models.GetPromoResponse, error)
+	GetPromoCode(ctx context.Context, companyID, promoID, code string) (*models.PromoCode, error)
+	LookupCode(ctx context.Context, companyID, code string) (*models.CodeLookup, error)
+	RedeemCode(ctx context.Context, companyID string, req models.RedeemCodeRequest) (*models.CodeLookup, error)
 	UserSignUp(ctx context.Context, user models.User) error
 	UserSignIn(ctx context.Context, user models.User) (*models.User, error)
 	GetUser(ctx context.Context, user models.User) (*models.User, error)
@@ -47,25 +101,49 @@ type Service interface {
 	CheckCache(ctx context.Context, userID string) (bool, error)
 	Cache(ctx context.Context, userID, until string, value bool) error
 	UserActivatePromo(ctx context.Context, promo models.ActivateRequest) (string, error)
+	ReserveActivation(ctx context.Context, promo models.ActivateRequest, ttl time.Duration) (*models.Reservation, error)
+	ConfirmReservation(ctx context.Context, req models.ReservationRequest) (*models.Reservation, error)
+	ReleaseReservation(ctx context.Context, req models.ReservationRequest) (*models.Reservation, error)
+	Quote(ctx context.Context, req models.QuoteRequest) (*models.QuoteResponse, error)
 	GetUserHistory(ctx context.Context, sortRules *models.HistorySort) ([]models.FeedUserResponse, int, error)
 }
-type Handlers struct {
-	service          Service
-	SigningKey       string
+
+// Config holds the settings the handlers read from the environment.
+type Config struct {
 	AntifraudAddress string
-	CryptoKey        []byte
-	validate         *validator.Validate
+	// CryptoKey decrypts passwords stored before bcrypt hashing, see
+	// utils.CheckPassword.
+	CryptoKey       []byte
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	ReservationTTL  time.Duration
+	PublicURL       string
+	AdminToken      string
+}
+type Handlers struct {
+	service Service
+	Keys    *utils.KeySet
+	Config
+	mailer   mailer.Mailer
+	validate *validator.Validate
 	logger.Logger
 }
 
-func New(srv Service, SigningKey, AntifraudAddress string, CryptoKey []byte, validate *validator.Validate, l logger.Logger) *Handlers {
-	return &Handlers{srv, SigningKey, AntifraudAddress, CryptoKey, validate, l}
+func New(srv Service, keys *utils.KeySet, cfg Config, m mailer.Mailer, validate *validator.Validate, l logger.Logger) *Handlers {
+	return &Handlers{service: srv, Keys: keys, Config: cfg, mailer: m, validate: validate, Logger: l}
 }
 func (h *Handlers) Ping(c echo.Context) error {
 	return c.JSON(200, echo.Map{"status": "PROOOOOOOOOOOOOOOOOD"})
 }
+func (h *Handlers) JWKS(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(200, h.Keys.JWKS())
+}
 func (h *Handlers) BussinessAuthJWT(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		if apiKey := c.Request().Header.Get("X-API-Key"); apiKey != "" {
+			return h.apiKeyAuth(c, next, apiKey)
+		}
 		bearerToken := c.Request().Header.Get("Authorization")
 		splitToken := strings.Split(bearerToken, " ")
 		if len(splitToken) != 2 {
@@ -75,8 +153,11 @@ func (h *Handlers) BussinessAuthJWT(next echo.HandlerFunc) echo.HandlerFunc {
 				"message": "Пользователь не авторизован.",
 			})
 		}
+		if utils.IsAPIKey(splitToken[1]) {
+			return h.apiKeyAuth(c, next, splitToken[1])
+		}
 
-		company, err := utils.VerifyToken(splitToken[1], h.SigningKey)
+		company, err := utils.VerifyToken(splitToken[1], h.Keys)
 
 		if err != nil {
 			h.Error(c.Request().Context(), "", zap.Error(err))
@@ -85,29 +166,100 @@ func (h *Handlers) BussinessAuthJWT(next echo.HandlerFunc) echo.HandlerFunc {
 				"message": "Пользователь не авторизован.",
 			})
 		}
-		token, err := h.service.GetToken(c.Request().Context(), company.ID)
-		if err != nil {
-			h.Error(c.Request().Context(), "", zap.Error(err))
+		if company.Role != "company" {
+			h.Error(c.Request().Context(), "access deny, forbidden")
 			return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
 				"status":  "error",
 				"message": "Пользователь не авторизован.",
 			})
 		}
-		if token != splitToken[1] {
-			h.Error(c.Request().Context(), "token not valid", zap.String("expected", token), zap.String("recieved", splitToken[1]))
+		accountID, role := company.SessionAccount()
+		err = h.service.CheckSession(c.Request().Context(), company.SessionID, accountID, role)
+		if err != nil {
+			h.Error(c.Request().Context(), "session not valid", zap.Error(err), zap.String("session_id", company.SessionID))
 			return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
 				"status":  "error",
 				"message": "Пользователь не авторизован.",
 			})
 		}
-		if company.Role != "company" {
-			h.Error(c.Request().Context(), "access deny, forbidden")
-			return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
+		if company.MemberID != "" {
+			// A nil Scopes would mean the unrestricted company account, so an
+			// unknown role must not get that far.
+			scopes, ok := models.MemberScopes[company.MemberRole]
+			if !ok {
+				h.Error(c.Request().Context(), "unknown member role", zap.String("member_id", company.MemberID), zap.String("role", company.MemberRole))
+				return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
+					"status":  "error",
+					"message": "Пользователь не авторизован.",
+				})
+			}
+			company.Scopes = scopes
+		}
+		c.Set("user", company)
+		return next(c)
+	}
+}
+func (h *Handlers) apiKeyAuth(c echo.Context, next echo.HandlerFunc, apiKey string) error {
+	key, err := h.service.AuthenticateAPIKey(c.Request().Context(), utils.HashAPIKey(apiKey))
+	if err != nil {
+		h.Error(c.Request().Context(), "api key not valid", zap.Error(err))
+		return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
+			"status":  "error",
+			"message": "Пользователь не авторизован.",
+		})
+	}
+	c.Set("user", &utils.JWTClaims{
+		ID:       key.CompanyID,
+		Role:     "company",
+		APIKeyID: key.ID,
+		Scopes:   key.Scopes,
+	})
+	return next(c)
+}
+
+// RequireScope limits an API key to routes allowed by its scopes.
+func (h *Handlers) RequireScope(scopes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := c.Get("user").(*utils.JWTClaims)
+			if !user.HasScope(scopes...) {
+				h.Error(c.Request().Context(), "api key scope not allowed", zap.String("api_key_id", user.APIKeyID))
+				return echo.NewHTTPError(http.StatusForbidden, echo.Map{
+					"status":  "error",
+					"message": "Недостаточно прав.",
+				})
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireOwner limits a route to the company account and its owners.
+func (h *Handlers) RequireOwner(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := c.Get("user").(*utils.JWTClaims)
+		if !user.IsOwner() {
+			h.Error(c.Request().Context(), "owner role required", zap.String("member_id", user.MemberID), zap.String("api_key_id", user.APIKeyID))
+			return echo.NewHTTPError(http.StatusForbidden, echo.Map{
 				"status":  "error",
-				"message": "Пользователь не авторизован.",
+				"message": "Недостаточно прав.",
+			})
+		}
+		return next(c)
+	}
+}
+
+// SessionOnly rejects API keys on routes that manage the account itself.
+func (h *Handlers) SessionOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := c.Get("user").(*utils.JWTClaims)
+		if user.APIKeyID != "" {
+			h.Error(c.Request().Context(), "api key not allowed", zap.String("api_key_id", user.APIKeyID))
+			return echo.NewHTTPError(http.StatusForbidden, echo.Map{
+				"status":  "error",
+				"message": "Недостаточно прав.",
 			})
 		}
-		c.Set("user", company)
 		return next(c)
 	}
 }
@@ -123,7 +275,7 @@ func (h *Handlers) UserAuthJWT(next echo.HandlerFunc) echo.HandlerFunc {
 			})
 		}
 
-		user, err := utils.VerifyToken(splitToken[1], h.SigningKey)
+		user, err := utils.VerifyToken(splitToken[1], h.Keys)
 		if err != nil {
 			h.Error(c.Request().Context(), "", zap.Error(err))
 			return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
@@ -131,16 +283,9 @@ func (h *Handlers) UserAuthJWT(next echo.HandlerFunc) echo.HandlerFunc {
 				"message": "Пользователь не авторизован.",
 			})
 		}
-		token, err := h.service.GetToken(c.Request().Context(), user.ID)
+		err = h.service.CheckSession(c.Request().Context(), user.SessionID, user.ID, "user")
 		if err != nil {
-			h.Error(c.Request().Context(), "", zap.Error(err))
-			return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
-				"status":  "error",
-				"message": "Пользователь не авторизован.",
-			})
-		}
-		if token != splitToken[1] {
-			h.Error(c.Request().Context(), "token not valid", zap.String("expected", token), zap.String("recieved", splitToken[1]))
+			h.Error(c.Request().Context(), "session not valid", zap.Error(err), zap.String("session_id", user.SessionID))
 			return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
 				"status":  "error",
 				"message": "Пользователь не авторизован.",
@@ -168,6 +313,205 @@ func (h *Handlers) RequestTimeMiddleware(next echo.HandlerFunc) echo.HandlerFunc
 	}
 }
 
+// startSession opens a new session for the account in claims and issues the
+// access and refresh tokens bound to it.
+func (h *Handlers) startSession(c echo.Context, claims utils.JWTClaims) (*models.TokenPair, error) {
+	sessionID := uuid.NewString()
+	refreshToken, refreshHash, err := utils.NewRefreshToken(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	accountID, role := claims.SessionAccount()
+	now := time.Now()
+	err = h.service.CreateSession(c.Request().Context(), models.Session{
+		ID:          sessionID,
+		AccountID:   accountID,
+		Role:        role,
+		RefreshHash: refreshHash,
+		UserAgent:   c.Request().UserAgent(),
+		IP:          c.RealIP(),
+		CreatedAt:   now.Unix(),
+		LastUsedAt:  now.Unix(),
+		ExpiresAt:   now.Add(h.RefreshTokenTTL).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims.SessionID = sessionID
+	token, err := utils.CreateToken(claims, h.Keys, h.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &models.TokenPair{Token: token, RefreshToken: refreshToken, SessionID: sessionID}, nil
+}
+func (h *Handlers) BusinessRefresh(c echo.Context) error {
+	return h.refreshSession(c, "company", "member")
+}
+func (h *Handlers) UserRefresh(c echo.Context) error {
+	return h.refreshSession(c, "user")
+}
+func (h *Handlers) refreshSession(c echo.Context, roles ...string) error {
+	var req models.RefreshTokenRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	sessionID, oldHash, err := utils.ParseRefreshToken(*req.RefreshToken)
+	if err == nil {
+		_, err = uuid.Parse(sessionID)
+	}
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
+			"status":  "error",
+			"message": "Пользователь не авторизован.",
+		})
+	}
+	refreshToken, newHash, err := utils.NewRefreshToken(sessionID)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	session, err := h.service.RefreshSession(c.Request().Context(), sessionID, roles, oldHash, newHash, time.Now().Add(h.RefreshTokenTTL).Unix())
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err), zap.String("session_id", sessionID))
+		if err == service.ErrSessionInvalid {
+			return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
+				"status":  "error",
+				"message": "Пользователь не авторизован.",
+			})
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	claims := utils.JWTClaims{ID: session.AccountID, Role: session.Role, SessionID: session.ID}
+	if session.Role == "member" {
+		member, err := h.service.GetMember(c.Request().Context(), session.AccountID)
+		if err != nil {
+			h.Error(c.Request().Context(), "", zap.Error(err), zap.String("session_id", sessionID))
+			return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
+				"status":  "error",
+				"message": "Пользователь не авторизован.",
+			})
+		}
+		claims = utils.JWTClaims{ID: member.CompanyID, Role: "company", SessionID: session.ID, MemberID: member.ID, MemberRole: member.Role}
+	}
+	token, err := utils.CreateToken(claims, h.Keys, h.AccessTokenTTL)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	return c.JSON(200, models.TokenPair{
+		Token:        token,
+		RefreshToken: refreshToken,
+	})
+}
+func (h *Handlers) GetSessions(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+
+	accountID, role := user.SessionAccount()
+	sessions, err := h.service.GetSessions(c.Request().Context(), accountID, role)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	resp := make([]models.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		resp = append(resp, models.SessionResponse{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  time.Unix(session.CreatedAt, 0).UTC().Format(time.RFC3339),
+			LastUsedAt: time.Unix(session.LastUsedAt, 0).UTC().Format(time.RFC3339),
+			ExpiresAt:  time.Unix(session.ExpiresAt, 0).UTC().Format(time.RFC3339),
+			Current:    session.ID == user.SessionID,
+		})
+	}
+	return c.JSON(200, resp)
+}
+func (h *Handlers) RevokeSession(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+
+	var req models.SessionRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	accountID, role := user.SessionAccount()
+	err := h.service.RevokeSession(c.Request().Context(), *req.ID, accountID, role)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		if err == service.ErrSessionNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, echo.Map{
+				"status":  "error",
+				"message": "Сессия не найдена.",
+			})
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	return c.JSON(200, echo.Map{"status": "ok"})
+}
+func (h *Handlers) SignOut(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+
+	var req models.SignOutRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	var err error
+	accountID, role := user.SessionAccount()
+	if req.All {
+		err = h.service.RevokeAllSessions(c.Request().Context(), accountID, role)
+	} else {
+		err = h.service.RevokeSession(c.Request().Context(), user.SessionID, accountID, role)
+	}
+	if err != nil && err != service.ErrSessionNotFound {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	return c.JSON(200, echo.Map{"status": "ok"})
+}
 func (h *Handlers) BusinessSignUp(c echo.Context) error {
 	var body models.CompanySignUpRequest
 	if c.Request().Header.Get("Content-Type") != "application/json" {
@@ -192,7 +536,7 @@ func (h *Handlers) BusinessSignUp(c echo.Context) error {
 			"message": "Ошибка в данных запроса.",
 		})
 	}
-	hashedPassword, err := utils.Encrypt([]byte(body.Password), h.CryptoKey)
+	hashedPassword, err := utils.HashPassword(body.Password)
 	if err != nil {
 		h.Error(c.Request().Context(), "", zap.Error(err))
 		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
@@ -206,6 +550,10 @@ func (h *Handlers) BusinessSignUp(c echo.Context) error {
 		Name:      body.Name,
 		Email:     body.Email,
 		Password:  hashedPassword,
+		TimeZone:  body.TimeZone,
+	}
+	if company.TimeZone == "" {
+		company.TimeZone = utils.DefaultTimeZone
 	}
 	err = h.service.CompanySignUp(c.Request().Context(), company)
 	if err != nil {
@@ -221,15 +569,10 @@ func (h *Handlers) BusinessSignUp(c echo.Context) error {
 			"message": "Ошибка в данных запроса.",
 		})
 	}
-	token, err := utils.CreateToken(company.CompanyID, "company", h.SigningKey)
-	if err != nil {
-		h.Error(c.Request().Context(), "", zap.Error(err))
-		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
-			"status":  "error",
-			"message": "Ошибка в данных запроса.",
-		})
+	if err := h.sendVerificationEmail(c.Request().Context(), company.CompanyID, "company", company.Email); err != nil {
+		h.Error(c.Request().Context(), "failed send verification email", zap.Error(err))
 	}
-	err = h.service.UpdateToken(c.Request().Context(), company.CompanyID, token)
+	tokens, err := h.startSession(c, utils.JWTClaims{ID: company.CompanyID, Role: "company"})
 	if err != nil {
 		h.Error(c.Request().Context(), "", zap.Error(err))
 		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
@@ -238,8 +581,9 @@ func (h *Handlers) BusinessSignUp(c echo.Context) error {
 		})
 	}
 	return c.JSON(200, models.CompanySignUpResponse{
-		Token:     token,
-		CompanyID: company.CompanyID,
+		Token:        tokens.Token,
+		RefreshToken: tokens.RefreshToken,
+		CompanyID:    company.CompanyID,
 	})
 }
 func (h *Handlers) BusinessSignIn(c echo.Context) error {
@@ -266,18 +610,16 @@ func (h *Handlers) BusinessSignIn(c echo.Context) error {
 			"message": "Ошибка в данных запроса.",
 		})
 	}
+	if err := h.checkSignIn(c, "business", body.Email); err != nil {
+		return err
+	}
 	company := models.Company{
 		Email: body.Email,
 	}
 	cmp, err := h.service.CompanySignIn(c.Request().Context(), company)
-	if err != nil {
-		h.Error(c.Request().Context(), "", zap.Error(err))
-		return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
-			"status":  "error",
-			"message": "Неверный email или пароль.",
-		})
+	if err == service.ErrEmailNotRegistrated {
+		return h.memberSignIn(c, body)
 	}
-	decryptedPassword, err := utils.Decrypt(cmp.Password, h.CryptoKey)
 	if err != nil {
 		h.Error(c.Request().Context(), "", zap.Error(err))
 		return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
@@ -285,14 +627,22 @@ func (h *Handlers) BusinessSignIn(c echo.Context) error {
 			"message": "Неверный email или пароль.",
 		})
 	}
-	if !bytes.Equal(decryptedPassword, []byte(body.Password)) {
-		h.Error(c.Request().Context(), "password not match", zap.Binary("decrypted", decryptedPassword), zap.Binary("body password", []byte(body.Password)))
+	ok, needsRehash := utils.CheckPassword(cmp.Password, body.Password, h.CryptoKey)
+	if !ok {
+		h.Error(c.Request().Context(), "password not match", zap.String("company_id", cmp.CompanyID))
+		h.signInFailed(c, "business", body.Email)
 		return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
 			"status":  "error",
 			"message": "Неверный email или пароль.",
 		})
 	}
-	token, err := utils.CreateToken(cmp.CompanyID, "company", h.SigningKey)
+	if needsRehash {
+		if err := h.upgradeCompanyPassword(c.Request().Context(), cmp, body.Password); err != nil {
+			h.Error(c.Request().Context(), "failed upgrade password hash", zap.Error(err))
+		}
+	}
+	h.signInSucceeded(c, "business", body.Email)
+	enabled, err := h.service.TwoFactorEnabled(c.Request().Context(), cmp.CompanyID, "company")
 	if err != nil {
 		h.Error(c.Request().Context(), "", zap.Error(err))
 		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
@@ -300,7 +650,10 @@ func (h *Handlers) BusinessSignIn(c echo.Context) error {
 			"message": "Ошибка в данных запроса.",
 		})
 	}
-	err = h.service.UpdateToken(c.Request().Context(), cmp.CompanyID, token)
+	if enabled {
+		return h.twoFactorChallenge(c, cmp.CompanyID, "company")
+	}
+	tokens, err := h.startSession(c, utils.JWTClaims{ID: cmp.CompanyID, Role: "company"})
 	if err != nil {
 		h.Error(c.Request().Context(), "", zap.Error(err))
 		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
@@ -309,10 +662,136 @@ func (h *Handlers) BusinessSignIn(c echo.Context) error {
 		})
 	}
 	return c.JSON(200, models.CompanySignInResponse{
-		Token: token,
+		Token:        tokens.Token,
+		RefreshToken: tokens.RefreshToken,
 	})
 }
 
+// upgradeCompanyPassword replaces a legacy or outdated password record with a
+// fresh hash. Sign-in has already succeeded, so failures are only logged.
+func (h *Handlers) upgradeCompanyPassword(ctx context.Context, cmp *models.Company, password string) error {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	cmp.Password = hashedPassword
+	return h.service.UpdateCompanyPassword(ctx, *cmp)
+}
+
+func apiKeyResponse(key models.APIKey) models.APIKeyResponse {
+	resp := models.APIKeyResponse{
+		ID:        key.ID,
+		Name:      key.Name,
+		Prefix:    utils.APIKeyPrefix + key.Prefix,
+		Scopes:    key.Scopes,
+		CreatedAt: time.Unix(key.CreatedAt, 0).UTC().Format(time.RFC3339),
+	}
+	if key.LastUsedAt != nil {
+		lastUsed := time.Unix(*key.LastUsedAt, 0).UTC().Format(time.RFC3339)
+		resp.LastUsedAt = &lastUsed
+	}
+	return resp
+}
+func (h *Handlers) BussinessCreateAPIKey(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+
+	var req models.CreateAPIKeyRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	secret, prefix, hash, err := utils.NewAPIKey()
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	key := models.APIKey{
+		ID:        uuid.NewString(),
+		CompanyID: user.ID,
+		Name:      *req.Name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    req.Scopes,
+		CreatedAt: time.Now().Unix(),
+	}
+	err = h.service.CreateAPIKey(c.Request().Context(), key)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	h.audit(c, "api_key.create", key.ID)
+	resp := apiKeyResponse(key)
+	resp.Key = secret
+	return c.JSON(201, resp)
+}
+func (h *Handlers) BussinessGetAPIKeys(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+
+	keys, err := h.service.GetAPIKeys(c.Request().Context(), user.ID)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	resp := make([]models.APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		resp = append(resp, apiKeyResponse(key))
+	}
+	return c.JSON(200, resp)
+}
+func (h *Handlers) BussinessRevokeAPIKey(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+
+	var req models.APIKeyRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	err := h.service.RevokeAPIKey(c.Request().Context(), *req.ID, user.ID)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		if err == service.ErrAPIKeyNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, echo.Map{
+				"status":  "error",
+				"message": "Ключ не найден.",
+			})
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	h.audit(c, "api_key.revoke", *req.ID)
+	return c.JSON(200, echo.Map{"status": "ok"})
+}
 func (h *Handlers) BussinessCreatePromo(c echo.Context) error {
 
 	user := c.Get("user").(*utils.JWTClaims)
@@ -354,9 +833,27 @@ func (h *Handlers) BussinessCreatePromo(c echo.Context) error {
 				"message": "Ошибка в данных запроса.",
 			})
 		}
+		if (len(body.PromoUnique) == 0) == (body.Generate == nil) {
+			h.Error(c.Request().Context(), "either promo_unique or generate is required")
+			return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+				"status":  "error",
+				"message": "Ошибка в данных запроса.",
+			})
+		}
+	}
+	var pattern utils.CodePattern
+	if body.Generate != nil {
+		var err error
+		if pattern, err = codePattern(*body.Generate); err != nil {
+			h.Error(c.Request().Context(), "", zap.Error(err))
+			return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+				"status":  "error",
+				"message": "Ошибка в данных запроса.",
+			})
+		}
 	}
 	if *body.Mode == "COMMON" {
-		if body.PromoUnique != nil {
+		if body.PromoUnique != nil || body.Generate != nil {
 			h.Error(c.Request().Context(), "invalid promo mode")
 			return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
 				"status":  "error",
@@ -364,30 +861,49 @@ func (h *Handlers) BussinessCreatePromo(c echo.Context) error {
 			})
 		}
 	}
-	if body.Target.AgeFrom != nil && body.Target.AgeUntil != nil {
-		if *body.Target.AgeFrom > *body.Target.AgeUntil {
-			h.Error(c.Request().Context(), "invalid from age")
+	var publishAt *int64
+	if body.Publish || body.PublishAt != nil {
+		if body.Publish && body.PublishAt != nil {
+			h.Error(c.Request().Context(), "either publish or publish_at is allowed")
 			return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
 				"status":  "error",
 				"message": "Ошибка в данных запроса.",
 			})
 		}
+		publishNow := time.Now().Unix()
+		publishAt = &publishNow
+		at, err := h.parsePublishAt(c, body.PublishAt)
+		if err != nil {
+			return err
+		}
+		if at != nil && *at > publishNow {
+			publishAt = at
+		}
 	}
-	var tFrom, tUntil int64
-	if body.ActiveUntil != nil {
-		now := time.Now().UTC().Add(3 * time.Hour)
-		if t, _ := time.Parse(utils.TimeFormat, *body.ActiveUntil); t.Day() < now.Day() && t.Year() < now.Year() && t.Month() < now.Month() {
-			h.Error(c.Request().Context(), "promocode expired")
+	if body.Target.AgeFrom != nil && body.Target.AgeUntil != nil {
+		if *body.Target.AgeFrom > *body.Target.AgeUntil {
+			h.Error(c.Request().Context(), "invalid from age")
 			return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
 				"status":  "error",
 				"message": "Ошибка в данных запроса.",
 			})
-		} else {
-			tUntil = t.Unix()
 		}
 	}
+	loc, err := h.service.CompanyLocation(c.Request().Context(), user.ID)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	var tFrom, tUntil int64
+	if body.ActiveUntil != nil {
+		t, _ := utils.ParseDate(*body.ActiveUntil, loc, true)
+		tUntil = t.Unix()
+	}
 	if body.ActiveFrom != nil {
-		t, _ := time.Parse(utils.TimeFormat, *body.ActiveFrom)
+		t, _ := utils.ParseDate(*body.ActiveFrom, loc, false)
 		tFrom = t.Unix()
 
 	}
@@ -406,7 +922,7 @@ func (h *Handlers) BussinessCreatePromo(c echo.Context) error {
 	// }
 	promoID := uuid.NewString()
 	likeCount, usedCount := 0, 0
-	now := time.Now().UTC().Add(3 * time.Hour).Unix()
+	now := time.Now().Unix()
 	until, from := true, true
 	if tUntil != 0 {
 		until = tUntil >= now
@@ -428,6 +944,16 @@ func (h *Handlers) BussinessCreatePromo(c echo.Context) error {
 		LikeCount:   &likeCount,
 		UsedCount:   &usedCount,
 		Active:      &active,
+		PublishAt:   publishAt,
+	}
+	if body.ActivationPolicy != nil && !body.ActivationPolicy.Empty() {
+		promo.ActivationPolicy = body.ActivationPolicy
+	}
+	if body.Schedule != nil && !body.Schedule.Empty() {
+		promo.Schedule = body.Schedule
+	}
+	if body.Benefit != nil && !body.Benefit.Empty() {
+		promo.Benefit = body.Benefit
 	}
 	if tFrom == 0 {
 		promo.ActiveFrom = nil
@@ -442,17 +968,23 @@ func (h *Handlers) BussinessCreatePromo(c echo.Context) error {
 	if len(promo.Target.Categories) == 0 {
 		promo.Target.Categories = nil
 	}
-	err := h.service.CreatePromo(c.Request().Context(), &promo)
+	count := 0
+	if body.Generate != nil {
+		count = *body.Generate.Count
+	}
+	job, err := h.service.CreatePromo(c.Request().Context(), &promo, pattern, count)
 	if err != nil {
-		h.Error(c.Request().Context(), "", zap.Error(err))
-		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
-			"status":  "error",
-			"message": "Ошибка в данных запроса.",
-		})
+		return h.generationError(c, err)
 	}
-	return c.JSON(201, models.CreatePromoResponse{
+	h.audit(c, "promo.create", *promo.PromoId)
+	res := models.CreatePromoResponse{
 		PromoId: *promo.PromoId,
-	})
+	}
+	if job != nil {
+		jobRes := generationJobResponse(*job)
+		res.GenerationJob = &jobRes
+	}
+	return c.JSON(201, res)
 }
 func (h *Handlers) BussinessGetPromos(c echo.Context) error {
 	user := c.Get("user").(*utils.JWTClaims)
@@ -488,6 +1020,9 @@ func (h *Handlers) BussinessGetPromos(c echo.Context) error {
 	if req.SortBy != nil {
 		baseSort.SortBy = *req.SortBy
 	}
+	if req.State != nil {
+		baseSort.State = *req.State
+	}
 	if req.Countries != nil {
 		baseSort.Countries = make([]string, 0)
 		for _, contry := range req.Countries {
@@ -567,14 +1102,22 @@ func (h *Handlers) BussinessEditPromo(c echo.Context) error {
 		})
 	}
 	h.Info(c.Request().Context(), "", zap.Any("req", req))
+	loc, err := h.service.CompanyLocation(c.Request().Context(), user.ID)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
 	var tFrom, tUntil int64
 	if req.ActiveFrom != nil {
-		t, _ := time.Parse(utils.TimeFormat, *req.ActiveFrom)
+		t, _ := utils.ParseDate(*req.ActiveFrom, loc, false)
 		tFrom = t.Unix()
 	}
 	if req.ActiveUntil != nil {
 
-		t, _ := time.Parse(utils.TimeFormat, *req.ActiveUntil)
+		t, _ := utils.ParseDate(*req.ActiveUntil, loc, true)
 		tUntil = t.Unix()
 	}
 	if req.ActiveFrom != nil && req.ActiveUntil != nil {
@@ -607,6 +1150,9 @@ func (h *Handlers) BussinessEditPromo(c echo.Context) error {
 		Target:      req.Target,
 		MaxCount:    req.MaxCount,
 	}
+	promo.ActivationPolicy = req.ActivationPolicy
+	promo.Schedule = req.Schedule
+	promo.Benefit = req.Benefit
 	if tFrom == 0 {
 		promo.ActiveFrom = nil
 	} else {
@@ -638,8 +1184,48 @@ func (h *Handlers) BussinessEditPromo(c echo.Context) error {
 			"message": "Ошибка в данных запроса.",
 		})
 	}
+	h.audit(c, "promo.update", *req.ID)
 	return c.JSON(200, edited)
 }
+func (h *Handlers) BussinessGetPromoCode(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	var req models.GetPromoCodeRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	code, err := h.service.GetPromoCode(c.Request().Context(), user.ID, *req.PromoID, *req.Code)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		if err == service.ErrNoPermission {
+			return echo.NewHTTPError(http.StatusForbidden, echo.Map{
+				"status":  "error",
+				"message": "Промокод не принадлежит этой компании.",
+			})
+		}
+		if err == service.ErrPromoNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, echo.Map{
+				"status":  "error",
+				"message": "Промокод не найден.",
+			})
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	return c.JSON(200, code)
+}
 func (h *Handlers) BussinessStatPromo(c echo.Context) error {
 	user := c.Get("user").(*utils.JWTClaims)
 	var req models.GetPromoStatRequest
@@ -706,7 +1292,7 @@ func (h *Handlers) UserSignUp(c echo.Context) error {
 			"message": "Ошибка в данных запроса.",
 		})
 	}
-	hashedPassword, err := utils.Encrypt([]byte(*req.Password), h.CryptoKey)
+	hashedPassword, err := utils.HashPassword(*req.Password)
 	if err != nil {
 		h.Error(c.Request().Context(), "", zap.Error(err))
 		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
@@ -738,15 +1324,10 @@ func (h *Handlers) UserSignUp(c echo.Context) error {
 			"message": "Ошибка в данных запроса.",
 		})
 	}
-	token, err := utils.CreateToken(*user.ID, "user", h.SigningKey)
-	if err != nil {
-		h.Error(c.Request().Context(), "", zap.Error(err))
-		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
-			"status":  "error",
-			"message": "Ошибка в данных запроса.",
-		})
+	if err := h.sendVerificationEmail(c.Request().Context(), *user.ID, "user", *user.Email); err != nil {
+		h.Error(c.Request().Context(), "failed send verification email", zap.Error(err))
 	}
-	err = h.service.UpdateToken(c.Request().Context(), *user.ID, token)
+	tokens, err := h.startSession(c, utils.JWTClaims{ID: *user.ID, Role: "user"})
 	if err != nil {
 		h.Error(c.Request().Context(), "", zap.Error(err))
 		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
@@ -755,7 +1336,8 @@ func (h *Handlers) UserSignUp(c echo.Context) error {
 		})
 	}
 	return c.JSON(200, models.SignUpUserResp{
-		Token: token,
+		Token:        tokens.Token,
+		RefreshToken: tokens.RefreshToken,
 	})
 }
 func (h *Handlers) UserSignIn(c echo.Context) error {
@@ -782,41 +1364,37 @@ func (h *Handlers) UserSignIn(c echo.Context) error {
 			"message": "Ошибка в данных запроса.",
 		})
 	}
+	if err := h.checkSignIn(c, "user", *req.Email); err != nil {
+		return err
+	}
 	company := models.User{
 		Email: req.Email,
 	}
 	usr, err := h.service.UserSignIn(c.Request().Context(), company)
 	if err != nil {
 		h.Error(c.Request().Context(), "", zap.Error(err))
+		h.signInFailed(c, "user", *req.Email)
 		return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
 			"status":  "error",
 			"message": "Неверный email или пароль.",
 		})
 	}
-	decryptedPassword, err := utils.Decrypt(usr.Password, h.CryptoKey)
-	if err != nil {
-		h.Error(c.Request().Context(), "", zap.Error(err))
-		return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
-			"status":  "error",
-			"message": "Неверный email или пароль.",
-		})
-	}
-	if !bytes.Equal(decryptedPassword, []byte(*req.Password)) {
-		h.Error(c.Request().Context(), "password not match", zap.Binary("decrypted", decryptedPassword), zap.Binary("body password", []byte(*req.Password)))
+	ok, needsRehash := utils.CheckPassword(usr.Password, *req.Password, h.CryptoKey)
+	if !ok {
+		h.Error(c.Request().Context(), "password not match", zap.String("user_id", *usr.ID))
+		h.signInFailed(c, "user", *req.Email)
 		return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
 			"status":  "error",
 			"message": "Неверный email или пароль.",
 		})
 	}
-	token, err := utils.CreateToken(*usr.ID, "user", h.SigningKey)
-	if err != nil {
-		h.Error(c.Request().Context(), "", zap.Error(err))
-		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
-			"status":  "error",
-			"message": "Ошибка в данных запроса.",
-		})
+	if needsRehash {
+		if err := h.upgradeUserPassword(c.Request().Context(), usr, *req.Password); err != nil {
+			h.Error(c.Request().Context(), "failed upgrade password hash", zap.Error(err))
+		}
 	}
-	err = h.service.UpdateToken(c.Request().Context(), *usr.ID, token)
+	h.signInSucceeded(c, "user", *req.Email)
+	tokens, err := h.startSession(c, utils.JWTClaims{ID: *usr.ID, Role: "user"})
 	if err != nil {
 		h.Error(c.Request().Context(), "", zap.Error(err))
 		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
@@ -825,9 +1403,18 @@ func (h *Handlers) UserSignIn(c echo.Context) error {
 		})
 	}
 	return c.JSON(200, models.CompanySignInResponse{
-		Token: token,
+		Token:        tokens.Token,
+		RefreshToken: tokens.RefreshToken,
 	})
 }
+func (h *Handlers) upgradeUserPassword(ctx context.Context, usr *models.User, password string) error {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = h.service.UpdateUser(ctx, &models.User{ID: usr.ID, Password: hashedPassword})
+	return err
+}
 func (h *Handlers) FeedUser(c echo.Context) error {
 	user := c.Get("user").(*utils.JWTClaims)
 	baseSort := models.UserSort{
@@ -928,7 +1515,7 @@ func (h *Handlers) UpdateUser(c echo.Context) error {
 	}
 	if req.Password != nil {
 
-		hashedPassword, err := utils.Encrypt([]byte(*req.Password), h.CryptoKey)
+		hashedPassword, err := utils.HashPassword(*req.Password)
 		if err != nil {
 			h.Error(c.Request().Context(), "", zap.Error(err))
 			return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
@@ -1065,7 +1652,7 @@ func (h *Handlers) UserCreateComment(c echo.Context) error {
 		})
 	}
 	req.UserID = &user.ID
-	now := time.Now().UTC().Add(3 * time.Hour).Format(time.RFC3339)
+	now := time.Now().UTC().Format(time.RFC3339)
 	req.Date = &now
 	id := uuid.NewString()
 	req.CommentId = &id
@@ -1277,7 +1864,20 @@ func (h *Handlers) UserActivate(c echo.Context) error {
 			"message": "Ошибка в данных запроса.",
 		})
 	}
-	value, err := h.service.CheckCache(c.Request().Context(), user.ID)
+	if err := h.checkAntifraud(c, user.ID, *req.PromoID); err != nil {
+		return err
+	}
+	promo, promoErr := h.service.UserActivatePromo(c.Request().Context(), req)
+	if promoErr != nil {
+		return h.activationError(c, promoErr)
+	}
+	return c.JSON(200, echo.Map{"promo": promo})
+}
+
+// checkAntifraud asks the antifraud service, or its cached verdict, whether the
+// user may redeem the promo.
+func (h *Handlers) checkAntifraud(c echo.Context, userID, promoID string) error {
+	value, err := h.service.CheckCache(c.Request().Context(), userID)
 	if err != nil && err != redis.Nil {
 		h.Error(c.Request().Context(), "", zap.Error(err))
 		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
@@ -1288,7 +1888,7 @@ func (h *Handlers) UserActivate(c echo.Context) error {
 	ok := false
 	if err == redis.Nil {
 		var fraudResp models.AntifraudResponse
-		usr, err := h.service.GetUser(c.Request().Context(), models.User{ID: &user.ID})
+		usr, err := h.service.GetUser(c.Request().Context(), models.User{ID: &userID})
 		if err != nil {
 			h.Error(c.Request().Context(), "", zap.Error(err))
 			return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
@@ -1298,7 +1898,7 @@ func (h *Handlers) UserActivate(c echo.Context) error {
 		}
 		body := models.AntifraudRequest{
 			UserEmail: *usr.Email,
-			PromoId:   *req.PromoID,
+			PromoId:   promoID,
 		}
 		for range 2 {
 			data, err := json.Marshal(body)
@@ -1351,7 +1951,7 @@ func (h *Handlers) UserActivate(c echo.Context) error {
 				}
 				value = fraudResp.Ok
 				if fraudResp.CacheUntil != "" {
-					err = h.service.Cache(c.Request().Context(), user.ID, fraudResp.CacheUntil, fraudResp.Ok)
+					err = h.service.Cache(c.Request().Context(), userID, fraudResp.CacheUntil, fraudResp.Ok)
 					if err != nil {
 						h.Error(c.Request().Context(), "", zap.Error(err))
 						return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
@@ -1378,27 +1978,7 @@ func (h *Handlers) UserActivate(c echo.Context) error {
 			"message": "Вы не можете использовать этот промокод.",
 		})
 	}
-	promo, promoErr := h.service.UserActivatePromo(c.Request().Context(), req)
-	if promoErr != nil {
-		h.Error(c.Request().Context(), "", zap.Error(promoErr))
-		if err == service.ErrPromoNotFound {
-			return echo.NewHTTPError(http.StatusNotFound, echo.Map{
-				"status":  "error",
-				"message": "Промокод не найден.",
-			})
-		}
-		if promoErr == service.ErrNoPermission {
-			return echo.NewHTTPError(http.StatusForbidden, echo.Map{
-				"status":  "error",
-				"message": "Вы не можете использовать этот промокод.",
-			})
-		}
-		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
-			"status":  "error",
-			"message": "Ошибка в данных запроса.",
-		})
-	}
-	return c.JSON(200, echo.Map{"promo": promo})
+	return nil
 }
 func (h *Handlers) UserHistory(c echo.Context) error {
 	user := c.Get("user").(*utils.JWTClaims)
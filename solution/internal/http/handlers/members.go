@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"solution/internal/models"
+	"solution/internal/service"
+	"solution/internal/utils"
+	"solution/pkg/mailer"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const inviteTTL = 7 * 24 * time.Hour
+
+// audit records who performed a company action. The action has already
+// happened, so failures are only logged.
+func (h *Handlers) audit(c echo.Context, action, targetID string) {
+	user := c.Get("user").(*utils.JWTClaims)
+	record := models.AuditRecord{
+		CompanyID: user.ID,
+		Action:    action,
+		CreatedAt: time.Now().Unix(),
+	}
+	if user.MemberID != "" {
+		record.MemberID = &user.MemberID
+	}
+	if user.APIKeyID != "" {
+		record.APIKeyID = &user.APIKeyID
+	}
+	if targetID != "" {
+		record.TargetID = &targetID
+	}
+	if err := h.service.AddAuditRecord(c.Request().Context(), record); err != nil {
+		h.Error(c.Request().Context(), "failed write audit record", zap.Error(err), zap.String("action", action))
+	}
+}
+func memberResponse(member models.CompanyMember) models.MemberResponse {
+	resp := models.MemberResponse{
+		ID:        member.ID,
+		Email:     member.Email,
+		Name:      member.Name,
+		Role:      member.Role,
+		Status:    models.MemberInvited,
+		InvitedAt: time.Unix(member.CreatedAt, 0).UTC().Format(time.RFC3339),
+	}
+	if member.JoinedAt != nil {
+		joined := time.Unix(*member.JoinedAt, 0).UTC().Format(time.RFC3339)
+		resp.Status = models.MemberActive
+		resp.JoinedAt = &joined
+	}
+	return resp
+}
+func (h *Handlers) memberSignIn(c echo.Context, body models.CompanySignInRequest) error {
+	member, err := h.service.MemberSignIn(c.Request().Context(), body.Email)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		h.signInFailed(c, "business", body.Email)
+		return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
+			"status":  "error",
+			"message": "Неверный email или пароль.",
+		})
+	}
+	if ok, _ := utils.CheckPassword(member.Password, body.Password, nil); !ok {
+		h.Error(c.Request().Context(), "password not match", zap.String("member_id", member.ID))
+		h.signInFailed(c, "business", body.Email)
+		return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
+			"status":  "error",
+			"message": "Неверный email или пароль.",
+		})
+	}
+	h.signInSucceeded(c, "business", body.Email)
+	enabled, err := h.service.TwoFactorEnabled(c.Request().Context(), member.ID, "member")
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if enabled {
+		return h.twoFactorChallenge(c, member.ID, "member")
+	}
+	return h.memberSession(c, member)
+}
+func (h *Handlers) memberSession(c echo.Context, member *models.CompanyMember) error {
+	tokens, err := h.startSession(c, utils.JWTClaims{
+		ID:         member.CompanyID,
+		Role:       "company",
+		MemberID:   member.ID,
+		MemberRole: member.Role,
+	})
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	return c.JSON(200, models.CompanySignInResponse{
+		Token:        tokens.Token,
+		RefreshToken: tokens.RefreshToken,
+	})
+}
+func (h *Handlers) BussinessInviteMember(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+
+	var req models.InviteMemberRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	inviteToken, inviteHash, err := utils.NewSecretToken()
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	now := time.Now()
+	expiresAt := now.Add(inviteTTL).Unix()
+	member := models.CompanyMember{
+		ID:              uuid.NewString(),
+		CompanyID:       user.ID,
+		Email:           *req.Email,
+		Name:            *req.Name,
+		Role:            *req.Role,
+		InviteHash:      inviteHash,
+		InviteExpiresAt: &expiresAt,
+		CreatedAt:       now.Unix(),
+	}
+	if user.MemberID != "" {
+		member.InvitedBy = &user.MemberID
+	}
+	err = h.service.InviteMember(c.Request().Context(), member)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		if err == service.ErrEmailRegistrated {
+			return echo.NewHTTPError(409, echo.Map{
+				"status":  "error",
+				"message": "Такой email уже зарегистрирован.",
+			})
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	h.audit(c, "member.invite", member.ID)
+	h.sendMail(mailer.Message{
+		To:      member.Email,
+		Subject: "Приглашение в команду",
+		Body: fmt.Sprintf("Вас пригласили в команду компании. Чтобы присоединиться, перейдите по ссылке:\n%s/accept-invite?token=%s\n\nПриглашение действует 7 дней.",
+			h.PublicURL, inviteToken),
+	})
+	resp := memberResponse(member)
+	resp.InviteToken = inviteToken
+	return c.JSON(201, resp)
+}
+func (h *Handlers) BusinessAcceptInvite(c echo.Context) error {
+	var req models.AcceptInviteRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	hashedPassword, err := utils.HashPassword(*req.Password)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	member, err := h.service.AcceptInvite(c.Request().Context(), utils.HashSecretToken(*req.InviteToken), hashedPassword)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		if err == service.ErrInviteInvalid {
+			return echo.NewHTTPError(http.StatusNotFound, echo.Map{
+				"status":  "error",
+				"message": "Приглашение не найдено или истекло.",
+			})
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	return h.memberSession(c, member)
+}
+func (h *Handlers) BussinessGetMembers(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+
+	members, err := h.service.GetMembers(c.Request().Context(), user.ID)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	resp := make([]models.MemberResponse, 0, len(members))
+	for _, member := range members {
+		resp = append(resp, memberResponse(member))
+	}
+	return c.JSON(200, resp)
+}
+func (h *Handlers) BussinessUpdateMember(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+
+	var req models.UpdateMemberRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	member, err := h.service.UpdateMemberRole(c.Request().Context(), user.ID, *req.ID, *req.Role)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		if err == service.ErrMemberNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, echo.Map{
+				"status":  "error",
+				"message": "Участник не найден.",
+			})
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	h.audit(c, "member.update", member.ID)
+	return c.JSON(200, memberResponse(*member))
+}
+func (h *Handlers) BussinessRemoveMember(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+
+	var req models.MemberRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	err := h.service.RemoveMember(c.Request().Context(), user.ID, *req.ID)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		if err == service.ErrMemberNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, echo.Map{
+				"status":  "error",
+				"message": "Участник не найден.",
+			})
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	h.audit(c, "member.remove", *req.ID)
+	return c.JSON(200, echo.Map{"status": "ok"})
+}
+func (h *Handlers) BussinessGetAuditLog(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+
+	var req models.GetAuditLogRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	limit, offset := 10, 0
+	if req.Limit != nil {
+		limit = *req.Limit
+	}
+	if req.Offset != nil {
+		offset = *req.Offset
+	}
+	records, total, err := h.service.GetAuditLog(c.Request().Context(), user.ID, limit, offset)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	resp := make([]models.AuditRecordResponse, 0, len(records))
+	for _, record := range records {
+		resp = append(resp, models.AuditRecordResponse{
+			ID:        record.ID,
+			MemberID:  record.MemberID,
+			APIKeyID:  record.APIKeyID,
+			Action:    record.Action,
+			TargetID:  record.TargetID,
+			CreatedAt: time.Unix(record.CreatedAt, 0).UTC().Format(time.RFC3339),
+		})
+	}
+	c.Response().Header().Add("X-Total-Count", fmt.Sprintf("%d", total))
+	return c.JSON(200, resp)
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"solution/internal/models"
+	"solution/internal/service"
+	"solution/internal/utils"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func (h *Handlers) bindPromoID(c echo.Context) (string, error) {
+	var req models.GetPromoRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return "", echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return "", echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	return *req.ID, nil
+}
+func (h *Handlers) BussinessArchivePromo(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	promoID, err := h.bindPromoID(c)
+	if err != nil {
+		return err
+	}
+	promo, err := h.service.ArchivePromo(c.Request().Context(), user.ID, promoID)
+	if err != nil {
+		return h.generationError(c, err)
+	}
+	h.audit(c, "promo.archive", promoID)
+	return c.JSON(200, promo)
+}
+func (h *Handlers) BussinessUnarchivePromo(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	promoID, err := h.bindPromoID(c)
+	if err != nil {
+		return err
+	}
+	promo, err := h.service.UnarchivePromo(c.Request().Context(), user.ID, promoID)
+	if err != nil {
+		return h.generationError(c, err)
+	}
+	h.audit(c, "promo.unarchive", promoID)
+	return c.JSON(200, promo)
+}
+func (h *Handlers) BussinessDeletePromo(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	promoID, err := h.bindPromoID(c)
+	if err != nil {
+		return err
+	}
+	err = h.service.DeletePromo(c.Request().Context(), user.ID, promoID)
+	if err == service.ErrPromoActivated {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusConflict, echo.Map{
+			"status":  "error",
+			"message": "Промокод уже активировали, сначала переместите его в архив.",
+		})
+	}
+	if err != nil {
+		return h.generationError(c, err)
+	}
+	h.audit(c, "promo.delete", promoID)
+	return c.JSON(200, echo.Map{"status": "ok"})
+}
+func (h *Handlers) BussinessPublishPromo(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	var req models.PublishPromoRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	publishAt, err := h.parsePublishAt(c, req.PublishAt)
+	if err != nil {
+		return err
+	}
+	promo, err := h.service.PublishPromo(c.Request().Context(), user.ID, *req.PromoID, publishAt)
+	if err != nil {
+		return h.publicationError(c, err)
+	}
+	h.audit(c, "promo.publish", *req.PromoID)
+	return c.JSON(200, promo)
+}
+
+// parsePublishAt reads an optional RFC 3339 publish_at, nil when it is not
+// given.
+func (h *Handlers) parsePublishAt(c echo.Context, value *string) (*int64, error) {
+	if value == nil {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, *value)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return nil, echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	ts := t.Unix()
+	return &ts, nil
+}
+func (h *Handlers) BussinessUnpublishPromo(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	promoID, err := h.bindPromoID(c)
+	if err != nil {
+		return err
+	}
+	promo, err := h.service.UnpublishPromo(c.Request().Context(), user.ID, promoID)
+	if err != nil {
+		return h.publicationError(c, err)
+	}
+	h.audit(c, "promo.unpublish", promoID)
+	return c.JSON(200, promo)
+}
+func (h *Handlers) publicationError(c echo.Context, err error) error {
+	if err == service.ErrPromoPublished {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusConflict, echo.Map{
+			"status":  "error",
+			"message": "Промокод уже опубликован.",
+		})
+	}
+	return h.generationError(c, err)
+}
+func (h *Handlers) BussinessGetPromoTransitions(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	var req models.GetPromoTransitionsRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	limit, offset := 10, 0
+	if req.Limit != nil {
+		limit = *req.Limit
+	}
+	if req.Offset != nil {
+		offset = *req.Offset
+	}
+	transitions, total, err := h.service.GetPromoTransitions(c.Request().Context(), user.ID, *req.PromoID, limit, offset)
+	if err != nil {
+		return h.generationError(c, err)
+	}
+	resp := make([]models.PromoTransitionResponse, 0, len(transitions))
+	for _, transition := range transitions {
+		resp = append(resp, models.PromoTransitionResponse{
+			Active:    transition.Active,
+			Reason:    transition.Reason,
+			CreatedAt: time.Unix(transition.CreatedAt, 0).UTC().Format(time.RFC3339),
+		})
+	}
+	c.Response().Header().Add("X-Total-Count", fmt.Sprintf("%d", total))
+	return c.JSON(200, resp)
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"solution/internal/models"
+	"solution/internal/service"
+	"solution/internal/utils"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func (h *Handlers) UserQuote(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	var req models.QuoteRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	req.UserID = user.ID
+	quote, err := h.service.Quote(c.Request().Context(), req)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		if err == service.ErrQuoteTotalMismatch {
+			return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+				"status":  "error",
+				"message": "Сумма корзины не совпадает с её позициями.",
+			})
+		}
+		if err == service.ErrNoPermission {
+			return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
+				"status":  "error",
+				"message": "Пользователь не авторизован.",
+			})
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	return c.JSON(200, quote)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"solution/internal/models"
+	"solution/internal/service"
+	"solution/internal/utils"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+var redemptionDeniedMessages = map[string]string{
+	models.RedemptionNotIssued:   "Код ещё не выдан пользователю.",
+	models.RedemptionRedeemed:    "Код уже погашен.",
+	models.RedemptionInvalidated: "Код аннулирован.",
+	models.RedemptionExpired:     "Срок действия промокода истёк.",
+	models.RedemptionArchived:    "Промокод перемещён в архив.",
+}
+
+func codeLookupResponse(lookup *models.CodeLookup, now int64) models.CodeLookupResponse {
+	reason := lookup.DenyReason(now)
+	resp := models.CodeLookupResponse{
+		Code:          lookup.Code,
+		Valid:         reason == "",
+		Reason:        reason,
+		PromoID:       lookup.PromoID,
+		Mode:          lookup.Mode,
+		Description:   lookup.Description,
+		UserID:        lookup.UserID,
+		IssuedCount:   lookup.IssuedCount,
+		RedeemedCount: lookup.RedeemedCount,
+		Redeemed:      lookup.IssuedCount > 0 && lookup.RedeemedCount >= lookup.IssuedCount,
+	}
+	if lookup.IssuedAt != nil {
+		resp.IssuedAt = time.Unix(*lookup.IssuedAt, 0).UTC().Format(time.RFC3339)
+	}
+	if last := lookup.LastRedemption; last != nil {
+		resp.Redemption = &models.RedemptionResponse{
+			StoreID:    last.StoreID,
+			TerminalID: last.TerminalID,
+			RedeemedAt: time.Unix(last.RedeemedAt, 0).UTC().Format(time.RFC3339),
+		}
+	}
+	return resp
+}
+func (h *Handlers) BussinessLookupCode(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	var req models.CodeLookupRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	lookup, err := h.service.LookupCode(c.Request().Context(), user.ID, *req.Code)
+	if err != nil {
+		return h.redemptionError(c, err)
+	}
+	return c.JSON(200, codeLookupResponse(lookup, time.Now().Unix()))
+}
+func (h *Handlers) BussinessRedeemCode(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	var req models.RedeemCodeRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	lookup, err := h.service.RedeemCode(c.Request().Context(), user.ID, req)
+	if err != nil {
+		return h.redemptionError(c, err)
+	}
+	h.audit(c, "code.redeem", lookup.PromoID)
+	return c.JSON(200, codeLookupResponse(lookup, time.Now().Unix()))
+}
+func (h *Handlers) redemptionError(c echo.Context, err error) error {
+	h.Error(c.Request().Context(), "", zap.Error(err))
+	var denied *service.RedemptionDeniedError
+	if errors.As(err, &denied) {
+		return echo.NewHTTPError(http.StatusConflict, echo.Map{
+			"status":  "error",
+			"message": redemptionDeniedMessages[denied.Reason],
+			"reason":  denied.Reason,
+		})
+	}
+	if err == service.ErrPromoNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, echo.Map{
+			"status":  "error",
+			"message": "Промокод не найден.",
+		})
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+		"status":  "error",
+		"message": "Ошибка в данных запроса.",
+	})
+}
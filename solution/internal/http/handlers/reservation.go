@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"net/http"
+	"solution/internal/models"
+	"solution/internal/service"
+	"solution/internal/utils"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func reservationResponse(reservation *models.Reservation) models.ReservationResponse {
+	return models.ReservationResponse{
+		ReservationID: reservation.ID,
+		PromoID:       reservation.PromoID,
+		Promo:         reservation.Code,
+		Status:        reservation.Status,
+		ExpiresAt:     time.Unix(reservation.ExpiresAt, 0).UTC().Format(time.RFC3339),
+	}
+}
+func (h *Handlers) UserReservePromo(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	var req models.ActivateRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	req.UserID = &user.ID
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.checkAntifraud(c, user.ID, *req.PromoID); err != nil {
+		return err
+	}
+	reservation, err := h.service.ReserveActivation(c.Request().Context(), req, h.ReservationTTL)
+	if err != nil {
+		return h.activationError(c, err)
+	}
+	return c.JSON(200, reservationResponse(reservation))
+}
+func (h *Handlers) UserConfirmReservation(c echo.Context) error {
+	req, err := h.bindReservation(c)
+	if err != nil {
+		return err
+	}
+	reservation, err := h.service.ConfirmReservation(c.Request().Context(), *req)
+	if err != nil {
+		return h.reservationError(c, err)
+	}
+	return c.JSON(200, reservationResponse(reservation))
+}
+func (h *Handlers) UserReleaseReservation(c echo.Context) error {
+	req, err := h.bindReservation(c)
+	if err != nil {
+		return err
+	}
+	reservation, err := h.service.ReleaseReservation(c.Request().Context(), *req)
+	if err != nil {
+		return h.reservationError(c, err)
+	}
+	return c.JSON(200, reservationResponse(reservation))
+}
+func (h *Handlers) bindReservation(c echo.Context) (*models.ReservationRequest, error) {
+	user := c.Get("user").(*utils.JWTClaims)
+	var req models.ReservationRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return nil, echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return nil, echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	req.UserID = user.ID
+	return &req, nil
+}
+func (h *Handlers) reservationError(c echo.Context, err error) error {
+	h.Error(c.Request().Context(), "", zap.Error(err))
+	if err == service.ErrReservationNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, echo.Map{
+			"status":  "error",
+			"message": "Бронь не найдена.",
+		})
+	}
+	if err == service.ErrReservationFinished {
+		return echo.NewHTTPError(http.StatusConflict, echo.Map{
+			"status":  "error",
+			"message": "Бронь уже завершена или истекла.",
+		})
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+		"status":  "error",
+		"message": "Ошибка в данных запроса.",
+	})
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"math"
+	"net/http"
+	"solution/internal/models"
+	"solution/internal/service"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// checkSignIn rejects the attempt with 429 while the account or the client
+// address is locked or has to wait after previous failures, and with 503 when
+// the attempts cannot be checked.
+func (h *Handlers) checkSignIn(c echo.Context, scope, email string) error {
+	wait, err := h.service.CheckSignIn(c.Request().Context(), scope, email, c.RealIP())
+	if err == service.ErrAccountLocked || err == service.ErrSignInDelayed {
+		h.Warn(c.Request().Context(), "sign in throttled", zap.String("scope", scope), zap.String("email", email), zap.String("ip", c.RealIP()), zap.Error(err))
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return echo.NewHTTPError(http.StatusTooManyRequests, echo.Map{
+			"status":  "error",
+			"message": "Слишком много попыток входа. Попробуйте позже.",
+		})
+	}
+	if err != nil {
+		// Without the counters every attempt would pass unthrottled.
+		h.Error(c.Request().Context(), "failed check sign in attempts", zap.Error(err))
+		return echo.NewHTTPError(http.StatusServiceUnavailable, echo.Map{
+			"status":  "error",
+			"message": "Вход временно недоступен. Попробуйте позже.",
+		})
+	}
+	return nil
+}
+func (h *Handlers) signInFailed(c echo.Context, scope, email string) {
+	event, err := h.service.SignInFailed(c.Request().Context(), scope, email, c.RealIP())
+	if err != nil {
+		h.Error(c.Request().Context(), "failed count sign in attempt", zap.Error(err))
+	}
+	if event != nil {
+		h.Warn(c.Request().Context(), event.Event, zap.String("scope", scope), zap.String("email", email), zap.String("ip", c.RealIP()))
+	}
+}
+func (h *Handlers) signInSucceeded(c echo.Context, scope, email string) {
+	if err := h.service.SignInSucceeded(c.Request().Context(), scope, email); err != nil {
+		h.Error(c.Request().Context(), "failed reset sign in attempts", zap.Error(err))
+	}
+}
+
+// RequireAdmin guards internal endpoints with the X-Admin-Token header. They
+// are disabled when no admin token is configured.
+func (h *Handlers) RequireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := c.Request().Header.Get("X-Admin-Token")
+		if h.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.AdminToken)) != 1 {
+			h.Error(c.Request().Context(), "admin token not valid")
+			return echo.NewHTTPError(http.StatusForbidden, echo.Map{
+				"status":  "error",
+				"message": "Недостаточно прав.",
+			})
+		}
+		return next(c)
+	}
+}
+func (h *Handlers) UnlockAccount(c echo.Context) error {
+	var req models.UnlockAccountRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	ip := ""
+	if req.IP != nil {
+		ip = *req.IP
+	}
+	err := h.service.UnlockAccount(c.Request().Context(), *req.Scope, *req.Email, ip, c.RealIP())
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	h.Warn(c.Request().Context(), models.SecurityUnlock, zap.String("scope", *req.Scope), zap.String("email", *req.Email), zap.String("ip", ip), zap.String("admin_ip", c.RealIP()))
+	return c.JSON(200, echo.Map{"status": "ok"})
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"net/http"
+	"solution/internal/models"
+	"solution/internal/service"
+	"solution/internal/utils"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	twoFactorSignInTTL = 5 * time.Minute
+	recoveryCodesCount = 10
+)
+
+func (h *Handlers) twoFactorError(c echo.Context, err error) error {
+	h.Error(c.Request().Context(), "", zap.Error(err))
+	switch err {
+	case service.ErrTwoFactorCodeInvalid:
+		return echo.NewHTTPError(http.StatusForbidden, echo.Map{
+			"status":  "error",
+			"message": "Неверный код подтверждения.",
+		})
+	case service.ErrTwoFactorEnabled:
+		return echo.NewHTTPError(http.StatusConflict, echo.Map{
+			"status":  "error",
+			"message": "Двухфакторная аутентификация уже включена.",
+		})
+	case service.ErrTwoFactorNotEnabled:
+		return echo.NewHTTPError(http.StatusConflict, echo.Map{
+			"status":  "error",
+			"message": "Двухфакторная аутентификация не включена.",
+		})
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+		"status":  "error",
+		"message": "Ошибка в данных запроса.",
+	})
+}
+func (h *Handlers) bindTwoFactorCode(c echo.Context) (string, error) {
+	var req models.TwoFactorCodeRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return "", echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return "", echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	return *req.Code, nil
+}
+
+// twoFactorChallenge answers a correct password of an account with 2FA with a
+// short-lived mfa token instead of a session.
+func (h *Handlers) twoFactorChallenge(c echo.Context, accountID, role string) error {
+	token, err := h.issueToken(c.Request().Context(), models.TokenTwoFactor, accountID, role, twoFactorSignInTTL)
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	return c.JSON(200, models.TwoFactorChallengeResponse{MFARequired: true, MFAToken: token})
+}
+func (h *Handlers) BusinessTwoFactorSignIn(c echo.Context) error {
+	var req models.TwoFactorSignInRequest
+	if err := c.Bind(&req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	token, err := h.service.TwoFactorSignIn(c.Request().Context(), utils.HashSecretToken(*req.MFAToken), *req.Code)
+	if err == service.ErrTokenInvalid || err == service.ErrTwoFactorCodeInvalid {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
+			"status":  "error",
+			"message": "Неверный код подтверждения. Войдите заново.",
+		})
+	}
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+	claims := utils.JWTClaims{ID: token.AccountID, Role: "company"}
+	if token.Role == "member" {
+		member, err := h.service.GetMember(c.Request().Context(), token.AccountID)
+		if err != nil {
+			h.Error(c.Request().Context(), "", zap.Error(err))
+			return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
+				"status":  "error",
+				"message": "Неверный email или пароль.",
+			})
+		}
+		claims = utils.JWTClaims{ID: member.CompanyID, Role: "company", MemberID: member.ID, MemberRole: member.Role}
+	}
+	tokens, err := h.startSession(c, claims)
+	if err == nil {
+		err = h.service.ConfirmSession(c.Request().Context(), tokens.SessionID)
+	}
+	if err != nil {
+		h.Error(c.Request().Context(), "", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"message": "Ошибка в данных запроса.",
+		})
+	}
+	return c.JSON(200, models.CompanySignInResponse{
+		Token:        tokens.Token,
+		RefreshToken: tokens.RefreshToken,
+	})
+}
+func (h *Handlers) GetTwoFactor(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	accountID, role := user.SessionAccount()
+	enabled, err := h.service.TwoFactorEnabled(c.Request().Context(), accountID, role)
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+	return c.JSON(200, models.TwoFactorStatusResponse{Enabled: enabled})
+}
+func (h *Handlers) EnrollTwoFactor(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	accountID, role := user.SessionAccount()
+	res, err := h.service.EnrollTwoFactor(c.Request().Context(), accountID, role)
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+	return c.JSON(200, res)
+}
+func (h *Handlers) EnableTwoFactor(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	code, err := h.bindTwoFactorCode(c)
+	if err != nil {
+		return err
+	}
+	codes, hashes, err := utils.NewRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+	accountID, role := user.SessionAccount()
+	err = h.service.EnableTwoFactor(c.Request().Context(), accountID, role, code, hashes)
+	if err == nil {
+		err = h.service.ConfirmSession(c.Request().Context(), user.SessionID)
+	}
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+	return c.JSON(200, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+func (h *Handlers) DisableTwoFactor(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	code, err := h.bindTwoFactorCode(c)
+	if err != nil {
+		return err
+	}
+	accountID, role := user.SessionAccount()
+	if err := h.service.DisableTwoFactor(c.Request().Context(), accountID, role, code); err != nil {
+		return h.twoFactorError(c, err)
+	}
+	return c.JSON(200, echo.Map{"status": "ok"})
+}
+func (h *Handlers) RegenerateRecoveryCodes(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	code, err := h.bindTwoFactorCode(c)
+	if err != nil {
+		return err
+	}
+	codes, hashes, err := utils.NewRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+	accountID, role := user.SessionAccount()
+	if err := h.service.RegenerateRecoveryCodes(c.Request().Context(), accountID, role, code, hashes); err != nil {
+		return h.twoFactorError(c, err)
+	}
+	return c.JSON(200, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// ConfirmTwoFactor marks the current session as recently confirmed, which
+// RequireStepUp asks for before sensitive operations.
+func (h *Handlers) ConfirmTwoFactor(c echo.Context) error {
+	user := c.Get("user").(*utils.JWTClaims)
+	code, err := h.bindTwoFactorCode(c)
+	if err != nil {
+		return err
+	}
+	accountID, role := user.SessionAccount()
+	err = h.service.VerifyTwoFactor(c.Request().Context(), accountID, role, code)
+	if err == nil {
+		err = h.service.ConfirmSession(c.Request().Context(), user.SessionID)
+	}
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+	return c.JSON(200, echo.Map{"status": "ok"})
+}
+
+// RequireStepUp guards sensitive operations of accounts with 2FA enabled.
+// API keys cannot answer a prompt, so once the company enables 2FA these
+// operations are left to confirmed sessions and keys are refused.
+func (h *Handlers) RequireStepUp(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := c.Get("user").(*utils.JWTClaims)
+		if user.APIKeyID != "" {
+			enabled, err := h.service.TwoFactorEnabled(c.Request().Context(), user.ID, "company")
+			if err != nil {
+				h.Error(c.Request().Context(), "", zap.Error(err))
+				return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
+					"status":  "error",
+					"message": "Пользователь не авторизован.",
+				})
+			}
+			if enabled {
+				h.Error(c.Request().Context(), "api key needs step-up", zap.String("api_key_id", user.APIKeyID))
+				return echo.NewHTTPError(http.StatusForbidden, echo.Map{
+					"status":  "error",
+					"message": "Действие требует подтверждения кодом двухфакторной аутентификации и недоступно по API-ключу.",
+				})
+			}
+			return next(c)
+		}
+		accountID, role := user.SessionAccount()
+		err := h.service.CheckStepUp(c.Request().Context(), user.SessionID, accountID, role)
+		if err == service.ErrStepUpRequired {
+			h.Error(c.Request().Context(), "step-up required", zap.String("session_id", user.SessionID))
+			return echo.NewHTTPError(http.StatusForbidden, echo.Map{
+				"status":  "error",
+				"message": "Подтвердите действие кодом двухфакторной аутентификации.",
+			})
+		}
+		if err != nil {
+			h.Error(c.Request().Context(), "", zap.Error(err))
+			return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
+				"status":  "error",
+				"message": "Пользователь не авторизован.",
+			})
+		}
+		return next(c)
+	}
+}
@@ -2,6 +2,9 @@ package http
 
 import (
 	"context"
+	"net"
+	"solution/internal/models"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -9,15 +12,45 @@ import (
 
 type Handlers interface {
 	Ping(c echo.Context) error
+	JWKS(c echo.Context) error
 	RequestTimeMiddleware(echo echo.HandlerFunc) echo.HandlerFunc
 	BusinessSignUp(c echo.Context) error
 	BusinessSignIn(c echo.Context) error
 	BussinessAuthJWT(echo echo.HandlerFunc) echo.HandlerFunc
+	BusinessRefresh(c echo.Context) error
+	UserRefresh(c echo.Context) error
+	GetSessions(c echo.Context) error
+	RevokeSession(c echo.Context) error
+	SignOut(c echo.Context) error
+	RequireScope(scopes ...string) echo.MiddlewareFunc
+	SessionOnly(echo.HandlerFunc) echo.HandlerFunc
+	RequireOwner(echo.HandlerFunc) echo.HandlerFunc
+	BussinessInviteMember(c echo.Context) error
+	BusinessAcceptInvite(c echo.Context) error
+	BussinessGetMembers(c echo.Context) error
+	BussinessUpdateMember(c echo.Context) error
+	BussinessRemoveMember(c echo.Context) error
+	BussinessGetAuditLog(c echo.Context) error
+	BussinessGetProfile(c echo.Context) error
+	BussinessUpdateProfile(c echo.Context) error
+	BusinessVerifyEmail(c echo.Context) error
+	UserVerifyEmail(c echo.Context) error
+	ResendVerificationEmail(c echo.Context) error
+	BusinessPasswordReset(c echo.Context) error
+	UserPasswordReset(c echo.Context) error
+	BusinessPasswordResetConfirm(c echo.Context) error
+	UserPasswordResetConfirm(c echo.Context) error
+	BussinessCreateAPIKey(c echo.Context) error
+	BussinessGetAPIKeys(c echo.Context) error
+	BussinessRevokeAPIKey(c echo.Context) error
 	BussinessCreatePromo(c echo.Context) error
 	BussinessGetPromos(c echo.Context) error
 	BussinessGetPromo(c echo.Context) error
 	BussinessEditPromo(c echo.Context) error
 	BussinessStatPromo(c echo.Context) error
+	BussinessGetPromoCode(c echo.Context) error
+	BussinessLookupCode(c echo.Context) error
+	BussinessRedeemCode(c echo.Context) error
 	UserAuthJWT(echo.HandlerFunc) echo.HandlerFunc
 	UserSignUp(c echo.Context) error
 	UserSignIn(c echo.Context) error
@@ -33,16 +66,47 @@ type Handlers interface {
 	UserEditComment(c echo.Context) error
 	UserDeleteComment(c echo.Context) error
 	UserActivate(c echo.Context) error
+	UserReservePromo(c echo.Context) error
+	UserConfirmReservation(c echo.Context) error
+	UserReleaseReservation(c echo.Context) error
+	UserQuote(c echo.Context) error
 	UserHistory(c echo.Context) error
 	UpdateuserVerdict(c echo.Context) error
+	RequireAdmin(echo.HandlerFunc) echo.HandlerFunc
+	UnlockAccount(c echo.Context) error
+	BusinessTwoFactorSignIn(c echo.Context) error
+	GetTwoFactor(c echo.Context) error
+	EnrollTwoFactor(c echo.Context) error
+	EnableTwoFactor(c echo.Context) error
+	DisableTwoFactor(c echo.Context) error
+	RegenerateRecoveryCodes(c echo.Context) error
+	ConfirmTwoFactor(c echo.Context) error
+	RequireStepUp(echo.HandlerFunc) echo.HandlerFunc
+	BussinessGenerateCodes(c echo.Context) error
+	BussinessGetGenerationJob(c echo.Context) error
+	BussinessImportCodes(c echo.Context) error
+	BussinessExportCodes(c echo.Context) error
+	BussinessAppendCodes(c echo.Context) error
+	BussinessInvalidateCodes(c echo.Context) error
+	BussinessArchivePromo(c echo.Context) error
+	BussinessUnarchivePromo(c echo.Context) error
+	BussinessDeletePromo(c echo.Context) error
+	BussinessPublishPromo(c echo.Context) error
+	BussinessUnpublishPromo(c echo.Context) error
+	BussinessGetPromoTransitions(c echo.Context) error
 }
 type Server struct {
 	server  *echo.Echo
 	address string
 }
 
-func New(ctx context.Context, srv Handlers, SigningKey string, address string) (*Server, error) {
+func New(ctx context.Context, srv Handlers, address string, trustedProxies []string) (*Server, error) {
 	e := echo.New()
+	ipExtractor, err := clientIP(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	e.IPExtractor = ipExtractor
 	e.Use(srv.RequestTimeMiddleware)
 	e.Use(middleware.Recover())
 	e.Use(middleware.LoggerWithConfig(
@@ -56,18 +120,74 @@ func New(ctx context.Context, srv Handlers, SigningKey string, address string) (
 	}))
 
 	e.GET("/api/ping", srv.Ping)
+	e.GET("/.well-known/jwks.json", srv.JWKS)
 	e.POST("/internal/update_user_verdict",srv.UpdateuserVerdict)
+	e.POST("/internal/unlock", srv.UnlockAccount, srv.RequireAdmin)
 	e.POST("/api/business/auth/sign-up", srv.BusinessSignUp)
 	e.POST("/api/business/auth/sign-in", srv.BusinessSignIn)
+	e.POST("/api/business/auth/sign-in/2fa", srv.BusinessTwoFactorSignIn)
+	e.POST("/api/business/auth/refresh", srv.BusinessRefresh)
+	e.POST("/api/business/auth/sign-out", srv.SignOut, srv.BussinessAuthJWT, srv.SessionOnly)
+	e.POST("/api/business/auth/verify-email", srv.BusinessVerifyEmail)
+	e.POST("/api/business/auth/verify-email/resend", srv.ResendVerificationEmail, srv.BussinessAuthJWT, srv.SessionOnly)
+	e.POST("/api/business/auth/password-reset", srv.BusinessPasswordReset)
+	e.POST("/api/business/auth/password-reset/confirm", srv.BusinessPasswordResetConfirm)
+	e.GET("/api/business/auth/sessions", srv.GetSessions, srv.BussinessAuthJWT, srv.SessionOnly)
+	e.DELETE("/api/business/auth/sessions/:id", srv.RevokeSession, srv.BussinessAuthJWT, srv.SessionOnly)
+	e.GET("/api/business/auth/2fa", srv.GetTwoFactor, srv.BussinessAuthJWT, srv.SessionOnly)
+	e.POST("/api/business/auth/2fa/enroll", srv.EnrollTwoFactor, srv.BussinessAuthJWT, srv.SessionOnly)
+	e.POST("/api/business/auth/2fa/enable", srv.EnableTwoFactor, srv.BussinessAuthJWT, srv.SessionOnly)
+	e.POST("/api/business/auth/2fa/disable", srv.DisableTwoFactor, srv.BussinessAuthJWT, srv.SessionOnly)
+	e.POST("/api/business/auth/2fa/recovery-codes", srv.RegenerateRecoveryCodes, srv.BussinessAuthJWT, srv.SessionOnly)
+	e.POST("/api/business/auth/2fa/confirm", srv.ConfirmTwoFactor, srv.BussinessAuthJWT, srv.SessionOnly)
+	e.POST("/api/business/api-keys", srv.BussinessCreateAPIKey, srv.BussinessAuthJWT, srv.RequireOwner, srv.RequireStepUp)
+	e.GET("/api/business/api-keys", srv.BussinessGetAPIKeys, srv.BussinessAuthJWT, srv.RequireOwner)
+	e.DELETE("/api/business/api-keys/:id", srv.BussinessRevokeAPIKey, srv.BussinessAuthJWT, srv.RequireOwner)
+	e.POST("/api/business/members/accept", srv.BusinessAcceptInvite)
+	e.POST("/api/business/members", srv.BussinessInviteMember, srv.BussinessAuthJWT, srv.RequireOwner, srv.RequireStepUp)
+	e.GET("/api/business/members", srv.BussinessGetMembers, srv.BussinessAuthJWT, srv.RequireOwner)
+	e.PATCH("/api/business/members/:id", srv.BussinessUpdateMember, srv.BussinessAuthJWT, srv.RequireOwner, srv.RequireStepUp)
+	e.DELETE("/api/business/members/:id", srv.BussinessRemoveMember, srv.BussinessAuthJWT, srv.RequireOwner, srv.RequireStepUp)
+	e.GET("/api/business/audit", srv.BussinessGetAuditLog, srv.BussinessAuthJWT, srv.RequireOwner)
+	e.GET("/api/business/profile", srv.BussinessGetProfile, srv.BussinessAuthJWT)
+	e.PATCH("/api/business/profile", srv.BussinessUpdateProfile, srv.BussinessAuthJWT, srv.RequireOwner)
 
-	e.POST("/api/business/promo", srv.BussinessCreatePromo, srv.BussinessAuthJWT) //TODO
-	e.GET("/api/business/promo", srv.BussinessGetPromos, srv.BussinessAuthJWT)
-	e.GET("/api/business/promo/:id", srv.BussinessGetPromo, srv.BussinessAuthJWT)
-	e.PATCH("/api/business/promo/:id", srv.BussinessEditPromo, srv.BussinessAuthJWT)
-	e.GET("/api/business/promo/:id/stat", srv.BussinessStatPromo, srv.BussinessAuthJWT)
+	readPromo := srv.RequireScope(models.ScopeReadOnly, models.ScopePromoWrite)
+	writePromo := srv.RequireScope(models.ScopePromoWrite)
+	readStats := srv.RequireScope(models.ScopeStatsRead)
+	readCode := srv.RequireScope(models.ScopeReadOnly, models.ScopePromoWrite, models.ScopeRedeem)
+	redeemCode := srv.RequireScope(models.ScopePromoWrite, models.ScopeRedeem)
+	e.POST("/api/business/promo", srv.BussinessCreatePromo, srv.BussinessAuthJWT, writePromo) //TODO
+	e.GET("/api/business/promo", srv.BussinessGetPromos, srv.BussinessAuthJWT, readPromo)
+	e.GET("/api/business/promo/:id", srv.BussinessGetPromo, srv.BussinessAuthJWT, readPromo)
+	e.PATCH("/api/business/promo/:id", srv.BussinessEditPromo, srv.BussinessAuthJWT, writePromo, srv.RequireStepUp)
+	e.DELETE("/api/business/promo/:id", srv.BussinessDeletePromo, srv.BussinessAuthJWT, writePromo, srv.RequireStepUp)
+	e.POST("/api/business/promo/:id/archive", srv.BussinessArchivePromo, srv.BussinessAuthJWT, writePromo)
+	e.POST("/api/business/promo/:id/unarchive", srv.BussinessUnarchivePromo, srv.BussinessAuthJWT, writePromo)
+	e.POST("/api/business/promo/:id/publish", srv.BussinessPublishPromo, srv.BussinessAuthJWT, writePromo)
+	e.POST("/api/business/promo/:id/unpublish", srv.BussinessUnpublishPromo, srv.BussinessAuthJWT, writePromo)
+	e.GET("/api/business/promo/:id/transitions", srv.BussinessGetPromoTransitions, srv.BussinessAuthJWT, readPromo)
+	e.GET("/api/business/promo/:id/stat", srv.BussinessStatPromo, srv.BussinessAuthJWT, readStats)
+	e.GET("/api/business/promo/:id/codes/:code", srv.BussinessGetPromoCode, srv.BussinessAuthJWT, readPromo)
+	e.POST("/api/business/promo/:id/codes/generate", srv.BussinessGenerateCodes, srv.BussinessAuthJWT, writePromo, srv.RequireStepUp)
+	e.GET("/api/business/promo/:id/codes/jobs/:job_id", srv.BussinessGetGenerationJob, srv.BussinessAuthJWT, readPromo)
+	e.POST("/api/business/promo/:id/codes/import", srv.BussinessImportCodes, srv.BussinessAuthJWT, writePromo, srv.RequireStepUp)
+	e.GET("/api/business/promo/:id/codes", srv.BussinessExportCodes, srv.BussinessAuthJWT, readPromo)
+	e.POST("/api/business/promo/:id/codes", srv.BussinessAppendCodes, srv.BussinessAuthJWT, writePromo, srv.RequireStepUp)
+	e.POST("/api/business/promo/:id/codes/invalidate", srv.BussinessInvalidateCodes, srv.BussinessAuthJWT, writePromo, srv.RequireStepUp)
+	e.GET("/api/business/codes/:code", srv.BussinessLookupCode, srv.BussinessAuthJWT, readCode)
+	e.POST("/api/business/codes/:code/redeem", srv.BussinessRedeemCode, srv.BussinessAuthJWT, redeemCode)
 
 	e.POST("/api/user/auth/sign-up", srv.UserSignUp)
 	e.POST("/api/user/auth/sign-in", srv.UserSignIn)
+	e.POST("/api/user/auth/refresh", srv.UserRefresh)
+	e.POST("/api/user/auth/sign-out", srv.SignOut, srv.UserAuthJWT)
+	e.POST("/api/user/auth/verify-email", srv.UserVerifyEmail)
+	e.POST("/api/user/auth/verify-email/resend", srv.ResendVerificationEmail, srv.UserAuthJWT)
+	e.POST("/api/user/auth/password-reset", srv.UserPasswordReset)
+	e.POST("/api/user/auth/password-reset/confirm", srv.UserPasswordResetConfirm)
+	e.GET("/api/user/auth/sessions", srv.GetSessions, srv.UserAuthJWT)
+	e.DELETE("/api/user/auth/sessions/:id", srv.RevokeSession, srv.UserAuthJWT)
 	e.GET("/api/user/profile", srv.GetUser, srv.UserAuthJWT)
 	e.PATCH("/api/user/profile", srv.UpdateUser, srv.UserAuthJWT)
 	e.GET("/api/user/feed", srv.FeedUser, srv.UserAuthJWT)
@@ -80,11 +200,32 @@ func New(ctx context.Context, srv Handlers, SigningKey string, address string) (
 	e.PUT("/api/user/promo/:id/comments/:comment_id", srv.UserEditComment, srv.UserAuthJWT)
 	e.DELETE("/api/user/promo/:id/comments/:comment_id", srv.UserDeleteComment, srv.UserAuthJWT)
 	e.POST("/api/user/promo/:id/activate", srv.UserActivate, srv.UserAuthJWT)
+	e.POST("/api/user/promo/:id/reserve", srv.UserReservePromo, srv.UserAuthJWT)
+	e.POST("/api/user/promo/quote", srv.UserQuote, srv.UserAuthJWT)
+	e.POST("/api/user/reservations/:id/confirm", srv.UserConfirmReservation, srv.UserAuthJWT)
+	e.POST("/api/user/reservations/:id/release", srv.UserReleaseReservation, srv.UserAuthJWT)
 	e.GET("/api/user/promo/history", srv.UserHistory, srv.UserAuthJWT)
 	server := &Server{e, address}
 	return server, nil
 }
 
+// clientIP reads the client address from X-Forwarded-For only behind the
+// given proxies, so clients cannot pick the address sign-in throttling sees.
+func clientIP(trustedProxies []string) (echo.IPExtractor, error) {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range trustedProxies {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(proxy))
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
 func (s *Server) Start(ctx context.Context) error {
 	return s.server.Start(s.address)
 }
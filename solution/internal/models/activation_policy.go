@@ -0,0 +1,67 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+)
+
+// Reasons an activation policy refuses an activation.
+const (
+	ActivationLimitReached = "ACTIVATION_LIMIT_REACHED"
+	WindowLimitReached     = "WINDOW_LIMIT_REACHED"
+	ActivationCooldown     = "COOLDOWN"
+	// OutsideSchedule is reported for activations outside the weekly schedule.
+	OutsideSchedule = "OUTSIDE_SCHEDULE"
+)
+
+// ActivationPolicy limits how often a single user may activate a promo. Zero
+// fields impose no limit.
+type ActivationPolicy struct {
+	// MaxPerUser caps activations by one user over the promo lifetime.
+	MaxPerUser int `json:"max_per_user,omitempty" validate:"omitempty,gte=1"`
+	// MaxPerWindow caps activations by one user within any WindowSeconds.
+	MaxPerWindow  int   `json:"max_per_window,omitempty" validate:"required_with=WindowSeconds,omitempty,gte=1"`
+	WindowSeconds int64 `json:"window_seconds,omitempty" validate:"required_with=MaxPerWindow,omitempty,gte=60,lte=31536000"`
+	// CooldownSeconds is the least time between two activations by one user.
+	CooldownSeconds int64 `json:"cooldown_seconds,omitempty" validate:"omitempty,gte=1,lte=31536000"`
+}
+
+// ActivationUsage is what a user already activated of one promo.
+type ActivationUsage struct {
+	Total    int
+	InWindow int
+	// FirstInWindow and Last are activation times, nil without activations.
+	FirstInWindow *int64
+	Last          *int64
+}
+
+func (p ActivationPolicy) Empty() bool {
+	return p == ActivationPolicy{}
+}
+
+// Check returns why usage forbids another activation at now, and in how many
+// seconds it is allowed again; 0 means never.
+func (p ActivationPolicy) Check(usage ActivationUsage, now int64) (string, int64) {
+	if p.MaxPerUser > 0 && usage.Total >= p.MaxPerUser {
+		return ActivationLimitReached, 0
+	}
+	if p.CooldownSeconds > 0 && usage.Last != nil && *usage.Last+p.CooldownSeconds > now {
+		return ActivationCooldown, *usage.Last + p.CooldownSeconds - now
+	}
+	if p.MaxPerWindow > 0 && usage.InWindow >= p.MaxPerWindow && usage.FirstInWindow != nil {
+		return WindowLimitReached, max(*usage.FirstInWindow+p.WindowSeconds-now, 1)
+	}
+	return "", 0
+}
+
+func (p ActivationPolicy) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
+func (p *ActivationPolicy) Scan(value interface{}) error {
+	b, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+	return json.Unmarshal(b, p)
+}
@@ -0,0 +1,38 @@
+package models
+
+const (
+	ScopeReadOnly   = "read-only"
+	ScopePromoWrite = "promo-write"
+	ScopeStatsRead  = "stats-read"
+	// ScopeRedeem lets point of sale terminals look up and redeem codes.
+	ScopeRedeem = "redeem"
+)
+
+type APIKey struct {
+	ID         string      `json:"id" db:"id"`
+	CompanyID  string      `json:"-" db:"company_id"`
+	Name       string      `json:"name" db:"name"`
+	Prefix     string      `json:"prefix" db:"prefix"`
+	KeyHash    []byte      `json:"-" db:"key_hash"`
+	Scopes     StringSlice `json:"scopes" db:"scopes"`
+	CreatedAt  int64       `json:"-" db:"created_at"`
+	LastUsedAt *int64      `json:"-" db:"last_used_at"`
+	RevokedAt  *int64      `json:"-" db:"revoked_at"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name   *string  `json:"name" validate:"required,gte=1,lte=100"`
+	Scopes []string `json:"scopes" validate:"required,gte=1,lte=4,unique,dive,oneof='read-only' 'promo-write' 'stats-read' 'redeem'"`
+}
+type APIKeyRequest struct {
+	ID *string `param:"id" validate:"required,uuid"`
+}
+type APIKeyResponse struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	LastUsedAt *string  `json:"last_used_at"`
+	Key        string   `json:"key,omitempty"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+)
+
+// Kinds of benefits a promo gives.
+const (
+	BenefitPercent  = "PERCENT"
+	BenefitFixed    = "FIXED"
+	BenefitFreeItem = "FREE_ITEM"
+)
+
+// Benefit describes the discount of a promo. Money is in minor units of
+// Currency, e.g. kopecks for RUB.
+type Benefit struct {
+	Type string `json:"type,omitempty" validate:"required_with=Percent Amount Currency SKU MinOrder MaxDiscount,omitempty,oneof=PERCENT FIXED FREE_ITEM"`
+	// Percent is taken off the order for PERCENT, capped by MaxDiscount.
+	Percent     int   `json:"percent,omitempty" validate:"required_if=Type PERCENT,excluded_unless=Type PERCENT,omitempty,gte=1,lte=100"`
+	MaxDiscount int64 `json:"max_discount,omitempty" validate:"excluded_unless=Type PERCENT,omitempty,gte=1"`
+	// Amount is taken off the order for FIXED.
+	Amount   int64  `json:"amount,omitempty" validate:"required_if=Type FIXED,excluded_unless=Type FIXED,omitempty,gte=1"`
+	Currency string `json:"currency,omitempty" validate:"required_with=Amount MinOrder MaxDiscount,omitempty,iso4217"`
+	// SKU is the item given for free for FREE_ITEM.
+	SKU string `json:"sku,omitempty" validate:"required_if=Type FREE_ITEM,excluded_unless=Type FREE_ITEM,omitempty,gte=1,lte=64"`
+	// MinOrder is the least order total the promo applies to.
+	MinOrder int64 `json:"min_order,omitempty" validate:"omitempty,gte=1"`
+}
+
+func (b Benefit) Empty() bool {
+	return b == Benefit{}
+}
+
+func (b Benefit) Value() (driver.Value, error) {
+	return json.Marshal(b)
+}
+func (b *Benefit) Scan(value interface{}) error {
+	data, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+	return json.Unmarshal(data, b)
+}
@@ -0,0 +1,53 @@
+package models
+
+const (
+	JobPending = "PENDING"
+	JobRunning = "RUNNING"
+	JobDone    = "DONE"
+	JobFailed  = "FAILED"
+)
+
+type CodeGenerationJob struct {
+	ID         string  `db:"id"`
+	PromoID    string  `db:"promo_id"`
+	CompanyID  string  `db:"company_id"`
+	Prefix     string  `db:"prefix"`
+	Length     int     `db:"length"`
+	Alphabet   string  `db:"alphabet"`
+	CheckDigit bool    `db:"check_digit"`
+	Total      int     `db:"total"`
+	Generated  int     `db:"generated"`
+	Status     string  `db:"status"`
+	Error      *string `db:"error"`
+	CreatedAt  int64   `db:"created_at"`
+	UpdatedAt  int64   `db:"updated_at"`
+	FinishedAt *int64  `db:"finished_at"`
+}
+
+// GenerateCodesRequest asks the server to generate Count codes of the form
+// Prefix + Length random characters of Alphabet (+ a check character).
+type GenerateCodesRequest struct {
+	Count      *int    `json:"count" validate:"required,gte=1,lte=1000000"`
+	Prefix     *string `json:"prefix,omitempty" validate:"omitempty,lte=20,printascii,excludes= "`
+	Length     *int    `json:"length" validate:"required,gte=4,lte=24"`
+	Alphabet   *string `json:"alphabet,omitempty" validate:"omitempty,gte=2,lte=64,printascii,excludes= "`
+	CheckDigit bool    `json:"check_digit"`
+}
+type GenerateCodesParams struct {
+	PromoID *string `param:"id" validate:"required,uuid"`
+	GenerateCodesRequest
+}
+type CodeGenerationJobRequest struct {
+	PromoID *string `param:"id" validate:"required,uuid"`
+	JobID   *string `param:"job_id" validate:"required,uuid"`
+}
+type CodeGenerationJobResponse struct {
+	ID         string `json:"id"`
+	PromoID    string `json:"promo_id"`
+	Status     string `json:"status"`
+	Total      int    `json:"total"`
+	Generated  int    `json:"generated"`
+	Error      string `json:"error,omitempty"`
+	CreatedAt  string `json:"created_at"`
+	FinishedAt string `json:"finished_at,omitempty"`
+}
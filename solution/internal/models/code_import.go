@@ -0,0 +1,73 @@
+package models
+
+// Reasons a row of an imported CSV file was rejected.
+const (
+	ImportMalformedRow    = "MALFORMED_ROW"
+	ImportInvalidLength   = "INVALID_LENGTH"
+	ImportDuplicateInFile = "DUPLICATE_IN_FILE"
+	ImportDuplicateInList = "DUPLICATE_IN_REQUEST"
+	ImportAlreadyExists   = "ALREADY_EXISTS"
+	ImportTooManyRows     = "TOO_MANY_ROWS"
+)
+
+type PromoCodesRequest struct {
+	PromoID *string `param:"id" validate:"required,uuid"`
+}
+type AppendCodesRequest struct {
+	PromoID *string  `param:"id" validate:"required,uuid"`
+	Codes   []string `json:"codes" validate:"required,gte=1,lte=5000"`
+}
+type InvalidateCodesRequest struct {
+	PromoID *string  `param:"id" validate:"required,uuid"`
+	Codes   []string `json:"codes" validate:"required,gte=1,lte=5000,dive,required,lte=64"`
+}
+type InvalidateCodesResponse struct {
+	Invalidated int `json:"invalidated"`
+	// Skipped codes are unknown, already redeemed or already invalidated.
+	Skipped []string `json:"skipped"`
+}
+
+// ImportRowError describes a rejected code. Line is the line of the CSV file,
+// or the 1-based position in the list of codes sent as JSON.
+type ImportRowError struct {
+	Line  int    `json:"line"`
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error"`
+}
+type ImportCodesReport struct {
+	Imported        int              `json:"imported"`
+	Rejected        int              `json:"rejected"`
+	Errors          []ImportRowError `json:"errors"`
+	ErrorsTruncated bool             `json:"errors_truncated,omitempty"`
+}
+
+func (r *ImportCodesReport) Reject(line int, code, reason string, limit int) {
+	r.Rejected++
+	if len(r.Errors) >= limit {
+		r.ErrorsTruncated = true
+		return
+	}
+	r.Errors = append(r.Errors, ImportRowError{Line: line, Code: code, Error: reason})
+}
+
+// Merge adds the counts and errors of other, a report on rows of the same
+// import checked later, keeping the errors in line order.
+func (r *ImportCodesReport) Merge(other *ImportCodesReport, limit int) {
+	r.Imported += other.Imported
+	r.Rejected += other.Rejected
+	merged := make([]ImportRowError, 0, min(len(r.Errors)+len(other.Errors), limit))
+	i, j := 0, 0
+	for len(merged) < limit && (i < len(r.Errors) || j < len(other.Errors)) {
+		if j == len(other.Errors) || (i < len(r.Errors) && r.Errors[i].Line <= other.Errors[j].Line) {
+			merged = append(merged, r.Errors[i])
+			i++
+		} else {
+			merged = append(merged, other.Errors[j])
+			j++
+		}
+	}
+	if i < len(r.Errors) || j < len(other.Errors) || other.ErrorsTruncated {
+		r.ErrorsTruncated = true
+	}
+	r.Errors = merged
+}
@@ -5,5 +5,16 @@ type Company struct {
 	Name      string `json:"name" db:"name" redis:"name"`
 	Email     string `json:"email" db:"email" redis:"email"`
 	Password  []byte `json:"password" db:"password" redis:"password"`
+	// TimeZone is the IANA zone promo dates of the company are given in.
+	TimeZone string `json:"time_zone" db:"time_zone" redis:"-"`
 }
 
+type CompanyProfileResponse struct {
+	CompanyID string `json:"company_id"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	TimeZone  string `json:"time_zone"`
+}
+type UpdateCompanyProfileRequest struct {
+	TimeZone *string `json:"time_zone" validate:"required,timezone"`
+}
@@ -0,0 +1,80 @@
+package models
+
+const (
+	MemberOwner   = "owner"
+	MemberEditor  = "editor"
+	MemberAnalyst = "analyst"
+
+	MemberInvited = "INVITED"
+	MemberActive  = "ACTIVE"
+)
+
+// MemberScopes maps a team role to the API key scopes it is equivalent to.
+var MemberScopes = map[string][]string{
+	MemberOwner:   {ScopeReadOnly, ScopePromoWrite, ScopeStatsRead, ScopeRedeem},
+	MemberEditor:  {ScopePromoWrite, ScopeRedeem},
+	MemberAnalyst: {ScopeReadOnly, ScopeStatsRead},
+}
+
+type CompanyMember struct {
+	ID              string  `db:"id"`
+	CompanyID       string  `db:"company_id"`
+	Email           string  `db:"email"`
+	Name            string  `db:"name"`
+	Role            string  `db:"role"`
+	Password        []byte  `db:"password"`
+	InviteHash      []byte  `db:"invite_hash"`
+	InviteExpiresAt *int64  `db:"invite_expires_at"`
+	InvitedBy       *string `db:"invited_by"`
+	CreatedAt       int64   `db:"created_at"`
+	JoinedAt        *int64  `db:"joined_at"`
+}
+
+type InviteMemberRequest struct {
+	Email *string `json:"email" validate:"required,email,gte=8,lte=120"`
+	Name  *string `json:"name" validate:"required,gte=1,lte=120"`
+	Role  *string `json:"role" validate:"required,oneof='owner' 'editor' 'analyst'"`
+}
+type AcceptInviteRequest struct {
+	InviteToken *string `json:"invite_token" validate:"required,lte=300"`
+	Password    *string `json:"password" validate:"required,password"`
+}
+type MemberRequest struct {
+	ID *string `param:"id" validate:"required,uuid"`
+}
+type UpdateMemberRequest struct {
+	ID   *string `param:"id" validate:"required,uuid"`
+	Role *string `json:"role" validate:"required,oneof='owner' 'editor' 'analyst'"`
+}
+type MemberResponse struct {
+	ID          string  `json:"id"`
+	Email       string  `json:"email"`
+	Name        string  `json:"name"`
+	Role        string  `json:"role"`
+	Status      string  `json:"status"`
+	InvitedAt   string  `json:"invited_at"`
+	JoinedAt    *string `json:"joined_at"`
+	InviteToken string  `json:"invite_token,omitempty"`
+}
+
+type AuditRecord struct {
+	ID        int64   `db:"id"`
+	CompanyID string  `db:"company_id"`
+	MemberID  *string `db:"member_id"`
+	APIKeyID  *string `db:"api_key_id"`
+	Action    string  `db:"action"`
+	TargetID  *string `db:"target_id"`
+	CreatedAt int64   `db:"created_at"`
+}
+type GetAuditLogRequest struct {
+	Limit  *int `query:"limit" validate:"omitempty,gte=0,lte=100"`
+	Offset *int `query:"offset" validate:"omitempty,gte=0"`
+}
+type AuditRecordResponse struct {
+	ID        int64   `json:"id"`
+	MemberID  *string `json:"member_id"`
+	APIKeyID  *string `json:"api_key_id"`
+	Action    string  `json:"action"`
+	TargetID  *string `json:"target_id"`
+	CreatedAt string  `json:"created_at"`
+}
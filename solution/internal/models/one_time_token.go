@@ -0,0 +1,28 @@
+package models
+
+const (
+	TokenEmailVerification = "EMAIL_VERIFICATION"
+	TokenPasswordReset     = "PASSWORD_RESET"
+	TokenTwoFactor         = "TWO_FACTOR"
+)
+
+type OneTimeToken struct {
+	Hash      []byte `db:"token_hash"`
+	Purpose   string `db:"purpose"`
+	AccountID string `db:"account_id"`
+	Role      string `db:"role"`
+	CreatedAt int64  `db:"created_at"`
+	ExpiresAt int64  `db:"expires_at"`
+	UsedAt    *int64 `db:"used_at"`
+}
+
+type VerifyEmailRequest struct {
+	Token *string `json:"token" validate:"required,lte=300"`
+}
+type PasswordResetRequest struct {
+	Email *string `json:"email" validate:"required,email,gte=8,lte=120"`
+}
+type PasswordResetConfirmRequest struct {
+	Token    *string `json:"token" validate:"required,lte=300"`
+	Password *string `json:"password" validate:"required,password"`
+}
@@ -6,6 +6,7 @@ type CompanySort struct {
 	Offset    int
 	SortBy    string
 	Countries []string
+	State     string
 }
 type UserSort struct {
 	Id       string
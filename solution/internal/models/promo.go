@@ -19,7 +19,7 @@ type Promo struct {
 	Mode            *string     `json:"mode" db:"mode" validate:"required,oneof='COMMON' 'UNIQUE'"`
 	PromoCommon     *string     `json:"promo_common,omitempty" db:"promo_common,omitempty" validate:"omitempty,required_if=Mode COMMON,gte=5,lte=30"`
 	PromoUnique     StringSlice `json:"promo_unique,omitempty" db:"promo_unique,omitempty" validate:"omitempty,required_if=Mode UNIQUE,gte=1,lte=5000,dive,gte=3,lte=30"`
-	UsedPromoUnique StringSlice
+	AvailableCodes  int         `json:"-" db:"available_codes"`
 	PromoId         *string `json:"promo_id" db:"promo_id" validate:"required,uuid"`
 	CompanyId       *string `json:"company_id" db:"company_id" validate:"required,uuid"`
 	CompanyName     *string `json:"company_name" db:"company_name" validate:"required,gte=5,lte=50"`
@@ -27,8 +27,25 @@ type Promo struct {
 	UsedCount       *int    `json:"used_count" db:"used_count" validate:"required"`
 	CommentCount    int    `json:"comment_count" db:"comment_count" `
 	Active          *bool   `json:"active" db:"active" `
+	ArchivedAt      *int64  `json:"-" db:"archived_at"`
+	PublishAt       *int64  `json:"-" db:"publish_at"`
+	ActivationPolicy *ActivationPolicy `json:"activation_policy,omitempty" db:"activation_policy"`
+	Schedule         *Schedule         `json:"schedule,omitempty" db:"schedule"`
+	Benefit          *Benefit          `json:"benefit,omitempty" db:"benefit"`
 }
 
+// Visible tells whether users can see and activate the promo at now.
+func (p *Promo) Visible(now int64) bool {
+	return p.ArchivedAt == nil && PublicationState(p.PublishAt, now) == PublicationPublished
+}
+
+// Lifecycle states the company promo listing can be filtered by.
+const (
+	PromoStateLive     = "live"
+	PromoStateArchived = "archived"
+	PromoStateAll      = "all"
+)
+
 type Target struct {
 	AgeFrom    *int        `json:"age_from,omitempty" db:"age_from,omitempty" validate:"omitempty,gte=0,lte=100"`
 	AgeUntil   *int        `json:"age_until,omitempty" db:"age_until,omitempty" validate:"omitempty,gte=0,lte=100"`
@@ -0,0 +1,20 @@
+package models
+
+const (
+	PromoCodeAvailable = "AVAILABLE"
+	PromoCodeActivated = "ACTIVATED"
+	// PromoCodeReserved codes are held for a checkout until it is confirmed.
+	PromoCodeReserved = "RESERVED"
+	// PromoCodeInvalidated codes were retired by the company before anyone
+	// redeemed them.
+	PromoCodeInvalidated = "INVALIDATED"
+)
+
+type PromoCode struct {
+	ID          int64   `json:"-" db:"id"`
+	PromoId     string  `json:"promo_id" db:"promo_id"`
+	Code        string  `json:"code" db:"code"`
+	Status      string  `json:"status" db:"status"`
+	UserID      *string `json:"user_id,omitempty" db:"user_id"`
+	ActivatedAt *int64  `json:"activated_at,omitempty" db:"activated_at"`
+}
@@ -0,0 +1,32 @@
+package models
+
+// Reasons of promo transitions. A promo is turned on once it is within its
+// dates, has uses left and its schedule is open, and turned off by the first
+// of them to fail.
+const (
+	TransitionStarted        = "STARTED"
+	TransitionNotStarted     = "NOT_STARTED"
+	TransitionExpired        = "EXPIRED"
+	TransitionExhausted      = "EXHAUSTED"
+	TransitionScheduleOpened = "SCHEDULE_OPENED"
+	TransitionScheduleClosed = "SCHEDULE_CLOSED"
+)
+
+// PromoTransition records a flip of the active flag of a promo.
+type PromoTransition struct {
+	ID        int64  `db:"id"`
+	PromoID   string `db:"promo_id"`
+	Active    bool   `db:"active"`
+	Reason    string `db:"reason"`
+	CreatedAt int64  `db:"created_at"`
+}
+type GetPromoTransitionsRequest struct {
+	PromoID *string `param:"id" validate:"required,uuid"`
+	Limit   *int    `query:"limit" validate:"omitempty,gte=0,lte=100"`
+	Offset  *int    `query:"offset" validate:"omitempty,gte=0"`
+}
+type PromoTransitionResponse struct {
+	Active    bool   `json:"active"`
+	Reason    string `json:"reason"`
+	CreatedAt string `json:"created_at"`
+}
@@ -0,0 +1,26 @@
+package models
+
+// Publication states of a promo. Drafts and scheduled promos are only visible
+// to the company.
+const (
+	PublicationDraft     = "DRAFT"
+	PublicationScheduled = "SCHEDULED"
+	PublicationPublished = "PUBLISHED"
+)
+
+func PublicationState(publishAt *int64, now int64) string {
+	switch {
+	case publishAt == nil:
+		return PublicationDraft
+	case *publishAt > now:
+		return PublicationScheduled
+	}
+	return PublicationPublished
+}
+
+type PublishPromoRequest struct {
+	PromoID *string `param:"id" validate:"required,uuid"`
+	// PublishAt schedules the publication; the promo is published right away
+	// when it is empty or already passed.
+	PublishAt *string `json:"publish_at,omitempty" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+}
@@ -0,0 +1,81 @@
+package models
+
+// Reasons a code is left out of a quote.
+const (
+	QuoteNotFound        = "NOT_FOUND"
+	QuoteNotTargeted     = "NOT_TARGETED"
+	QuoteNotActivated    = "NOT_ACTIVATED"
+	QuoteRedeemed        = "ALREADY_REDEEMED"
+	QuoteNotStarted      = "NOT_STARTED"
+	QuoteExpired         = "EXPIRED"
+	QuoteDuplicatePromo  = "DUPLICATE_PROMO"
+	QuoteNoBenefit       = "NO_BENEFIT"
+	QuoteCurrency        = "CURRENCY_MISMATCH"
+	QuoteMinOrder        = "MIN_ORDER_NOT_MET"
+	QuoteItemNotInCart   = "ITEM_NOT_IN_CART"
+	QuoteNotApplicable   = "NOT_APPLICABLE"
+	QuoteOutsideSchedule = OutsideSchedule
+)
+
+// QuotedCode is what a quote needs to know about a code the user presented.
+type QuotedCode struct {
+	Code        string
+	PromoID     string
+	Target      Target
+	ActiveFrom  *int64
+	ActiveUntil *int64
+	ArchivedAt  *int64
+	PublishAt   *int64
+	Benefit     *Benefit
+	// Open tells whether the schedule of the promo lets it be used now,
+	// Targeted whether the promo is aimed at the user.
+	Open     bool
+	Targeted bool
+	// Issued is set when the user activated or reserved the code.
+	Issued   bool
+	Redeemed bool
+}
+
+// QuoteRequest is a cart priced in minor units of Currency.
+type QuoteRequest struct {
+	Currency *string     `json:"currency" validate:"required,iso4217"`
+	Items    []QuoteItem `json:"items" validate:"required,gte=1,lte=100,dive"`
+	// Total, when given, has to match the sum of the items.
+	Total  *int64   `json:"total,omitempty" validate:"omitempty,gte=0"`
+	Codes  []string `json:"codes" validate:"required,gte=1,lte=10,unique,dive,gte=3,lte=30"`
+	UserID string   `json:"-"`
+}
+type QuoteItem struct {
+	SKU       *string `json:"sku" validate:"required,gte=1,lte=64"`
+	Category  *string `json:"category,omitempty" validate:"omitempty,gte=2,lte=20"`
+	Quantity  *int64  `json:"quantity" validate:"required,gte=1,lte=10000"`
+	UnitPrice *int64  `json:"unit_price" validate:"required,gte=0,lte=1000000000"`
+}
+type QuoteResponse struct {
+	Currency string          `json:"currency"`
+	Subtotal int64           `json:"subtotal"`
+	Discount int64           `json:"discount"`
+	Total    int64           `json:"total"`
+	Lines    []QuoteLine     `json:"lines"`
+	Applied  []QuoteApplied  `json:"applied"`
+	Rejected []QuoteRejected `json:"rejected"`
+}
+type QuoteLine struct {
+	SKU       string  `json:"sku"`
+	Category  *string `json:"category,omitempty"`
+	Quantity  int64   `json:"quantity"`
+	UnitPrice int64   `json:"unit_price"`
+	Amount    int64   `json:"amount"`
+	Discount  int64   `json:"discount"`
+	Total     int64   `json:"total"`
+}
+type QuoteApplied struct {
+	Code     string  `json:"code"`
+	PromoID  string  `json:"promo_id"`
+	Benefit  Benefit `json:"benefit"`
+	Discount int64   `json:"discount"`
+}
+type QuoteRejected struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,90 @@
+package models
+
+// Reasons a code can't be redeemed at the point of sale.
+const (
+	RedemptionNotIssued   = "NOT_ISSUED"
+	RedemptionRedeemed    = "ALREADY_REDEEMED"
+	RedemptionInvalidated = "INVALIDATED"
+	RedemptionExpired     = "EXPIRED"
+	RedemptionArchived    = "ARCHIVED"
+)
+
+type Redemption struct {
+	ID         int64   `db:"id"`
+	CompanyID  string  `db:"company_id"`
+	PromoID    string  `db:"promo_id"`
+	Code       string  `db:"code"`
+	CodeID     *int64  `db:"code_id"`
+	UserID     *string `db:"user_id"`
+	StoreID    string  `db:"store_id"`
+	TerminalID *string `db:"terminal_id"`
+	RedeemedAt int64   `db:"redeemed_at"`
+}
+
+// CodeLookup is what a company knows about one of its codes. A unique code is
+// issued once at most, a common one as many times as users activated it.
+type CodeLookup struct {
+	Code        string
+	PromoID     string
+	Mode        string
+	Description string
+	// CodeID, CodeStatus, UserID and IssuedAt are only set for unique codes.
+	CodeID        *int64
+	CodeStatus    string
+	UserID        *string
+	IssuedAt      *int64
+	ActiveUntil   *int64
+	ArchivedAt    *int64
+	IssuedCount   int
+	RedeemedCount int
+	// LastRedemption is nil until the code is redeemed.
+	LastRedemption *Redemption
+}
+
+// DenyReason returns why the code can't be redeemed at now, or "" when it can.
+func (l CodeLookup) DenyReason(now int64) string {
+	if l.ArchivedAt != nil {
+		return RedemptionArchived
+	}
+	if l.CodeStatus == PromoCodeInvalidated {
+		return RedemptionInvalidated
+	}
+	if l.ActiveUntil != nil && *l.ActiveUntil < now {
+		return RedemptionExpired
+	}
+	if l.IssuedCount == 0 {
+		return RedemptionNotIssued
+	}
+	if l.RedeemedCount >= l.IssuedCount {
+		return RedemptionRedeemed
+	}
+	return ""
+}
+
+type CodeLookupRequest struct {
+	Code *string `param:"code" validate:"required,gte=3,lte=30"`
+}
+type RedeemCodeRequest struct {
+	Code       *string `param:"code" validate:"required,gte=3,lte=30"`
+	StoreID    *string `json:"store_id" validate:"required,gte=1,lte=64"`
+	TerminalID *string `json:"terminal_id" validate:"omitempty,gte=1,lte=64"`
+}
+type CodeLookupResponse struct {
+	Code          string              `json:"code"`
+	Valid         bool                `json:"valid"`
+	Reason        string              `json:"reason,omitempty"`
+	PromoID       string              `json:"promo_id"`
+	Mode          string              `json:"mode"`
+	Description   string              `json:"description"`
+	UserID        *string             `json:"user_id,omitempty"`
+	IssuedAt      string              `json:"issued_at,omitempty"`
+	IssuedCount   int                 `json:"issued_count"`
+	RedeemedCount int                 `json:"redeemed_count"`
+	Redeemed      bool                `json:"redeemed"`
+	Redemption    *RedemptionResponse `json:"redemption,omitempty"`
+}
+type RedemptionResponse struct {
+	StoreID    string  `json:"store_id"`
+	TerminalID *string `json:"terminal_id,omitempty"`
+	RedeemedAt string  `json:"redeemed_at"`
+}
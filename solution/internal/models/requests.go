@@ -8,17 +8,20 @@ type CompanySignUpRequest struct {
 	Name     string `json:"name"  validate:"required,gte=5,lte=50"`
 	Email    string `json:"email" validate:"required,email,gte=8,lte=120"`
 	Password string `json:"password" validate:"required,password"`
+	TimeZone string `json:"time_zone,omitempty" validate:"omitempty,timezone"`
 }
 type CompanySignUpResponse struct {
-	Token     string `json:"token" validate:"lte=300"`
-	CompanyID string `json:"company_id" validate:"uuid"`
+	Token        string `json:"token" validate:"lte=300"`
+	RefreshToken string `json:"refresh_token"`
+	CompanyID    string `json:"company_id" validate:"uuid"`
 }
 type CompanySignInRequest struct {
 	Email    string `json:"email" validate:"required,email,gte=8,lte=120"`
 	Password string `json:"password" validate:"required,password"`
 }
 type CompanySignInResponse struct {
-	Token string `json:"token" validate:"lte=300"`
+	Token        string `json:"token" validate:"lte=300"`
+	RefreshToken string `json:"refresh_token"`
 }
 type CreatePromoRequest struct {
 	Description *string  `json:"description" db:"description" validate:"required,gte=10,lte=300"`
@@ -29,35 +32,57 @@ type CreatePromoRequest struct {
 	ActiveUntil *string  `json:"active_until,omitempty" db:"active_until,omitempty" validate:"omitempty,date_validation"`
 	Mode        *string  `json:"mode" db:"mode" validate:"required,oneof='COMMON' 'UNIQUE'"`
 	PromoCommon *string  `json:"promo_common,omitempty" validate:"required_if=Mode COMMON,omitempty,gte=5,lte=30"`
-	PromoUnique []string `json:"promo_unique,omitempty" validate:"required_if=Mode UNIQUE,omitempty,gte=1,lte=5000,dive,gte=3,lte=30"`
+	PromoUnique []string `json:"promo_unique,omitempty" validate:"omitempty,gte=1,lte=5000,unique,dive,gte=3,lte=30"`
+	// Generate replaces PromoUnique for UNIQUE promos whose codes are made by
+	// the server.
+	Generate *GenerateCodesRequest `json:"generate,omitempty" validate:"omitempty"`
+	// Promos start as drafts unless Publish or PublishAt is given.
+	Publish          bool              `json:"publish,omitempty"`
+	PublishAt        *string           `json:"publish_at,omitempty" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	ActivationPolicy *ActivationPolicy `json:"activation_policy,omitempty" validate:"omitempty"`
+	Schedule         *Schedule         `json:"schedule,omitempty" validate:"omitempty"`
+	Benefit          *Benefit          `json:"benefit,omitempty" validate:"omitempty"`
 }
 
 type CreatePromoResponse struct {
-	PromoId string `json:"id" validate:"requred,uuid"`
+	PromoId       string                     `json:"id" validate:"requred,uuid"`
+	GenerationJob *CodeGenerationJobResponse `json:"generation_job,omitempty"`
 }
 type GetPromosRequest struct {
 	Limit     *int     `query:"limit" validate:"omitempty,gte=0"`
 	Offset    *int     `query:"offset" validate:"omitempty,gte=0"`
 	SortBy    *string  `query:"sort_by" validate:"omitempty,oneof='active_from' 'active_until' ' '"`
 	Countries []string `query:"country" validate:"omitempty,dive,country_validation"`
+	State     *string  `query:"state" validate:"omitempty,oneof=live archived all"`
 }
 
 type GetPromoResponse struct {
-	Description *string     `json:"description" db:"description" validate:"required,gte=10,lte=300"`
-	ImageUrl    *string     `json:"image_url,omitempty" db:"image_url,omitempty" validate:"omitempty,lte=350,url"`
-	Target      *Target     `json:"target" db:"target" validate:"required,gte=0"`
-	MaxCount    *int        `json:"max_count" db:"max_count" validate:"required" `
-	ActiveFrom  *string     `json:"active_from,omitempty" db:"active_from,omitempty" validate:"omitempty,date_validation"`
-	ActiveUntil *string     `json:"active_until,omitempty" db:"active_until,omitempty" validate:"omitempty,date_validation"`
-	Mode        *string     `json:"mode" db:"mode" validate:"required,oneof='COMMON' 'UNIQUE'"`
-	PromoCommon *string     `json:"promo_common,omitempty" db:"promo_common,omitempty" validate:"omitempty,required_if=Mode COMMON,gte=5,lte=30"`
-	PromoUnique StringSlice `json:"promo_unique,omitempty" db:"promo_unique,omitempty" validate:"omitempty,required_if=Mode UNIQUE,gte=1,lte=5000,dive,gte=3,lte=30"`
-	PromoId     *string     `json:"promo_id" db:"promo_id" validate:"required,uuid"`
-	CompanyId   *string     `json:"company_id" db:"company_id" validate:"required,uuid"`
-	CompanyName *string     `json:"company_name" db:"company_name" validate:"required,gte=5,lte=50"`
-	LikeCount   *int        `json:"like_count" db:"like_count" validate:"required"`
-	UsedCount   *int        `json:"used_count" db:"used_count" validate:"required"`
-	Active      *bool       `json:"active" db:"active" `
+	Description *string `json:"description" db:"description" validate:"required,gte=10,lte=300"`
+	ImageUrl    *string `json:"image_url,omitempty" db:"image_url,omitempty" validate:"omitempty,lte=350,url"`
+	Target      *Target `json:"target" db:"target" validate:"required,gte=0"`
+	MaxCount    *int    `json:"max_count" db:"max_count" validate:"required" `
+	ActiveFrom  *string `json:"active_from,omitempty" db:"active_from,omitempty" validate:"omitempty,date_validation"`
+	ActiveUntil *string `json:"active_until,omitempty" db:"active_until,omitempty" validate:"omitempty,date_validation"`
+	// ActiveFrom and ActiveUntil are days in TimeZone, the exact bounds are
+	// given as RFC 3339 timestamps.
+	ActiveFromAt     *string           `json:"active_from_at,omitempty" db:"-"`
+	ActiveUntilAt    *string           `json:"active_until_at,omitempty" db:"-"`
+	TimeZone         string            `json:"time_zone" db:"time_zone"`
+	Mode             *string           `json:"mode" db:"mode" validate:"required,oneof='COMMON' 'UNIQUE'"`
+	PromoCommon      *string           `json:"promo_common,omitempty" db:"promo_common,omitempty" validate:"omitempty,required_if=Mode COMMON,gte=5,lte=30"`
+	PromoUnique      StringSlice       `json:"promo_unique,omitempty" db:"promo_unique,omitempty" validate:"omitempty,required_if=Mode UNIQUE,gte=1,lte=5000,dive,gte=3,lte=30"`
+	PromoId          *string           `json:"promo_id" db:"promo_id" validate:"required,uuid"`
+	CompanyId        *string           `json:"company_id" db:"company_id" validate:"required,uuid"`
+	CompanyName      *string           `json:"company_name" db:"company_name" validate:"required,gte=5,lte=50"`
+	LikeCount        *int              `json:"like_count" db:"like_count" validate:"required"`
+	UsedCount        *int              `json:"used_count" db:"used_count" validate:"required"`
+	Active           *bool             `json:"active" db:"active" `
+	ArchivedAt       *string           `json:"archived_at,omitempty" db:"archived_at"`
+	Publication      string            `json:"publication_state" db:"-"`
+	PublishAt        *string           `json:"publish_at,omitempty" db:"publish_at"`
+	ActivationPolicy *ActivationPolicy `json:"activation_policy,omitempty" db:"activation_policy"`
+	Schedule         *Schedule         `json:"schedule,omitempty" db:"schedule"`
+	Benefit          *Benefit          `json:"benefit,omitempty" db:"benefit"`
 }
 type GetPromoRequest struct {
 	ID *string `json:"promo_id" param:"id" validate:"required"`
@@ -70,14 +95,32 @@ type EditPromoRequest struct {
 	MaxCount    *int    `json:"max_count,omitempty" db:"max_count,omitempty" validate:"omitempty" `
 	ActiveFrom  *string `json:"active_from,omitempty" db:"active_from,omitempty" validate:"omitempty,date_validation"`
 	ActiveUntil *string `json:"active_until,omitempty" db:"active_until,omitempty" validate:"omitempty,date_validation"`
+	// ActivationPolicy, Schedule and Benefit replace those of the promo, {}
+	// removes them.
+	ActivationPolicy *ActivationPolicy `json:"activation_policy,omitempty" validate:"omitempty"`
+	Schedule         *Schedule         `json:"schedule,omitempty" validate:"omitempty"`
+	Benefit          *Benefit          `json:"benefit,omitempty" validate:"omitempty"`
+}
+type GetPromoCodeRequest struct {
+	PromoID *string `param:"id" validate:"required,uuid"`
+	Code    *string `param:"code" validate:"required,gte=3,lte=30"`
 }
 type GetPromoStatRequest struct {
 	PromoID   *string `json:"promo_id" param:"id" validate:"required"`
 	CompanyID *string `json:"user_id"  validate:"required"`
 }
+
+// GetPromoStatResponse counts confirmed activations in ActivationsCount and
+// Countries, reservations are counted by status. IssuedCount is the codes
+// handed out to users and RedeemedCount the ones used at a store.
 type GetPromoStatResponse struct {
 	ActivationsCount int       `json:"activations_count" db:"activations_count" redis:"actiovations_count" validate:"gte=0"`
 	Countries        Countries `json:"countries,omitempty" db:"countries,omitempty" redis:"countries,omitempty" validate:"omitempty"`
+	ReservedCount    int       `json:"reserved_count" db:"-" redis:"-"`
+	ReleasedCount    int       `json:"released_count" db:"-" redis:"-"`
+	ExpiredCount     int       `json:"expired_count" db:"-" redis:"-"`
+	IssuedCount      int       `json:"issued_count" db:"-" redis:"-"`
+	RedeemedCount    int       `json:"redeemed_count" db:"-" redis:"-"`
 }
 type Countries []Country
 type Country struct {
@@ -100,7 +143,8 @@ type SignUpUserRequest struct {
 	Password  *string `json:"password" db:"password" validate:"required,gte=8,lte=60,password"`
 }
 type SignUpUserResp struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
 }
 type SignInUserRequest struct {
 	Email    *string `json:"email" db:"email" validate:"required,email,gte=8,lte=120"`
@@ -135,16 +179,17 @@ type GetUserResponse struct {
 	Other     *Other  `json:"other" db:"other"  redis:"other" validate:"required"`
 }
 type FeedUserResponse struct {
-	PromoId      *string `json:"promo_id" db:"promo_id" validate:"required,uuid"`
-	CompanyId    *string `json:"company_id" db:"company_id" validate:"required,uuid"`
-	CompanyName  *string `json:"company_name" db:"company_name" validate:"required,gte=5,lte=50"`
-	Description  *string `json:"description" db:"description" validate:"required,gte=10,lte=300"`
-	ImageUrl     *string `json:"image_url,omitempty" db:"image_url,omitempty" validate:"omitempty,lte=350,url"`
-	Active       *bool   `json:"active" db:"active" `
-	IsActivated  *bool   `json:"is_activated_by_user" db:"is_activated_by_user"`
-	LikeCount    *int    `json:"like_count" db:"like_count" validate:"required"`
-	IsLiked      *bool   `json:"is_liked_by_user" db:"is_liked_by_user"`
-	CommentCount *int    `json:"comment_count" db:"comment_count" validate:"required"`
+	PromoId      *string  `json:"promo_id" db:"promo_id" validate:"required,uuid"`
+	CompanyId    *string  `json:"company_id" db:"company_id" validate:"required,uuid"`
+	CompanyName  *string  `json:"company_name" db:"company_name" validate:"required,gte=5,lte=50"`
+	Description  *string  `json:"description" db:"description" validate:"required,gte=10,lte=300"`
+	ImageUrl     *string  `json:"image_url,omitempty" db:"image_url,omitempty" validate:"omitempty,lte=350,url"`
+	Active       *bool    `json:"active" db:"active" `
+	IsActivated  *bool    `json:"is_activated_by_user" db:"is_activated_by_user"`
+	LikeCount    *int     `json:"like_count" db:"like_count" validate:"required"`
+	IsLiked      *bool    `json:"is_liked_by_user" db:"is_liked_by_user"`
+	CommentCount *int     `json:"comment_count" db:"comment_count" validate:"required"`
+	Benefit      *Benefit `json:"benefit,omitempty" db:"benefit"`
 }
 type UserPromoRequest struct {
 	PromoId *string `param:"id" json:"promo_id" db:"promo_id" validate:"required,uuid"`
@@ -211,6 +256,8 @@ type ActivateRequest struct {
 	UserID  *string ` json:"used_id" db:"used_id" validate:"required,uuid"`
 	Country *string
 	Age     *int
+	// Reservation makes the activation hold the code until it is confirmed.
+	Reservation *Reservation `json:"-"`
 }
 type UserHistoryRequest struct {
 	Limit  *int    `query:"limit" validate:"omitempty,gte=0"`
@@ -0,0 +1,30 @@
+package models
+
+// Statuses of activations. Plain activations are confirmed right away, a
+// reservation holds a code until it is confirmed, released or expires.
+const (
+	ActivationReserved  = "RESERVED"
+	ActivationConfirmed = "CONFIRMED"
+	ActivationReleased  = "RELEASED"
+	ActivationExpired   = "EXPIRED"
+)
+
+type Reservation struct {
+	ID        string `db:"reservation_id"`
+	PromoID   string `db:"promo_id"`
+	UserID    string `db:"id"`
+	Code      string `db:"-"`
+	Status    string `db:"status"`
+	ExpiresAt int64  `db:"expires_at"`
+}
+type ReservationRequest struct {
+	ID     *string `param:"id" validate:"required,uuid"`
+	UserID string  `json:"-"`
+}
+type ReservationResponse struct {
+	ReservationID string `json:"reservation_id"`
+	PromoID       string `json:"promo_id"`
+	Promo         string `json:"promo"`
+	Status        string `json:"status"`
+	ExpiresAt     string `json:"expires_at"`
+}
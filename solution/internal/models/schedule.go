@@ -0,0 +1,37 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+)
+
+// Schedule limits a promo to recurring weekly windows, read in an IANA time
+// zone.
+type Schedule struct {
+	TimeZone string           `json:"time_zone,omitempty" validate:"required_with=Windows,omitempty,timezone"`
+	Windows  []ScheduleWindow `json:"windows,omitempty" validate:"required_with=TimeZone,omitempty,lte=50,dive"`
+}
+
+// ScheduleWindow is open on each of Days from From until Until, local time. A
+// window whose Until is not after From runs past midnight into the next day.
+type ScheduleWindow struct {
+	Days  []string `json:"days" validate:"required,gte=1,lte=7,unique,dive,oneof=MON TUE WED THU FRI SAT SUN"`
+	From  string   `json:"from" validate:"required,datetime=15:04"`
+	Until string   `json:"until" validate:"required,datetime=15:04"`
+}
+
+func (s Schedule) Empty() bool {
+	return s.TimeZone == "" && len(s.Windows) == 0
+}
+
+func (s Schedule) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+func (s *Schedule) Scan(value interface{}) error {
+	b, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+	return json.Unmarshal(b, s)
+}
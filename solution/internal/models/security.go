@@ -0,0 +1,34 @@
+package models
+
+const (
+	SecurityLockout   = "signin.lockout"
+	SecurityIPLockout = "signin.ip_lockout"
+	SecurityUnlock    = "signin.unlock"
+)
+
+type SecurityEvent struct {
+	ID        int64   `db:"id"`
+	Event     string  `db:"event"`
+	Scope     *string `db:"scope"`
+	Email     *string `db:"email"`
+	IP        *string `db:"ip"`
+	Details   *string `db:"details"`
+	CreatedAt int64   `db:"created_at"`
+}
+
+type UnlockAccountRequest struct {
+	Scope *string `json:"scope" validate:"required,oneof='business' 'user'"`
+	Email *string `json:"email" validate:"required,email,gte=8,lte=120"`
+	// IP also lifts the lockout of a client address, e.g. the one the user
+	// signs in from.
+	IP *string `json:"ip,omitempty" validate:"omitempty,ip"`
+}
+
+// LegacyPassword is an account password still stored AES encrypted, from
+// before passwords were hashed with bcrypt.
+type LegacyPassword struct {
+	AccountID string
+	Role      string
+	Email     string
+	Password  []byte
+}
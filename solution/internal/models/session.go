@@ -0,0 +1,39 @@
+package models
+
+type Session struct {
+	ID          string `json:"id" db:"id" redis:"id"`
+	AccountID   string `json:"account_id" db:"account_id" redis:"account_id"`
+	Role        string `json:"role" db:"role" redis:"role"`
+	RefreshHash []byte `json:"-" db:"refresh_hash" redis:"-"`
+	UserAgent   string `json:"user_agent" db:"user_agent" redis:"-"`
+	IP          string `json:"ip" db:"ip" redis:"-"`
+	CreatedAt   int64  `json:"created_at" db:"created_at" redis:"-"`
+	LastUsedAt  int64  `json:"last_used_at" db:"last_used_at" redis:"-"`
+	ExpiresAt   int64  `json:"expires_at" db:"expires_at" redis:"expires_at"`
+	RevokedAt   *int64 `json:"-" db:"revoked_at" redis:"-"`
+	ConfirmedAt *int64 `json:"-" db:"confirmed_at" redis:"-"`
+}
+
+type TokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	SessionID    string `json:"-"`
+}
+type RefreshTokenRequest struct {
+	RefreshToken *string `json:"refresh_token" validate:"required,lte=300"`
+}
+type SessionRequest struct {
+	ID *string `param:"id" validate:"required,uuid"`
+}
+type SignOutRequest struct {
+	All bool `json:"all"`
+}
+type SessionResponse struct {
+	ID         string `json:"id"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	IP         string `json:"ip,omitempty"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at"`
+	ExpiresAt  string `json:"expires_at"`
+	Current    bool   `json:"current"`
+}
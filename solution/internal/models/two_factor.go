@@ -0,0 +1,32 @@
+package models
+
+type TwoFactor struct {
+	AccountID string `db:"account_id"`
+	Role      string `db:"role"`
+	Secret    string `db:"secret"`
+	LastStep  int64  `db:"last_step"`
+	CreatedAt int64  `db:"created_at"`
+	EnabledAt *int64 `db:"enabled_at"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code *string `json:"code" validate:"required,gte=6,lte=20"`
+}
+type TwoFactorSignInRequest struct {
+	MFAToken *string `json:"mfa_token" validate:"required,lte=300"`
+	Code     *string `json:"code" validate:"required,gte=6,lte=20"`
+}
+type TwoFactorStatusResponse struct {
+	Enabled bool `json:"enabled"`
+}
+type TwoFactorEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+type TwoFactorChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
@@ -6,12 +6,13 @@ import (
 	"fmt"
 	"solution/internal/models"
 	"solution/internal/service"
+	"solution/internal/utils"
 	"solution/pkg/db/postgres"
 	"strings"
 	"time"
 
 	sq "github.com/Masterminds/squirrel"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type PostgresRepo struct {
@@ -32,13 +33,13 @@ func (pr *PostgresRepo) TestCompanyRegistration(ctx context.Context, company mod
 }
 func (pr *PostgresRepo) GetCompanyByEmail(ctx context.Context, company models.Company) (*models.Company, error) {
 	var res models.Company
-	err := sq.Select("company_id", "name", "password").
+	err := sq.Select("company_id", "name", "password", "time_zone").
 		From("companies").
 		Where(sq.Eq{"email": company.Email}).
 		PlaceholderFormat(sq.Dollar).
 		RunWith(pr.db.Db).
 		QueryRow().
-		Scan(&res.CompanyID, &res.Name, &res.Password)
+		Scan(&res.CompanyID, &res.Name, &res.Password, &res.TimeZone)
 	if err != nil {
 		return nil, err
 	}
@@ -47,13 +48,13 @@ func (pr *PostgresRepo) GetCompanyByEmail(ctx context.Context, company models.Co
 }
 func (pr *PostgresRepo) GetCompanyById(ctx context.Context, company models.Company) (*models.Company, error) {
 	var res models.Company
-	err := sq.Select("email", "name", "password").
+	err := sq.Select("email", "name", "password", "time_zone").
 		From("companies").
 		Where(sq.Eq{"company_id": company.CompanyID}).
 		PlaceholderFormat(sq.Dollar).
 		RunWith(pr.db.Db).
 		QueryRow().
-		Scan(&res.Email, &res.Name, &res.Password)
+		Scan(&res.Email, &res.Name, &res.Password, &res.TimeZone)
 	if err != nil {
 		return nil, err
 	}
@@ -62,8 +63,8 @@ func (pr *PostgresRepo) GetCompanyById(ctx context.Context, company models.Compa
 }
 func (pr *PostgresRepo) AddCompany(ctx context.Context, company models.Company) error {
 	_, err := sq.Insert("companies").
-		Columns("company_id", "email", "name", "password").
-		Values(company.CompanyID, company.Email, company.Name, company.Password).
+		Columns("company_id", "email", "name", "password", "time_zone").
+		Values(company.CompanyID, company.Email, company.Name, company.Password, company.TimeZone).
 		PlaceholderFormat(sq.Dollar).
 		RunWith(pr.db.Db).
 		Exec()
@@ -72,28 +73,243 @@ func (pr *PostgresRepo) AddCompany(ctx context.Context, company models.Company)
 	}
 	return nil
 }
-func (pr *PostgresRepo) CreatePromo(ctx context.Context, promo *models.Promo) error {
-	_, err := sq.Insert("promos").
-		Columns("description,image_url,target,max_count,active_from,active_until,mode,promo_common,promo_unique,used_promo_unique,promo_id,company_id,company_name,like_count,used_count,comment_count,active").
+func (pr *PostgresRepo) UpdateCompanyPassword(ctx context.Context, company models.Company) error {
+	_, err := sq.Update("companies").
+		Set("password", company.Password).
+		Where(sq.Eq{"company_id": company.CompanyID}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		Exec()
+	return err
+}
+func (pr *PostgresRepo) UpdateCompanyTimeZone(ctx context.Context, companyID, timeZone string) error {
+	_, err := sq.Update("companies").
+		Set("time_zone", timeZone).
+		Where(sq.Eq{"company_id": companyID}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	return err
+}
+
+// CreatePromo stores the promo together with its codes and the job that
+// generates the rest, if any.
+func (pr *PostgresRepo) CreatePromo(ctx context.Context, promo *models.Promo, job *models.CodeGenerationJob) error {
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = sq.Insert("promos").
+		Columns("description,image_url,target,max_count,active_from,active_until,mode,promo_common,promo_id,company_id,company_name,like_count,used_count,comment_count,active,publish_at,activation_policy,schedule,benefit").
 		Values(promo.Description, promo.ImageUrl, promo.Target, promo.MaxCount,
-			promo.ActiveFrom, promo.ActiveUntil, promo.Mode, promo.PromoCommon, promo.PromoUnique, models.StringSlice{},
+			promo.ActiveFrom, promo.ActiveUntil, promo.Mode, promo.PromoCommon,
 			promo.PromoId, promo.CompanyId, promo.CompanyName, promo.LikeCount,
-			promo.UsedCount, 0, promo.Active).
+			promo.UsedCount, 0, promo.Active, promo.PublishAt, promo.ActivationPolicy, promo.Schedule, promo.Benefit).
 		PlaceholderFormat(sq.Dollar).
-		RunWith(pr.db.Db).
+		RunWith(tx).
 		Exec()
 	if err != nil {
 		return err
 	}
+	if len(promo.PromoUnique) > 0 {
+		if err := lockCompanyCodes(ctx, tx, *promo.CompanyId); err != nil {
+			return err
+		}
+		taken, err := takenCodes(ctx, tx, *promo.CompanyId, promo.PromoUnique)
+		if err != nil {
+			return err
+		}
+		if len(taken) > 0 {
+			return service.ErrCodeTaken
+		}
+	}
+	if err := insertPromoCodes(ctx, tx, *promo.CompanyId, *promo.PromoId, promo.PromoUnique); err != nil {
+		return err
+	}
+	if job != nil {
+		if err := insertGenerationJob(ctx, tx, *job); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// lockCompanyCodes serializes transactions adding codes to the promos of one
+// company, so a code checked to be free stays free until commit.
+func lockCompanyCodes(ctx context.Context, tx *sql.Tx, companyID string) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", companyID)
+	return err
+}
+
+// insertPromoCodes streams codes into promo_codes with COPY, so promos with
+// hundreds of thousands of codes are created in one round trip.
+func insertPromoCodes(ctx context.Context, tx *sql.Tx, companyID, promoID string, codes []string) error {
+	if len(codes) == 0 {
+		return nil
+	}
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("promo_codes", "company_id", "promo_id", "code", "status"))
+	if err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if _, err := stmt.ExecContext(ctx, companyID, promoID, code, models.PromoCodeAvailable); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return err
+	}
+	return stmt.Close()
+}
+
+// promoCodesPreview caps the codes listed in promo responses, which is as many
+// as a promo can be created with. The rest are served by the codes export.
+const promoCodesPreview = 5000
+
+// getPromoCodes returns the first codes of each UNIQUE promo in the order they
+// were added, in a single query.
+func (pr *PostgresRepo) getPromoCodes(ctx context.Context, promoIDs []string) (map[string]models.StringSlice, error) {
+	codes := make(map[string]models.StringSlice, len(promoIDs))
+	if len(promoIDs) == 0 {
+		return codes, nil
+	}
+	rows, err := pr.db.Db.QueryContext(ctx, `SELECT promo_id, code FROM (
+	SELECT promo_id, code, id, row_number() OVER (PARTITION BY promo_id ORDER BY id) AS n
+	FROM promo_codes WHERE promo_id = ANY($1)
+) AS c WHERE n <= $2 ORDER BY promo_id, id`, pq.Array(promoIDs), promoCodesPreview)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var promoID, code string
+		if err := rows.Scan(&promoID, &code); err != nil {
+			return nil, err
+		}
+		codes[promoID] = append(codes[promoID], code)
+	}
+	return codes, rows.Err()
+}
+
+// setPromoCodes fills PromoUnique of the UNIQUE promos among promos.
+func (pr *PostgresRepo) setPromoCodes(ctx context.Context, promos ...*models.GetPromoResponse) error {
+	var promoIDs []string
+	for _, promo := range promos {
+		if *promo.Mode == "UNIQUE" {
+			promoIDs = append(promoIDs, *promo.PromoId)
+		}
+	}
+	codes, err := pr.getPromoCodes(ctx, promoIDs)
+	if err != nil {
+		return err
+	}
+	for _, promo := range promos {
+		if *promo.Mode == "UNIQUE" {
+			promo.PromoUnique = codes[*promo.PromoId]
+			if promo.PromoUnique == nil {
+				promo.PromoUnique = models.StringSlice{}
+			}
+		}
+	}
 	return nil
 }
+
+func (pr *PostgresRepo) GetPromoCode(ctx context.Context, promoID, code string) (*models.PromoCode, error) {
+	var res models.PromoCode
+	err := sq.Select("id", "promo_id", "code", "status", "user_id", "activated_at").
+		From("promo_codes").
+		Where(sq.Eq{"promo_id": promoID, "code": code}).
+		OrderBy("activated_at DESC NULLS LAST").
+		Limit(1).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx).
+		Scan(&res.ID, &res.PromoId, &res.Code, &res.Status, &res.UserID, &res.ActivatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// availableCodesExpr is a column expression telling whether a UNIQUE promo still
+// has codes to hand out.
+const availableCodesExpr = "EXISTS(SELECT 1 FROM promo_codes WHERE promo_codes.promo_id = promos.promo_id AND promo_codes.status = 'AVAILABLE')"
+
+// scheduleOpenAt is a column expression telling whether the weekly schedule
+// of a promo, if it has one, lets it be used at the timestamptz at.
+func scheduleOpenAt(at string) string {
+	return `(promos.schedule IS NULL OR EXISTS(
+	SELECT 1 FROM jsonb_array_elements(promos.schedule->'windows') AS w,
+		jsonb_array_elements_text(w->'days') AS d(day),
+		(SELECT ` + at + ` AT TIME ZONE (promos.schedule->>'time_zone') AS t) AS l
+	WHERE (d.day = to_char(l.t, 'DY') AND l.t::time >= (w->>'from')::time
+			AND ((w->>'until')::time <= (w->>'from')::time OR l.t::time < (w->>'until')::time))
+		OR (d.day = to_char(l.t - interval '1 day', 'DY') AND (w->>'until')::time <= (w->>'from')::time
+			AND l.t::time < (w->>'until')::time)))`
+}
+
+// scheduleOpenExpr tells whether the schedule of a promo lets it be used right
+// now.
+var scheduleOpenExpr = scheduleOpenAt("now()")
+
+// promoActiveExpr is the active column of company responses. The scheduler
+// keeps active in line with the schedule as well, this only covers the moment
+// until it gets to a window edge.
+var promoActiveExpr = "(active AND " + scheduleOpenExpr + ")"
+
+// companyPromoColumns are scanned into a GetPromoResponse, in this order.
+var companyPromoColumns = "description,image_url,target,max_count,active_from,active_until,mode,promo_common,promo_id,company_id,company_name,like_count,used_count," +
+	promoActiveExpr + ",archived_at,publish_at,activation_policy,schedule,benefit," +
+	"(SELECT time_zone FROM companies WHERE companies.company_id = promos.company_id LIMIT 1)"
+
+func timestamp(ts *int64) *string {
+	if ts == nil {
+		return nil
+	}
+	t := time.Unix(*ts, 0).UTC().Format(time.RFC3339)
+	return &t
+}
+
+// setActiveDates fills the activity bounds of a promo response, as days in the
+// company zone and as exact timestamps.
+func setActiveDates(resp *models.GetPromoResponse, activeFrom, activeUntil *int64) {
+	loc := utils.Location(resp.TimeZone)
+	if activeFrom != nil {
+		t := utils.FormatDate(*activeFrom, loc)
+		resp.ActiveFrom = &t
+	}
+	if activeUntil != nil {
+		t := utils.FormatDate(*activeUntil, loc)
+		resp.ActiveUntil = &t
+	}
+	resp.ActiveFromAt = timestamp(activeFrom)
+	resp.ActiveUntilAt = timestamp(activeUntil)
+}
+
+// setLifecycle fills the archive and publication fields of a promo response.
+func setLifecycle(resp *models.GetPromoResponse, archivedAt, publishAt *int64) {
+	resp.ArchivedAt = timestamp(archivedAt)
+	resp.PublishAt = timestamp(publishAt)
+	resp.Publication = models.PublicationState(publishAt, time.Now().Unix())
+}
+
 func (pr *PostgresRepo) GetPromos(ctx context.Context, sortRules *models.CompanySort) ([]models.GetPromoResponse, int, error) {
 	promos := make([]models.GetPromoResponse, 0)
-	selectBuilder := sq.Select("description,image_url,target,max_count,active_from,active_until,mode,promo_common,promo_unique,promo_id,company_id,company_name,like_count,used_count,active").
+	selectBuilder := sq.Select(companyPromoColumns).
 		From("promos").
 		Where(sq.Eq{"company_id": sortRules.CompanyId}).
 		PlaceholderFormat(sq.Dollar).
 		RunWith(pr.db.Db)
+	switch sortRules.State {
+	case models.PromoStateArchived:
+		selectBuilder = selectBuilder.Where(sq.NotEq{"archived_at": nil})
+	case models.PromoStateAll:
+	default:
+		selectBuilder = selectBuilder.Where(sq.Eq{"archived_at": nil})
+	}
 	if sortRules.SortBy != "" {
 		selectBuilder = selectBuilder.OrderBy(fmt.Sprintf("%s DESC", sortRules.SortBy))
 	}
@@ -112,60 +328,61 @@ func (pr *PostgresRepo) GetPromos(ctx context.Context, sortRules *models.Company
 	var count int = 0
 	for rows.Next() {
 		var promo models.GetPromoResponse
-		var ActiveFrom, ActiveUntil *int64
-		err := rows.Scan(&promo.Description, &promo.ImageUrl, &promo.Target, &promo.MaxCount, &ActiveFrom, &ActiveUntil, &promo.Mode, &promo.PromoCommon, &promo.PromoUnique, &promo.PromoId, &promo.CompanyId, &promo.CompanyName, &promo.LikeCount, &promo.UsedCount, &promo.Active) //
+		var ActiveFrom, ActiveUntil, ArchivedAt, PublishAt *int64
+		err := rows.Scan(&promo.Description, &promo.ImageUrl, &promo.Target, &promo.MaxCount, &ActiveFrom, &ActiveUntil, &promo.Mode, &promo.PromoCommon, &promo.PromoId, &promo.CompanyId, &promo.CompanyName, &promo.LikeCount, &promo.UsedCount, &promo.Active, &ArchivedAt, &PublishAt, &promo.ActivationPolicy, &promo.Schedule, &promo.Benefit, &promo.TimeZone) //
 		if err != nil {
 			return nil, 0, err
 		}
 		if sortRules.Offset <= count && len(promos) < sortRules.Limit {
-
-			if ActiveFrom != nil {
-				t := time.Unix(*ActiveFrom, 0).Format("2006-01-02")
-				promo.ActiveFrom = &t
-			}
-			if ActiveUntil != nil {
-				t := time.Unix(*ActiveUntil, 0).Format("2006-01-02")
-				promo.ActiveUntil = &t
-			}
+			setActiveDates(&promo, ActiveFrom, ActiveUntil)
+			setLifecycle(&promo, ArchivedAt, PublishAt)
 
 			promos = append(promos, promo)
 		}
 		count++
 
 	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	page := make([]*models.GetPromoResponse, len(promos))
+	for i := range promos {
+		page[i] = &promos[i]
+	}
+	if err := pr.setPromoCodes(ctx, page...); err != nil {
+		return nil, 0, err
+	}
 	return promos, count, nil
 }
 func (pr *PostgresRepo) GetPromo(ctx context.Context, promo models.Promo) (*models.GetPromoResponse, error) {
 	var resp models.GetPromoResponse
-	var ActiveFrom, ActiveUntil *int64
-	err := sq.Select("description,image_url,target,max_count,active_from,active_until,mode,promo_common,promo_unique,promo_id,company_id,company_name,like_count,used_count,active").
+	var ActiveFrom, ActiveUntil, ArchivedAt, PublishAt *int64
+	err := sq.Select(companyPromoColumns).
 		From("promos").
 		Where(sq.And{sq.Eq{"promo_id": promo.PromoId}, sq.Eq{"company_id": promo.CompanyId}}).
 		PlaceholderFormat(sq.Dollar).
 		RunWith(pr.db.Db).
-		Scan(&resp.Description, &resp.ImageUrl, &resp.Target, &resp.MaxCount, &ActiveFrom, &ActiveUntil, &resp.Mode, &resp.PromoCommon, &resp.PromoUnique, &resp.PromoId, &resp.CompanyId, &resp.CompanyName, &resp.LikeCount, &resp.UsedCount, &resp.Active)
+		Scan(&resp.Description, &resp.ImageUrl, &resp.Target, &resp.MaxCount, &ActiveFrom, &ActiveUntil, &resp.Mode, &resp.PromoCommon, &resp.PromoId, &resp.CompanyId, &resp.CompanyName, &resp.LikeCount, &resp.UsedCount, &resp.Active, &ArchivedAt, &PublishAt, &resp.ActivationPolicy, &resp.Schedule, &resp.Benefit, &resp.TimeZone)
 	if err != nil {
 		return nil, err
 	}
-	if ActiveFrom != nil {
-		t := time.Unix(*ActiveFrom, 0).Format("2006-01-02")
-		resp.ActiveFrom = &t
-	}
-	if ActiveUntil != nil {
-		t := time.Unix(*ActiveUntil, 0).Format("2006-01-02")
-		resp.ActiveUntil = &t
+	if err := pr.setPromoCodes(ctx, &resp); err != nil {
+		return nil, err
 	}
+	setActiveDates(&resp, ActiveFrom, ActiveUntil)
+	setLifecycle(&resp, ArchivedAt, PublishAt)
 	return &resp, nil
 }
 func (pr *PostgresRepo) GetPromoById(ctx context.Context, promo models.Promo) (*models.Promo, error) {
 	var resp models.Promo
 	var ActiveFrom, ActiveUntil *int64
-	err := sq.Select("description,image_url,target,max_count,active_from,active_until,mode,promo_common,promo_unique,used_promo_unique,promo_id,company_id,company_name,like_count,used_count,comment_count,active").
+	err := sq.Select("description,image_url,target,max_count,active_from,active_until,mode,promo_common,promo_id,company_id,company_name,like_count,used_count,comment_count,active,archived_at,publish_at,activation_policy,schedule,benefit").
+		Column("(SELECT count(*) FROM promo_codes WHERE promo_codes.promo_id = promos.promo_id AND promo_codes.status = 'AVAILABLE') AS available_codes").
 		From("promos").
 		Where(sq.Eq{"promo_id": promo.PromoId}).
 		PlaceholderFormat(sq.Dollar).
 		RunWith(pr.db.Db).
-		Scan(&resp.Description, &resp.ImageUrl, &resp.Target, &resp.MaxCount, &ActiveFrom, &ActiveUntil, &resp.Mode, &resp.PromoCommon, &resp.PromoUnique, &promo.UsedPromoUnique, &resp.PromoId, &resp.CompanyId, &resp.CompanyName, &resp.LikeCount, &resp.UsedCount, &resp.CommentCount, &resp.Active)
+		Scan(&resp.Description, &resp.ImageUrl, &resp.Target, &resp.MaxCount, &ActiveFrom, &ActiveUntil, &resp.Mode, &resp.PromoCommon, &resp.PromoId, &resp.CompanyId, &resp.CompanyName, &resp.LikeCount, &resp.UsedCount, &resp.CommentCount, &resp.Active, &resp.ArchivedAt, &resp.PublishAt, &resp.ActivationPolicy, &resp.Schedule, &resp.Benefit, &resp.AvailableCodes)
 	if err != nil {
 		return nil, err
 	}
@@ -201,58 +418,118 @@ func (pr *PostgresRepo) EditPromo(ctx context.Context, promo *models.Promo) (*mo
 	if promo.ActiveUntil != nil {
 		sets["active_until"] = promo.ActiveUntil
 	}
+	if promo.ActivationPolicy != nil {
+		if promo.ActivationPolicy.Empty() {
+			sets["activation_policy"] = nil
+		} else {
+			sets["activation_policy"] = promo.ActivationPolicy
+		}
+	}
+	if promo.Schedule != nil {
+		if promo.Schedule.Empty() {
+			sets["schedule"] = nil
+		} else {
+			sets["schedule"] = promo.Schedule
+		}
+	}
+	if promo.Benefit != nil {
+		if promo.Benefit.Empty() {
+			sets["benefit"] = nil
+		} else {
+			sets["benefit"] = promo.Benefit
+		}
+	}
 
-	updateBuileder := sq.Update("promos").
-		Where(sq.Eq{"promo_id": *promo.PromoId}).Set("active", promo.Active).
-		SetMap(sets).
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if len(sets) > 0 {
+		_, err = sq.Update("promos").
+			Where(sq.Eq{"promo_id": *promo.PromoId}).
+			SetMap(sets).
+			PlaceholderFormat(sq.Dollar).
+			RunWith(tx).
+			ExecContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// New dates or counts may turn the promo on or off right away.
+	if _, err := syncPromoActive(ctx, tx, time.Now().Unix(), *promo.PromoId); err != nil {
+		return nil, err
+	}
+	var ActiveFrom, ActiveUntil, ArchivedAt, PublishAt *int64
+	err = sq.Select(companyPromoColumns).
+		From("promos").
+		Where(sq.Eq{"promo_id": *promo.PromoId}).
 		PlaceholderFormat(sq.Dollar).
-		RunWith(pr.db.Db).
-		Suffix("RETURNING description,image_url,target,max_count,active_from,active_until,mode,promo_common,promo_unique,promo_id,company_id,company_name,like_count,used_count,active")
-
-	var ActiveFrom, ActiveUntil *int64
-	err := updateBuileder.QueryRow().Scan(&resp.Description, &resp.ImageUrl, &resp.Target, &resp.MaxCount, &ActiveFrom, &ActiveUntil, &resp.Mode, &resp.PromoCommon, &resp.PromoUnique, &resp.PromoId, &resp.CompanyId, &resp.CompanyName, &resp.LikeCount, &resp.UsedCount, &resp.Active)
+		RunWith(tx).
+		QueryRowContext(ctx).
+		Scan(&resp.Description, &resp.ImageUrl, &resp.Target, &resp.MaxCount, &ActiveFrom, &ActiveUntil, &resp.Mode, &resp.PromoCommon, &resp.PromoId, &resp.CompanyId, &resp.CompanyName, &resp.LikeCount, &resp.UsedCount, &resp.Active, &ArchivedAt, &PublishAt, &resp.ActivationPolicy, &resp.Schedule, &resp.Benefit, &resp.TimeZone)
 	if err != nil {
 		return nil, err
 	}
-	if ActiveFrom != nil {
-		t := time.Unix(*ActiveFrom, 0).Format("2006-01-02")
-		resp.ActiveFrom = &t
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
-	if ActiveUntil != nil {
-		t := time.Unix(*ActiveUntil, 0).Format("2006-01-02")
-		resp.ActiveUntil = &t
+	if err := pr.setPromoCodes(ctx, &resp); err != nil {
+		return nil, err
 	}
+	setActiveDates(&resp, ActiveFrom, ActiveUntil)
+	setLifecycle(&resp, ArchivedAt, PublishAt)
 	return &resp, nil
 }
 func (pr *PostgresRepo) GetPromoStat(ctx context.Context, promo models.GetPromoStatRequest) (*models.GetPromoStatResponse, error) {
 	var resp models.GetPromoStatResponse
-	rows, err := sq.Select("country").
+	rows, err := sq.Select("country", "status", "count(*)").
 		From("activations").
 		Where(sq.Eq{"promo_id": promo.PromoID}).
+		GroupBy("country", "status").
+		OrderBy("country", "status").
 		PlaceholderFormat(sq.Dollar).
-		OrderBy("activate_time DESC").
-		RunWith(pr.db.Db).Query()
+		RunWith(pr.db.Db).
+		QueryContext(ctx)
 	if err != nil {
 		return nil, err
 	}
-	uses := make(map[string]int)
+	defer rows.Close()
+	var activations_count int
 	for rows.Next() {
-		var country string
-		err = rows.Scan(&country)
+		var country, status string
+		var count int
+		err = rows.Scan(&country, &status, &count)
 		if err != nil {
 			return nil, err
 		}
-		uses[country]++
+		switch status {
+		case models.ActivationConfirmed:
+			resp.Countries = append(resp.Countries, models.Country{Country: country, ActivationsCount: count})
+			activations_count += count
+		case models.ActivationReserved:
+			resp.ReservedCount += count
+		case models.ActivationReleased:
+			resp.ReleasedCount += count
+		case models.ActivationExpired:
+			resp.ExpiredCount += count
+		}
 	}
-	var activations_count int
-	for k, v := range uses {
-		var country models.Country
-		country.Country = k
-		country.ActivationsCount = v
-		activations_count += v
-		resp.Countries = append(resp.Countries, country)
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 	resp.ActivationsCount = activations_count
+	resp.IssuedCount = activations_count
+	err = sq.Select("count(*)").
+		From("redemptions").
+		Where(sq.Eq{"promo_id": promo.PromoID}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx).
+		Scan(&resp.RedeemedCount)
+	if err != nil {
+		return nil, err
+	}
 	return &resp, nil
 }
 func (pr *PostgresRepo) TestUserRegistration(ctx context.Context, user models.User) (bool, error) {
@@ -337,18 +614,17 @@ func (pr *PostgresRepo) UpdateUser(ctx context.Context, user *models.User) (*mod
 func (pr *PostgresRepo) FeedUser(ctx context.Context, sortRules *models.UserSort) ([]models.FeedUserResponse, int, error) {
 	promos := make([]models.FeedUserResponse, 0)
 	target := models.Target{}
-	q := `SELECT description, image_url, promo_id, company_id, active_from, 
-	active_until, mode, promo_unique, used_promo_unique, max_count, used_count, 
-	company_name, like_count, comment_count, active, target FROM promos
-	WHERE (lower(target ->> 'country') = $1 OR target ->> 'country' IS NULL) 
+	q := `SELECT description, image_url, promo_id, company_id, 
+	company_name, like_count, comment_count, active, target, benefit, ` + scheduleOpenExpr + ` FROM promos
+	WHERE archived_at IS NULL AND publish_at <= $4 AND (lower(target ->> 'country') = $1 OR target ->> 'country' IS NULL) 
 	AND ((target ->> 'age_from' <= $2 OR target ->> 'age_from' IS NULL) 
 	AND (target ->> 'age_until' >= $3 OR target ->> 'age_until' IS NULL))`
-	t := 4
+	t := 5
 	args := make([]interface{}, 0)
 	country := strings.ToLower(sortRules.Country)
-	args = append(args, &country, sortRules.Age, sortRules.Age)
+	args = append(args, &country, sortRules.Age, sortRules.Age, time.Now().Unix())
 	if sortRules.Active != nil {
-		q += fmt.Sprintf("AND (active = $%d)", t)
+		q += fmt.Sprintf("AND ((active AND "+scheduleOpenExpr+") = $%d)", t)
 		args = append(args, sortRules.Active)
 		t++
 	}
@@ -365,48 +641,13 @@ func (pr *PostgresRepo) FeedUser(ctx context.Context, sortRules *models.UserSort
 	var count int = 0
 	for rows.Next() {
 		if sortRules.Offset <= count && len(promos) < sortRules.Limit {
-			var ActiveFrom, ActiveUntil *int64
 			var promo models.FeedUserResponse
-			var mode string
-			var uniq, usedUniq models.StringSlice
-			var maxCount, usedCount int
-			scanErr := rows.Scan(&promo.Description, &promo.ImageUrl, &promo.PromoId, &promo.CompanyId, &ActiveFrom, &ActiveUntil, &mode, &uniq, &usedUniq, &maxCount, &usedCount, &promo.CompanyName, &promo.LikeCount, &promo.CommentCount, &promo.Active, &target) //
+			var open bool
+			scanErr := rows.Scan(&promo.Description, &promo.ImageUrl, &promo.PromoId, &promo.CompanyId, &promo.CompanyName, &promo.LikeCount, &promo.CommentCount, &promo.Active, &target, &promo.Benefit, &open) //
 			if scanErr != nil {
 				return nil, 0, scanErr
 			}
-			count, until, from := *promo.Active, *promo.Active, *promo.Active
-			now := time.Now().UTC().Add(3 * time.Hour).Unix()
-			if mode == "COMMON" {
-				if *promo.Active != (maxCount > usedCount) {
-					count = maxCount > usedCount
-				}
-			} else {
-				if *promo.Active != (len(uniq) > len(usedUniq)) {
-					count = len(uniq) > len(usedUniq)
-				}
-			}
-			if ActiveFrom != nil {
-				if *promo.Active != (*ActiveFrom > now) {
-					from = *ActiveFrom <= now
-				}
-
-			}
-			if ActiveUntil != nil {
-				if *promo.Active != (*ActiveUntil < now) {
-					until = *ActiveUntil >= now
-				}
-			}
-			if (count && until && from) != *promo.Active {
-				_, checkErr := sq.Update("promos").
-					Where(sq.Eq{"promo_id": promo.PromoId}).
-					Set("active", count && until && from).
-					RunWith(pr.db.Db).
-					Exec()
-				if checkErr != nil {
-					return nil, 0, checkErr
-				}
-				continue
-			}
+			*promo.Active = *promo.Active && open
 			statErr := sq.Select("is_liked_by_user").
 				From("promosstat").
 				Where(sq.And{sq.Eq{"id": sortRules.Id}, sq.Eq{"promo_id": *promo.PromoId}}).
@@ -423,7 +664,7 @@ func (pr *PostgresRepo) FeedUser(ctx context.Context, sortRules *models.UserSort
 			var activateTime *int64
 			activeErr := sq.Select("activate_time").
 				From("activations").
-				Where(sq.And{sq.Eq{"id": sortRules.Id}, sq.Eq{"promo_id": *promo.PromoId}}).
+				Where(sq.And{sq.Eq{"id": sortRules.Id}, sq.Eq{"promo_id": *promo.PromoId}, heldActivation}).
 				PlaceholderFormat(sq.Dollar).
 				RunWith(pr.db.Db).QueryRow().Scan(activateTime)
 			if activeErr != nil && activeErr != sql.ErrNoRows {
@@ -444,52 +685,17 @@ func (pr *PostgresRepo) FeedUser(ctx context.Context, sortRules *models.UserSort
 func (pr *PostgresRepo) UserGetPromo(ctx context.Context, req models.UserPromoRequest) (*models.FeedUserResponse, error) {
 	promo := models.FeedUserResponse{}
 	target := models.Target{}
-	var ActiveFrom, ActiveUntil *int64
-	var mode string
-	var uniq, usedUniq models.StringSlice
-	var maxCount, usedCount int
-	err := sq.Select("description", "image_url", "promo_id", "company_id", "active_from", "active_until", "mode", "promo_unique", "used_promo_unique", "max_count", "used_count", "company_name", "like_count", "comment_count", "active", "target").
+	var open bool
+	err := sq.Select("description", "image_url", "promo_id", "company_id", "company_name", "like_count", "comment_count", "active", "target", "benefit", scheduleOpenExpr).
 		From("promos").
-		Where(sq.Eq{"promo_id": req.PromoId}).
+		Where(sq.Eq{"promo_id": req.PromoId, "archived_at": nil}).
+		Where(sq.LtOrEq{"publish_at": time.Now().Unix()}).
 		PlaceholderFormat(sq.Dollar).
-		RunWith(pr.db.Db).Scan(&promo.Description, &promo.ImageUrl, &promo.PromoId, &promo.CompanyId, &ActiveFrom, &ActiveUntil, &mode, &uniq, &usedUniq, &maxCount, &usedCount, &promo.CompanyName, &promo.LikeCount, &promo.CommentCount, &promo.Active, &target) //
+		RunWith(pr.db.Db).Scan(&promo.Description, &promo.ImageUrl, &promo.PromoId, &promo.CompanyId, &promo.CompanyName, &promo.LikeCount, &promo.CommentCount, &promo.Active, &target, &promo.Benefit, &open) //
 	if err != nil {
 		return nil, err
 	}
-
-	count, until, from := *promo.Active, *promo.Active, *promo.Active
-	now := time.Now().UTC().Add(3 * time.Hour).Unix()
-	if mode == "COMMON" {
-		if *promo.Active != (maxCount > usedCount) {
-			count = maxCount > usedCount
-		}
-	} else {
-		if *promo.Active != (len(uniq) > len(usedUniq)) {
-			count = len(uniq) > len(usedUniq)
-		}
-	}
-	if ActiveFrom != nil {
-		if *promo.Active != (*ActiveFrom > now) {
-			from = *ActiveFrom <= now
-		}
-
-	}
-	if ActiveUntil != nil {
-		if *promo.Active != (*ActiveUntil < now) {
-			until = *ActiveUntil >= now
-		}
-	}
-	if (count && until && from) != *promo.Active {
-		_, checkErr := sq.Update("promos").
-			Where(sq.Eq{"promo_id": promo.PromoId}).
-			Set("active", count && until && from).
-			RunWith(pr.db.Db).
-			Exec()
-		if checkErr != nil {
-			return nil, checkErr
-		}
-		*promo.Active = count && until && from
-	}
+	*promo.Active = *promo.Active && open
 	statErr := sq.Select("is_liked_by_user").
 		From("promosstat").
 		Where(sq.And{sq.Eq{"id": req.ID}, sq.Eq{"promo_id": *promo.PromoId}}).
@@ -506,7 +712,7 @@ func (pr *PostgresRepo) UserGetPromo(ctx context.Context, req models.UserPromoRe
 	var activateTime int64
 	activeErr := sq.Select("activate_time").
 		From("activations").
-		Where(sq.And{sq.Eq{"id": req.ID}, sq.Eq{"promo_id": *promo.PromoId}}).
+		Where(sq.And{sq.Eq{"id": req.ID}, sq.Eq{"promo_id": *promo.PromoId}, heldActivation}).
 		PlaceholderFormat(sq.Dollar).
 		RunWith(pr.db.Db).QueryRow().Scan(&activateTime)
 	if activeErr != nil && activeErr != sql.ErrNoRows {
@@ -695,120 +901,172 @@ func (pr *PostgresRepo) UserDeleteComment(ctx context.Context, comment models.Us
 	return err
 }
 func (pr *PostgresRepo) UserActivatePromo(ctx context.Context, promo models.ActivateRequest) (string, error) {
-	var promocode models.Promo
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
 
+	var promocode models.Promo
 	var ActiveFrom, ActiveUntil *int64
-	err := sq.Select("max_count", "active_from", "active_until", "mode", "promo_common", "promo_unique", "used_promo_unique", "used_count", "active").
+	var open bool
+	// FOR UPDATE serializes concurrent activations of the same promo: the second
+	// transaction waits here and then sees the counters written by the first one.
+	err = sq.Select("max_count", "active_from", "active_until", "mode", "promo_common", "used_count", "activation_policy", scheduleOpenExpr).
 		From("promos").
-		Where(sq.Eq{"promo_id": promo.PromoID}).
-		Where(sq.Or{sq.Eq{"lower(target ->> 'country')": promo.Country}, sq.Eq{"target ->> 'country'": nil}}).
-		Where(sq.Or{sq.LtOrEq{"target ->> 'age_from'": promo.Age}, sq.Eq{"target ->> 'age_from'": nil}}).
-		Where(sq.Or{sq.GtOrEq{"target ->> 'age_until'": promo.Age}, sq.Eq{"target ->> 'age_until'": nil}}).
+		Where(sq.Eq{"promo_id": promo.PromoID, "archived_at": nil}).
+		Where(sq.LtOrEq{"publish_at": time.Now().Unix()}).
+		Where(promoTargets(promo.Country, promo.Age)).
+		Suffix("FOR UPDATE").
 		PlaceholderFormat(sq.Dollar).
-		RunWith(pr.db.Db).
-		Scan(&promocode.MaxCount, &ActiveFrom, &ActiveUntil, &promocode.Mode, &promocode.PromoCommon, &promocode.PromoUnique, &promocode.UsedPromoUnique, &promocode.UsedCount, &promocode.Active)
+		RunWith(tx).
+		Scan(&promocode.MaxCount, &ActiveFrom, &ActiveUntil, &promocode.Mode, &promocode.PromoCommon, &promocode.UsedCount, &promocode.ActivationPolicy, &open)
 	if err != nil {
 		return "", err
 	}
 	var res string
-	count, until, from := *promocode.Active, *promocode.Active, *promocode.Active
+	var codeID int64
+	var left bool
 	if *promocode.Mode == "COMMON" {
-		count = *promocode.MaxCount > *promocode.UsedCount
-		if count {
+		left = *promocode.MaxCount > *promocode.UsedCount
+		if left {
 			res = *promocode.PromoCommon
-			*promocode.UsedCount += 1
 		}
 	} else {
-		count = len(promocode.PromoUnique) > len(promocode.UsedPromoUnique)
-		if count {
-			used := make(map[string]int)
-			if promocode.UsedPromoUnique[0] == "" {
-				res = promocode.PromoUnique[0]
-
-				promocode.UsedPromoUnique = append(models.StringSlice{}, promocode.PromoUnique[0])
-				*promocode.UsedCount += 1
-			} else {
-				for _, code := range promocode.UsedPromoUnique {
-					used[code] += 1
-				}
-
-				for _, code := range promocode.PromoUnique {
-					_, ok := used[code]
-					if ok {
-						used[code] -= 1
-						if v := used[code]; v == 0 {
-							delete(used, code)
-						}
-					}
-					if !ok {
-						res = code
-						promocode.UsedPromoUnique = append(promocode.UsedPromoUnique, code)
-						*promocode.UsedCount += 1
-						break
-					}
-				}
-			}
+		err = sq.Select("id", "code").
+			From("promo_codes").
+			Where(sq.Eq{"promo_id": promo.PromoID, "status": models.PromoCodeAvailable}).
+			OrderBy("id").
+			Limit(1).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar).
+			RunWith(tx).
+			Scan(&codeID, &res)
+		if err != nil && err != sql.ErrNoRows {
+			return "", err
+		}
+		left = err == nil
+	}
+	now := time.Now().Unix()
+	until := ActiveUntil == nil || *ActiveUntil >= now
+	from := ActiveFrom == nil || *ActiveFrom <= now
+	if !(left && until && from) {
+		// The scheduler may not have turned the promo off yet.
+		if _, err := syncPromoActive(ctx, tx, now, *promo.PromoID); err != nil {
+			return "", err
 		}
+		if err := tx.Commit(); err != nil {
+			return "", err
+		}
+		return "", service.ErrNoPermission
 	}
-	now := time.Now().UTC().Add(3 * time.Hour).Unix()
-	if ActiveUntil != nil {
-		until = *ActiveUntil >= now
-
+	if !open {
+		return "", &service.ActivationDeniedError{Reason: models.OutsideSchedule}
 	}
-	if ActiveFrom != nil {
-		from = *ActiveFrom <= now
-
+	if promocode.ActivationPolicy != nil {
+		// The promo row is locked, so the user can't activate it concurrently
+		// between counting and inserting.
+		usage, err := activationUsage(ctx, tx, *promo.PromoID, *promo.UserID, now-promocode.ActivationPolicy.WindowSeconds)
+		if err != nil {
+			return "", err
+		}
+		if reason, retryAfter := promocode.ActivationPolicy.Check(*usage, now); reason != "" {
+			return "", &service.ActivationDeniedError{Reason: reason, RetryAfter: retryAfter}
+		}
 	}
-	active := count && until && from
-	if !active {
-		if active != *promocode.Active {
-			_, err := sq.Update("promos").
-				Set("active", active).
-				Where(sq.Eq{"promo_id": promo.PromoID}).
-				PlaceholderFormat(sq.Dollar).
-				RunWith(pr.db.Db).
-				Exec()
-			if err != nil {
-				return "", service.ErrNoPermission
-			}
+	// Reserved uses count as used until they are released or expire.
+	*promocode.UsedCount += 1
+	activation := sq.Insert("activations").
+		Columns("activate_time", "country", "promo_id", "id", "status")
+	var codeRef *int64
+	if *promocode.Mode == "UNIQUE" {
+		codeRef = &codeID
+		codeUpdate := sq.Update("promo_codes").
+			Set("user_id", promo.UserID).
+			Where(sq.Eq{"id": codeID})
+		if promo.Reservation != nil {
+			codeUpdate = codeUpdate.Set("status", models.PromoCodeReserved)
+		} else {
+			codeUpdate = codeUpdate.Set("status", models.PromoCodeActivated).Set("activated_at", now)
+		}
+		_, err = codeUpdate.
+			PlaceholderFormat(sq.Dollar).
+			RunWith(tx).
+			Exec()
+		if err != nil {
+			return "", err
 		}
-		return "", service.ErrNoPermission
 	}
-	if *promocode.Mode == "COMMON" {
-		count = *promocode.MaxCount > *promocode.UsedCount
-		promocode.UsedPromoUnique = models.StringSlice{}
+	if promo.Reservation != nil {
+		activation = activation.Columns("reservation_id", "code_id", "expires_at").
+			Values(now, promo.Country, promo.PromoID, promo.UserID, models.ActivationReserved, promo.Reservation.ID, codeRef, promo.Reservation.ExpiresAt)
 	} else {
-		count = len(promocode.PromoUnique) > len(promocode.UsedPromoUnique)
-
+		activation = activation.Columns("code_id").
+			Values(now, promo.Country, promo.PromoID, promo.UserID, models.ActivationConfirmed, codeRef)
 	}
-	active = count && until && from
 	_, err = sq.Update("promos").
-		Set("active", active).
 		Set("used_count", promocode.UsedCount).
-		Set("used_promo_unique", promocode.UsedPromoUnique).
 		Where(sq.Eq{"promo_id": promo.PromoID}).
 		PlaceholderFormat(sq.Dollar).
-		RunWith(pr.db.Db).
+		RunWith(tx).
 		Exec()
 	if err != nil {
-		return "", service.ErrNoPermission
+		return "", err
+	}
+	if _, err := syncPromoActive(ctx, tx, now, *promo.PromoID); err != nil {
+		return "", err
 	}
-	_, err = sq.Insert("activations").
-		Columns("activate_time", "country", "promo_id", "id").
-		Values(now, promo.Country, promo.PromoID, promo.UserID).
+	_, err = activation.
 		PlaceholderFormat(sq.Dollar).
-		RunWith(pr.db.Db).
+		RunWith(tx).
 		Exec()
 	if err != nil {
 		return "", err
 	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
 	return res, nil
 }
+
+// promoTargets matches promos aimed at a user of the country and age.
+func promoTargets(country, age interface{}) sq.And {
+	return sq.And{
+		sq.Or{sq.Eq{"lower(target ->> 'country')": country}, sq.Eq{"target ->> 'country'": nil}},
+		sq.Or{sq.LtOrEq{"target ->> 'age_from'": age}, sq.Eq{"target ->> 'age_from'": nil}},
+		sq.Or{sq.GtOrEq{"target ->> 'age_until'": age}, sq.Eq{"target ->> 'age_until'": nil}},
+	}
+}
+
+// heldActivation matches activations that hold a use of the promo.
+var heldActivation = sq.Eq{"activations.status": []string{models.ActivationReserved, models.ActivationConfirmed}}
+
+// activationUsage counts the activations of the promo by the user, windowed
+// ones being those after windowStart.
+func activationUsage(ctx context.Context, tx *sql.Tx, promoID, userID string, windowStart int64) (*models.ActivationUsage, error) {
+	var usage models.ActivationUsage
+	err := sq.Select("count(*)").
+		Column("count(*) FILTER (WHERE activate_time > ?)", windowStart).
+		Column("min(activate_time) FILTER (WHERE activate_time > ?)", windowStart).
+		Column("max(activate_time)").
+		From("activations").
+		Where(sq.Eq{"promo_id": promoID, "id": userID}).
+		Where(heldActivation).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		QueryRowContext(ctx).
+		Scan(&usage.Total, &usage.InWindow, &usage.FirstInWindow, &usage.Last)
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
 func (pr *PostgresRepo) GetUserHistory(ctx context.Context, sortRules *models.HistorySort) ([]models.FeedUserResponse, int, error) {
 	activations := []models.FeedUserResponse{}
 	rows, err := sq.Select("promo_id,activate_time").
 		From("activations").
 		Where(sq.Eq{"id": sortRules.UserID}).
+		Where(heldActivation).
 		PlaceholderFormat(sq.Dollar).
 		OrderBy("seq_id DESC").
 		RunWith(pr.db.Db).Query()
@@ -818,59 +1076,23 @@ func (pr *PostgresRepo) GetUserHistory(ctx context.Context, sortRules *models.Hi
 	var count int = 0
 	for rows.Next() {
 		if sortRules.Offset <= count && len(activations) < sortRules.Limit {
-			var ActiveFrom, ActiveUntil *int64
 			var promo models.FeedUserResponse
-			var mode string
-			var uniq, usedUniq models.StringSlice
-			var maxCount, usedCount int
 			var activate_time int64
 			scanErr := rows.Scan(&promo.PromoId, &activate_time)
 			if scanErr != nil {
 				return nil, 0, scanErr
 			}
-			err := sq.Select("company_id", "company_name", "description", "image_url", "active", "like_count", "comment_count", "used_count", "max_count", "active_from", "active_until", "mode", "promo_unique", "used_promo_unique").
+			var open bool
+			err := sq.Select("company_id", "company_name", "description", "image_url", "active", "like_count", "comment_count", "benefit", scheduleOpenExpr).
 				From("promos").
 				Where(sq.Eq{"promo_id": promo.PromoId}).
 				PlaceholderFormat(sq.Dollar).
 				RunWith(pr.db.Db).
-				Scan(&promo.CompanyId, &promo.CompanyName, &promo.Description, &promo.ImageUrl, &promo.Active, &promo.LikeCount, &promo.CommentCount, &usedCount, &maxCount, &ActiveFrom, &ActiveUntil, &mode, &uniq, &usedUniq)
+				Scan(&promo.CompanyId, &promo.CompanyName, &promo.Description, &promo.ImageUrl, &promo.Active, &promo.LikeCount, &promo.CommentCount, &promo.Benefit, &open)
 			if err != nil {
 				return nil, 0, err
 			}
-
-			count, until, from := *promo.Active, *promo.Active, *promo.Active
-			now := time.Now().UTC().Add(3 * time.Hour).Unix()
-			if mode == "COMMON" {
-				if *promo.Active != (maxCount > usedCount) {
-					count = maxCount > usedCount
-				}
-			} else {
-				if *promo.Active != (len(uniq) > len(usedUniq)) {
-					count = len(uniq) > len(usedUniq)
-				}
-			}
-			if ActiveFrom != nil {
-				if *promo.Active != (*ActiveFrom > now) {
-					from = *ActiveFrom <= now
-				}
-
-			}
-			if ActiveUntil != nil {
-				if *promo.Active != (*ActiveUntil < now) {
-					until = *ActiveUntil >= now
-				}
-			}
-			if (count && until && from) != *promo.Active {
-				_, checkErr := sq.Update("promos").
-					Where(sq.Eq{"promo_id": promo.PromoId}).
-					Set("active", count && until && from).
-					RunWith(pr.db.Db).
-					Exec()
-				if checkErr != nil {
-					return nil, 0, checkErr
-				}
-			}
-			*promo.Active = count && until && from
+			*promo.Active = *promo.Active && open
 			statErr := sq.Select("is_liked_by_user").
 				From("promosstat").
 				Where(sq.And{sq.Eq{"id": sortRules.UserID}, sq.Eq{"promo_id": *promo.PromoId}}).
@@ -892,3 +1114,1539 @@ func (pr *PostgresRepo) GetUserHistory(ctx context.Context, sortRules *models.Hi
 	}
 	return activations, count, nil
 }
+func (pr *PostgresRepo) CreateSession(ctx context.Context, session models.Session) error {
+	_, err := sq.Insert("sessions").
+		Columns("id", "account_id", "role", "refresh_hash", "user_agent", "ip", "created_at", "last_used_at", "expires_at", "confirmed_at").
+		Values(session.ID, session.AccountID, session.Role, session.RefreshHash, session.UserAgent, session.IP, session.CreatedAt, session.LastUsedAt, session.ExpiresAt, session.ConfirmedAt).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	return err
+}
+
+var sessionColumns = []string{"id", "account_id", "role", "refresh_hash", "COALESCE(user_agent, '')", "COALESCE(ip, '')", "created_at", "last_used_at", "expires_at", "revoked_at", "confirmed_at"}
+
+func scanSession(row sq.RowScanner) (*models.Session, error) {
+	var res models.Session
+	err := row.Scan(&res.ID, &res.AccountID, &res.Role, &res.RefreshHash, &res.UserAgent, &res.IP, &res.CreatedAt, &res.LastUsedAt, &res.ExpiresAt, &res.RevokedAt, &res.ConfirmedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+func (pr *PostgresRepo) GetSession(ctx context.Context, id string) (*models.Session, error) {
+	return scanSession(sq.Select(sessionColumns...).
+		From("sessions").
+		Where(sq.Eq{"id": id}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx))
+}
+
+// RotateSession swaps the refresh hash only when oldHash is still the current
+// one, so a refresh token can be exchanged exactly once.
+func (pr *PostgresRepo) RotateSession(ctx context.Context, id string, roles []string, oldHash, newHash []byte, now, expiresAt int64) (*models.Session, error) {
+	var res models.Session
+	err := sq.Update("sessions").
+		Set("refresh_hash", newHash).
+		Set("last_used_at", now).
+		Set("expires_at", expiresAt).
+		Where(sq.Eq{"id": id, "role": roles, "refresh_hash": oldHash, "revoked_at": nil}).
+		Where(sq.Gt{"expires_at": now}).
+		Suffix("RETURNING id, account_id, role, expires_at").
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx).
+		Scan(&res.ID, &res.AccountID, &res.Role, &res.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+func (pr *PostgresRepo) GetSessions(ctx context.Context, accountID, role string, now int64) ([]models.Session, error) {
+	rows, err := sq.Select(sessionColumns...).
+		From("sessions").
+		Where(sq.Eq{"account_id": accountID, "role": role, "revoked_at": nil}).
+		Where(sq.Gt{"expires_at": now}).
+		OrderBy("last_used_at DESC").
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []models.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *session)
+	}
+	return res, rows.Err()
+}
+
+// RevokeSession reports whether an active session of the account was revoked.
+func (pr *PostgresRepo) RevokeSession(ctx context.Context, id, accountID, role string, now int64) (bool, error) {
+	res, err := sq.Update("sessions").
+		Set("revoked_at", now).
+		Where(sq.Eq{"id": id, "account_id": accountID, "role": role, "revoked_at": nil}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+func (pr *PostgresRepo) RevokeSessions(ctx context.Context, accountID, role string, now int64) ([]string, error) {
+	rows, err := sq.Update("sessions").
+		Set("revoked_at", now).
+		Where(sq.Eq{"account_id": accountID, "role": role, "revoked_at": nil}).
+		Suffix("RETURNING id").
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+func (pr *PostgresRepo) CreateAPIKey(ctx context.Context, key models.APIKey) error {
+	_, err := sq.Insert("api_keys").
+		Columns("id", "company_id", "name", "prefix", "key_hash", "scopes", "created_at").
+		Values(key.ID, key.CompanyID, key.Name, key.Prefix, key.KeyHash, key.Scopes, key.CreatedAt).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	return err
+}
+func (pr *PostgresRepo) GetAPIKeys(ctx context.Context, companyID string) ([]models.APIKey, error) {
+	rows, err := sq.Select("id", "company_id", "name", "prefix", "scopes", "created_at", "last_used_at").
+		From("api_keys").
+		Where(sq.Eq{"company_id": companyID, "revoked_at": nil}).
+		OrderBy("created_at DESC").
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []models.APIKey{}
+	for rows.Next() {
+		var key models.APIKey
+		err := rows.Scan(&key.ID, &key.CompanyID, &key.Name, &key.Prefix, &key.Scopes, &key.CreatedAt, &key.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, key)
+	}
+	return res, rows.Err()
+}
+func (pr *PostgresRepo) GetAPIKeyByHash(ctx context.Context, hash []byte) (*models.APIKey, error) {
+	var key models.APIKey
+	err := sq.Select("id", "company_id", "name", "prefix", "scopes", "created_at", "last_used_at").
+		From("api_keys").
+		Where(sq.Eq{"key_hash": hash, "revoked_at": nil}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx).
+		Scan(&key.ID, &key.CompanyID, &key.Name, &key.Prefix, &key.Scopes, &key.CreatedAt, &key.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+func (pr *PostgresRepo) TouchAPIKey(ctx context.Context, id string, now int64) error {
+	_, err := sq.Update("api_keys").
+		Set("last_used_at", now).
+		Where(sq.Eq{"id": id}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	return err
+}
+func (pr *PostgresRepo) RevokeAPIKey(ctx context.Context, id, companyID string, now int64) (bool, error) {
+	res, err := sq.Update("api_keys").
+		Set("revoked_at", now).
+		Where(sq.Eq{"id": id, "company_id": companyID, "revoked_at": nil}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+func (pr *PostgresRepo) TestMemberRegistration(ctx context.Context, email string) (bool, error) {
+	var exist bool
+	err := pr.db.Db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM company_members WHERE email = $1 AND removed_at IS NULL)`, email).Scan(&exist)
+	if err != nil {
+		return false, err
+	}
+	return exist, nil
+}
+func (pr *PostgresRepo) AddMember(ctx context.Context, member models.CompanyMember) error {
+	_, err := sq.Insert("company_members").
+		Columns("id", "company_id", "email", "name", "role", "invite_hash", "invite_expires_at", "invited_by", "created_at").
+		Values(member.ID, member.CompanyID, member.Email, member.Name, member.Role, member.InviteHash, member.InviteExpiresAt, member.InvitedBy, member.CreatedAt).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	return err
+}
+
+var memberColumns = []string{"id", "company_id", "email", "name", "role", "password", "invite_expires_at", "invited_by", "created_at", "joined_at"}
+
+func scanMember(row sq.RowScanner) (*models.CompanyMember, error) {
+	var res models.CompanyMember
+	err := row.Scan(&res.ID, &res.CompanyID, &res.Email, &res.Name, &res.Role, &res.Password, &res.InviteExpiresAt, &res.InvitedBy, &res.CreatedAt, &res.JoinedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// AcceptInvite sets the password of an invited member once; the invite hash
+// is cleared so the token cannot be used again.
+func (pr *PostgresRepo) AcceptInvite(ctx context.Context, inviteHash, password []byte, now int64) (*models.CompanyMember, error) {
+	return scanMember(sq.Update("company_members").
+		Set("password", password).
+		Set("joined_at", now).
+		Set("invite_hash", nil).
+		Where(sq.Eq{"invite_hash": inviteHash, "joined_at": nil, "removed_at": nil}).
+		Where(sq.Gt{"invite_expires_at": now}).
+		Suffix("RETURNING " + strings.Join(memberColumns, ", ")).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx))
+}
+func (pr *PostgresRepo) GetMemberByEmail(ctx context.Context, email string) (*models.CompanyMember, error) {
+	return scanMember(sq.Select(memberColumns...).
+		From("company_members").
+		Where(sq.Eq{"email": email, "removed_at": nil}).
+		Where(sq.NotEq{"joined_at": nil}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx))
+}
+func (pr *PostgresRepo) GetMemberById(ctx context.Context, id string) (*models.CompanyMember, error) {
+	return scanMember(sq.Select(memberColumns...).
+		From("company_members").
+		Where(sq.Eq{"id": id, "removed_at": nil}).
+		Where(sq.NotEq{"joined_at": nil}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx))
+}
+func (pr *PostgresRepo) GetMembers(ctx context.Context, companyID string) ([]models.CompanyMember, error) {
+	rows, err := sq.Select(memberColumns...).
+		From("company_members").
+		Where(sq.Eq{"company_id": companyID, "removed_at": nil}).
+		OrderBy("created_at").
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []models.CompanyMember{}
+	for rows.Next() {
+		member, err := scanMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *member)
+	}
+	return res, rows.Err()
+}
+func (pr *PostgresRepo) UpdateMemberRole(ctx context.Context, companyID, id, role string) (*models.CompanyMember, error) {
+	return scanMember(sq.Update("company_members").
+		Set("role", role).
+		Where(sq.Eq{"id": id, "company_id": companyID, "removed_at": nil}).
+		Suffix("RETURNING " + strings.Join(memberColumns, ", ")).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx))
+}
+func (pr *PostgresRepo) RemoveMember(ctx context.Context, companyID, id string, now int64) (bool, error) {
+	res, err := sq.Update("company_members").
+		Set("removed_at", now).
+		Set("invite_hash", nil).
+		Where(sq.Eq{"id": id, "company_id": companyID, "removed_at": nil}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+func (pr *PostgresRepo) AddAuditRecord(ctx context.Context, record models.AuditRecord) error {
+	_, err := sq.Insert("audit_log").
+		Columns("company_id", "member_id", "api_key_id", "action", "target_id", "created_at").
+		Values(record.CompanyID, record.MemberID, record.APIKeyID, record.Action, record.TargetID, record.CreatedAt).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	return err
+}
+func (pr *PostgresRepo) GetAuditLog(ctx context.Context, companyID string, limit, offset int) ([]models.AuditRecord, int, error) {
+	var total int
+	err := sq.Select("COUNT(*)").
+		From("audit_log").
+		Where(sq.Eq{"company_id": companyID}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx).
+		Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := sq.Select("id", "company_id", "member_id", "api_key_id", "action", "target_id", "created_at").
+		From("audit_log").
+		Where(sq.Eq{"company_id": companyID}).
+		OrderBy("id DESC").
+		Limit(uint64(limit)).
+		Offset(uint64(offset)).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	res := []models.AuditRecord{}
+	for rows.Next() {
+		var record models.AuditRecord
+		err := rows.Scan(&record.ID, &record.CompanyID, &record.MemberID, &record.APIKeyID, &record.Action, &record.TargetID, &record.CreatedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		res = append(res, record)
+	}
+	return res, total, rows.Err()
+}
+
+// CreateOneTimeToken stores a new token and retires older unused tokens of
+// the same purpose, so only the latest email link works.
+func (pr *PostgresRepo) CreateOneTimeToken(ctx context.Context, token models.OneTimeToken) error {
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = sq.Update("one_time_tokens").
+		Set("used_at", token.CreatedAt).
+		Where(sq.Eq{"account_id": token.AccountID, "purpose": token.Purpose, "used_at": nil}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = sq.Insert("one_time_tokens").
+		Columns("token_hash", "purpose", "account_id", "role", "created_at", "expires_at").
+		Values(token.Hash, token.Purpose, token.AccountID, token.Role, token.CreatedAt, token.ExpiresAt).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+func (pr *PostgresRepo) UseOneTimeToken(ctx context.Context, hash []byte, purpose string, roles []string, now int64) (*models.OneTimeToken, error) {
+	return useOneTimeToken(ctx, pr.db.Db, hash, purpose, roles, now)
+}
+func useOneTimeToken(ctx context.Context, db sq.BaseRunner, hash []byte, purpose string, roles []string, now int64) (*models.OneTimeToken, error) {
+	var res models.OneTimeToken
+	err := sq.Update("one_time_tokens").
+		Set("used_at", now).
+		Where(sq.Eq{"token_hash": hash, "purpose": purpose, "role": roles, "used_at": nil}).
+		Where(sq.Gt{"expires_at": now}).
+		Suffix("RETURNING token_hash, purpose, account_id, role, created_at, expires_at, used_at").
+		PlaceholderFormat(sq.Dollar).
+		RunWith(db).
+		QueryRowContext(ctx).
+		Scan(&res.Hash, &res.Purpose, &res.AccountID, &res.Role, &res.CreatedAt, &res.ExpiresAt, &res.UsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ResetPassword spends a password reset token and sets the new password of
+// its account in one transaction, so the token stays usable when the update
+// fails. The link was delivered to the mailbox, which verifies the email too.
+func (pr *PostgresRepo) ResetPassword(ctx context.Context, hash []byte, roles []string, password []byte, now int64) (*models.OneTimeToken, error) {
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	token, err := useOneTimeToken(ctx, tx, hash, models.TokenPasswordReset, roles, now)
+	if err != nil {
+		return nil, err
+	}
+	update := sq.Update("company_members").
+		Set("password", password).
+		Where(sq.Eq{"id": token.AccountID, "removed_at": nil})
+	if account, ok := accountTables[token.Role]; ok {
+		update = sq.Update(account.table).
+			Set("password", password).
+			Set("email_verified_at", sq.Expr("COALESCE(email_verified_at, ?)", now)).
+			Where(sq.Eq{account.id: token.AccountID})
+	}
+	_, err = update.PlaceholderFormat(sq.Dollar).RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return token, tx.Commit()
+}
+
+// accountTables maps a session role to the table holding its email.
+var accountTables = map[string]struct{ table, id string }{
+	"company": {"companies", "company_id"},
+	"user":    {"users", "id"},
+}
+
+// GetLegacyPasswords lists the company and user passwords that are not bcrypt
+// hashes, which are always 60 bytes starting with "$2".
+func (pr *PostgresRepo) GetLegacyPasswords(ctx context.Context) ([]models.LegacyPassword, error) {
+	res := []models.LegacyPassword{}
+	for _, role := range []string{"company", "user"} {
+		account := accountTables[role]
+		rows, err := sq.Select(account.id, "email", "password").
+			From(account.table).
+			Where("(octet_length(password) <> 60 OR substring(password FROM 1 FOR 2) <> '$2'::bytea)").
+			PlaceholderFormat(sq.Dollar).
+			RunWith(pr.db.Db).
+			QueryContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			password := models.LegacyPassword{Role: role}
+			if err := rows.Scan(&password.AccountID, &password.Email, &password.Password); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			res = append(res, password)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// ReplaceLegacyPassword stores hash in place of the legacy password, unless
+// the password changed since it was read.
+func (pr *PostgresRepo) ReplaceLegacyPassword(ctx context.Context, password models.LegacyPassword, hash []byte) (bool, error) {
+	account, ok := accountTables[password.Role]
+	if !ok {
+		return false, fmt.Errorf("unknown account role %q", password.Role)
+	}
+	res, err := sq.Update(account.table).
+		Set("password", hash).
+		Where(sq.Eq{account.id: password.AccountID, "password": password.Password}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+func (pr *PostgresRepo) GetEmailVerification(ctx context.Context, accountID, role string) (string, *int64, error) {
+	account, ok := accountTables[role]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown account role %q", role)
+	}
+	var email string
+	var verifiedAt *int64
+	err := sq.Select("email", "email_verified_at").
+		From(account.table).
+		Where(sq.Eq{account.id: accountID}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx).
+		Scan(&email, &verifiedAt)
+	if err != nil {
+		return "", nil, err
+	}
+	return email, verifiedAt, nil
+}
+func (pr *PostgresRepo) MarkEmailVerified(ctx context.Context, accountID, role string, now int64) error {
+	account, ok := accountTables[role]
+	if !ok {
+		return nil
+	}
+	_, err := sq.Update(account.table).
+		Set("email_verified_at", sq.Expr("COALESCE(email_verified_at, ?)", now)).
+		Where(sq.Eq{account.id: accountID}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	return err
+}
+func (pr *PostgresRepo) AddSecurityEvent(ctx context.Context, event models.SecurityEvent) error {
+	_, err := sq.Insert("security_events").
+		Columns("event", "scope", "email", "ip", "details", "created_at").
+		Values(event.Event, event.Scope, event.Email, event.IP, event.Details, event.CreatedAt).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	return err
+}
+func (pr *PostgresRepo) ConfirmSession(ctx context.Context, id string, now int64) error {
+	_, err := sq.Update("sessions").
+		Set("confirmed_at", now).
+		Where(sq.Eq{"id": id, "revoked_at": nil}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	return err
+}
+
+// SaveTwoFactor stores a new pending secret for the account. It returns false
+// when two-factor authentication is already enabled.
+func (pr *PostgresRepo) SaveTwoFactor(ctx context.Context, tf models.TwoFactor) (bool, error) {
+	res, err := sq.Insert("two_factor").
+		Columns("account_id", "role", "secret", "created_at").
+		Values(tf.AccountID, tf.Role, tf.Secret, tf.CreatedAt).
+		Suffix("ON CONFLICT (account_id, role) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0, created_at = EXCLUDED.created_at WHERE two_factor.enabled_at IS NULL").
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+func (pr *PostgresRepo) GetTwoFactor(ctx context.Context, accountID, role string) (*models.TwoFactor, error) {
+	var res models.TwoFactor
+	err := sq.Select("account_id", "role", "secret", "last_step", "created_at", "enabled_at").
+		From("two_factor").
+		Where(sq.Eq{"account_id": accountID, "role": role}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx).
+		Scan(&res.AccountID, &res.Role, &res.Secret, &res.LastStep, &res.CreatedAt, &res.EnabledAt)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// UseTOTPStep remembers the time step of an accepted code. It returns false
+// when that step or a later one was already used.
+func (pr *PostgresRepo) UseTOTPStep(ctx context.Context, accountID, role string, step int64) (bool, error) {
+	res, err := sq.Update("two_factor").
+		Set("last_step", step).
+		Where(sq.Eq{"account_id": accountID, "role": role}).
+		Where(sq.Lt{"last_step": step}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, accountID, role string, hashes [][]byte) error {
+	_, err := sq.Delete("recovery_codes").
+		Where(sq.Eq{"account_id": accountID, "role": role}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+	insert := sq.Insert("recovery_codes").Columns("code_hash", "account_id", "role")
+	for _, hash := range hashes {
+		insert = insert.Values(hash, accountID, role)
+	}
+	_, err = insert.PlaceholderFormat(sq.Dollar).RunWith(tx).ExecContext(ctx)
+	return err
+}
+func (pr *PostgresRepo) EnableTwoFactor(ctx context.Context, accountID, role string, step int64, hashes [][]byte, now int64) (bool, error) {
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	res, err := sq.Update("two_factor").
+		Set("enabled_at", now).
+		Set("last_step", step).
+		Where(sq.Eq{"account_id": accountID, "role": role, "enabled_at": nil}).
+		Where(sq.Lt{"last_step": step}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		ExecContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, accountID, role, hashes); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+func (pr *PostgresRepo) ReplaceRecoveryCodes(ctx context.Context, accountID, role string, hashes [][]byte) error {
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := replaceRecoveryCodes(ctx, tx, accountID, role, hashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+func (pr *PostgresRepo) UseRecoveryCode(ctx context.Context, accountID, role string, hash []byte, now int64) (bool, error) {
+	res, err := sq.Update("recovery_codes").
+		Set("used_at", now).
+		Where(sq.Eq{"code_hash": hash, "account_id": accountID, "role": role, "used_at": nil}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+func (pr *PostgresRepo) DeleteTwoFactor(ctx context.Context, accountID, role string) error {
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := replaceRecoveryCodes(ctx, tx, accountID, role, nil); err != nil {
+		return err
+	}
+	_, err = sq.Delete("two_factor").
+		Where(sq.Eq{"account_id": accountID, "role": role}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+var generationJobColumns = []string{"id", "promo_id", "company_id", "prefix", "length", "alphabet", "check_digit", "total", "generated", "status", "error", "created_at", "updated_at", "finished_at"}
+
+func scanGenerationJob(row sq.RowScanner) (*models.CodeGenerationJob, error) {
+	var res models.CodeGenerationJob
+	err := row.Scan(&res.ID, &res.PromoID, &res.CompanyID, &res.Prefix, &res.Length, &res.Alphabet, &res.CheckDigit,
+		&res.Total, &res.Generated, &res.Status, &res.Error, &res.CreatedAt, &res.UpdatedAt, &res.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+func (pr *PostgresRepo) CreateGenerationJob(ctx context.Context, job models.CodeGenerationJob) error {
+	return insertGenerationJob(ctx, pr.db.Db, job)
+}
+func insertGenerationJob(ctx context.Context, db sq.BaseRunner, job models.CodeGenerationJob) error {
+	_, err := sq.Insert("code_generation_jobs").
+		Columns("id", "promo_id", "company_id", "prefix", "length", "alphabet", "check_digit", "total", "generated", "status", "created_at", "updated_at").
+		Values(job.ID, job.PromoID, job.CompanyID, job.Prefix, job.Length, job.Alphabet, job.CheckDigit, job.Total, job.Generated, job.Status, job.CreatedAt, job.UpdatedAt).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(db).
+		ExecContext(ctx)
+	return err
+}
+func (pr *PostgresRepo) GetGenerationJob(ctx context.Context, id string) (*models.CodeGenerationJob, error) {
+	return scanGenerationJob(sq.Select(generationJobColumns...).
+		From("code_generation_jobs").
+		Where(sq.Eq{"id": id}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx))
+}
+func (pr *PostgresRepo) GetUnfinishedGenerationJobs(ctx context.Context) ([]models.CodeGenerationJob, error) {
+	rows, err := sq.Select(generationJobColumns...).
+		From("code_generation_jobs").
+		Where(sq.Eq{"status": []string{models.JobPending, models.JobRunning}}).
+		OrderBy("created_at").
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []models.CodeGenerationJob{}
+	for rows.Next() {
+		job, err := scanGenerationJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *job)
+	}
+	return res, rows.Err()
+}
+func (pr *PostgresRepo) FailGenerationJob(ctx context.Context, id, reason string, now int64) error {
+	_, err := sq.Update("code_generation_jobs").
+		Set("status", models.JobFailed).
+		Set("error", reason).
+		Set("updated_at", now).
+		Set("finished_at", now).
+		Where(sq.Eq{"id": id}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	return err
+}
+
+// takenCodes returns which of codes are already used by promos of the company.
+func takenCodes(ctx context.Context, tx *sql.Tx, companyID string, codes []string) (map[string]bool, error) {
+	rows, err := sq.Select("code").
+		From("promo_codes").
+		Where(sq.Eq{"company_id": companyID}).
+		Where("code = ANY(?)", pq.Array(codes)).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	taken := map[string]bool{}
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		taken[code] = true
+	}
+	return taken, rows.Err()
+}
+
+// promoUsableExpr tells whether a promo has uses left.
+const promoUsableExpr = `(CASE WHEN promos.mode = 'UNIQUE' THEN ` + availableCodesExpr + ` ELSE promos.used_count < promos.max_count END)`
+
+// promoDueExpr tells whether a promo should be active at the epoch bound to
+// $1: it has uses left, is within its dates and its schedule is open.
+var promoDueExpr = `(` + promoUsableExpr + `
+	AND (promos.active_from IS NULL OR promos.active_from <= $1)
+	AND (promos.active_until IS NULL OR promos.active_until >= $1)
+	AND ` + scheduleOpenAt("to_timestamp($1::bigint)") + `)`
+
+// syncPromoActive flips the active flag of the given promos, or of all of them,
+// that disagrees with promoDueExpr at now and records each flip. A promo that
+// reopens after its schedule closed it is recorded as SCHEDULE_OPENED rather
+// than STARTED.
+func syncPromoActive(ctx context.Context, tx *sql.Tx, now int64, promoIDs ...string) (int64, error) {
+	args := []interface{}{now}
+	filter := ""
+	if len(promoIDs) > 0 {
+		filter = "AND promo_id = ANY($2)"
+		args = append(args, pq.Array(promoIDs))
+	}
+	q := fmt.Sprintf(`WITH changed AS (
+	UPDATE promos SET active = NOT active
+	WHERE active <> %s %s
+	RETURNING promo_id, active, active_from, active_until, %s AS usable
+)
+INSERT INTO promo_transitions (promo_id, active, reason, created_at)
+SELECT promo_id, active, CASE
+		WHEN active AND (SELECT reason FROM promo_transitions t WHERE t.promo_id = changed.promo_id ORDER BY id DESC LIMIT 1) = '%s' THEN '%s'
+		WHEN active THEN '%s'
+		WHEN active_until < $1 THEN '%s'
+		WHEN active_from > $1 THEN '%s'
+		WHEN NOT usable THEN '%s'
+		ELSE '%s' END, $1
+FROM changed`, promoDueExpr, filter, promoUsableExpr,
+		models.TransitionScheduleClosed, models.TransitionScheduleOpened, models.TransitionStarted,
+		models.TransitionExpired, models.TransitionNotStarted, models.TransitionExhausted, models.TransitionScheduleClosed)
+	res, err := tx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SyncPromoActive brings the active flag of every promo in line with its dates
+// and remaining uses at now, returning how many promos flipped.
+func (pr *PostgresRepo) SyncPromoActive(ctx context.Context, now int64) (int64, error) {
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	n, err := syncPromoActive(ctx, tx, now)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// NextPromoBoundary returns the first moment after now at which a promo starts
+// or ends, nil when there is none. Schedule windows open and close on whole
+// minutes, so while any promo has a schedule that is the next full minute.
+func (pr *PostgresRepo) NextPromoBoundary(ctx context.Context, now int64) (*int64, error) {
+	var next *int64
+	err := pr.db.Db.QueryRowContext(ctx, `SELECT min(b) FROM (
+	SELECT min(active_from) FROM promos WHERE active_from > $1
+	UNION ALL
+	SELECT min(active_until) + 1 FROM promos WHERE active_until >= $1
+	UNION ALL
+	SELECT ($1::bigint / 60 + 1) * 60 WHERE EXISTS(SELECT 1 FROM promos WHERE schedule IS NOT NULL AND archived_at IS NULL)
+) AS t(b)`, now).Scan(&next)
+	return next, err
+}
+func (pr *PostgresRepo) GetPromoTransitions(ctx context.Context, promoID string, limit, offset int) ([]models.PromoTransition, int, error) {
+	var total int
+	err := sq.Select("COUNT(*)").
+		From("promo_transitions").
+		Where(sq.Eq{"promo_id": promoID}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx).
+		Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := sq.Select("id", "promo_id", "active", "reason", "created_at").
+		From("promo_transitions").
+		Where(sq.Eq{"promo_id": promoID}).
+		OrderBy("id DESC").
+		Limit(uint64(limit)).
+		Offset(uint64(offset)).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	res := []models.PromoTransition{}
+	for rows.Next() {
+		var transition models.PromoTransition
+		err := rows.Scan(&transition.ID, &transition.PromoID, &transition.Active, &transition.Reason, &transition.CreatedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		res = append(res, transition)
+	}
+	return res, total, rows.Err()
+}
+
+// freeCodes generates n codes that no promo of the company uses yet.
+func freeCodes(ctx context.Context, tx *sql.Tx, companyID string, n int, generate func() (string, error)) ([]string, error) {
+	seen := make(map[string]bool, n)
+	res := make([]string, 0, n)
+	for attempt := 0; len(res) < n; attempt++ {
+		if attempt == 10 {
+			return nil, service.ErrCodeSpaceExhausted
+		}
+		batch := make([]string, 0, n-len(res))
+		for tries := 0; len(res)+len(batch) < n && tries < 10*n; tries++ {
+			code, err := generate()
+			if err != nil {
+				return nil, err
+			}
+			if !seen[code] {
+				seen[code] = true
+				batch = append(batch, code)
+			}
+		}
+		taken, err := takenCodes(ctx, tx, companyID, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, code := range batch {
+			if !taken[code] {
+				res = append(res, code)
+			}
+		}
+	}
+	return res, nil
+}
+
+// AddGeneratedCodes adds the next n codes of the job to its promo. It returns
+// false when another worker already advanced the job.
+func (pr *PostgresRepo) AddGeneratedCodes(ctx context.Context, job models.CodeGenerationJob, n int, generate func() (string, error), now int64) (bool, error) {
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if err := lockCompanyCodes(ctx, tx, job.CompanyID); err != nil {
+		return false, err
+	}
+	update := sq.Update("code_generation_jobs").
+		Set("generated", job.Generated+n).
+		Set("updated_at", now).
+		Where(sq.Eq{"id": job.ID, "generated": job.Generated, "status": []string{models.JobPending, models.JobRunning}})
+	if job.Generated+n >= job.Total {
+		update = update.Set("status", models.JobDone).Set("finished_at", now)
+	} else {
+		update = update.Set("status", models.JobRunning)
+	}
+	res, err := update.PlaceholderFormat(sq.Dollar).RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+	codes, err := freeCodes(ctx, tx, job.CompanyID, n, generate)
+	if err != nil {
+		return false, err
+	}
+	if err := insertPromoCodes(ctx, tx, job.CompanyID, job.PromoID, codes); err != nil {
+		return false, err
+	}
+	if _, err := syncPromoActive(ctx, tx, now, job.PromoID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ImportPromoCodes adds the codes passed to add by read, in one transaction,
+// without holding them in memory: they are copied into a temporary table and
+// checked there. A repeated code is rejected with duplicate, a code another
+// promo of the company uses with ALREADY_EXISTS. The report lists the first
+// limit rejected rows in line order.
+func (pr *PostgresRepo) ImportPromoCodes(ctx context.Context, companyID, promoID, duplicate string, limit int, read func(add func(line int, code string) error) error) (*models.ImportCodesReport, error) {
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "CREATE TEMP TABLE import_codes (line integer NOT NULL, code text NOT NULL) ON COMMIT DROP"); err != nil {
+		return nil, err
+	}
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("import_codes", "line", "code"))
+	if err != nil {
+		return nil, err
+	}
+	err = read(func(line int, code string) error {
+		_, err := stmt.ExecContext(ctx, line, code)
+		return err
+	})
+	if err == nil {
+		_, err = stmt.ExecContext(ctx)
+	}
+	if closeErr := stmt.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "CREATE INDEX ON import_codes (code, line)"); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "ANALYZE import_codes"); err != nil {
+		return nil, err
+	}
+	// The file is in, so the lock is not held while it uploads.
+	if err := lockCompanyCodes(ctx, tx, companyID); err != nil {
+		return nil, err
+	}
+	report := &models.ImportCodesReport{Errors: []models.ImportRowError{}}
+	rows, err := tx.QueryContext(ctx, `SELECT line, code, error, count(*) OVER () FROM (
+	SELECT line, code, CASE WHEN n > 1 THEN $2 ELSE $3 END AS error FROM (
+		SELECT line, code, row_number() OVER (PARTITION BY code ORDER BY line) AS n FROM import_codes
+	) AS i
+	WHERE n > 1 OR EXISTS(SELECT 1 FROM promo_codes WHERE promo_codes.company_id = $1 AND promo_codes.code = i.code)
+) AS r ORDER BY line LIMIT $4`, companyID, duplicate, models.ImportAlreadyExists, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row models.ImportRowError
+		if err := rows.Scan(&row.Line, &row.Code, &row.Error, &report.Rejected); err != nil {
+			return nil, err
+		}
+		report.Errors = append(report.Errors, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	report.ErrorsTruncated = report.Rejected > len(report.Errors)
+	res, err := tx.ExecContext(ctx, `INSERT INTO promo_codes (company_id, promo_id, code, status)
+SELECT $1, $2, code, $3 FROM (SELECT DISTINCT ON (code) line, code FROM import_codes ORDER BY code, line) AS i
+WHERE NOT EXISTS(SELECT 1 FROM promo_codes WHERE promo_codes.company_id = $1 AND promo_codes.code = i.code)
+ORDER BY line`, companyID, promoID, models.PromoCodeAvailable)
+	if err != nil {
+		return nil, err
+	}
+	imported, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	report.Imported = int(imported)
+	if imported > 0 {
+		if _, err := syncPromoActive(ctx, tx, time.Now().Unix(), promoID); err != nil {
+			return nil, err
+		}
+	}
+	return report, tx.Commit()
+}
+
+// ExportPromoCodes calls fn for every code of the promo in the order they were
+// added, without loading them all into memory.
+func (pr *PostgresRepo) ExportPromoCodes(ctx context.Context, promoID string, fn func(models.PromoCode) error) error {
+	rows, err := sq.Select("id", "promo_id", "code", "status", "user_id", "activated_at").
+		From("promo_codes").
+		Where(sq.Eq{"promo_id": promoID}).
+		OrderBy("id").
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code models.PromoCode
+		if err := rows.Scan(&code.ID, &code.PromoId, &code.Code, &code.Status, &code.UserID, &code.ActivatedAt); err != nil {
+			return err
+		}
+		if err := fn(code); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// InvalidatePromoCodes retires the given codes that are still available and
+// returns the ones it changed.
+func (pr *PostgresRepo) InvalidatePromoCodes(ctx context.Context, promoID string, codes []string, now int64) ([]string, error) {
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// Lock the promo before its codes, in the same order as UserActivatePromo.
+	var locked string
+	err = sq.Select("promo_id").
+		From("promos").
+		Where(sq.Eq{"promo_id": promoID}).
+		Suffix("FOR UPDATE").
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		QueryRowContext(ctx).
+		Scan(&locked)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := sq.Update("promo_codes").
+		Set("status", models.PromoCodeInvalidated).
+		Set("invalidated_at", now).
+		Where(sq.Eq{"promo_id": promoID, "status": models.PromoCodeAvailable}).
+		Where("code = ANY(?)", pq.Array(codes)).
+		Suffix("RETURNING code").
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invalidated := []string{}
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		invalidated = append(invalidated, code)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := syncPromoActive(ctx, tx, now, promoID); err != nil {
+		return nil, err
+	}
+	return invalidated, tx.Commit()
+}
+
+// SetPromoArchived moves the promo to the archive, or back out of it when
+// archivedAt is nil.
+func (pr *PostgresRepo) SetPromoArchived(ctx context.Context, promoID string, archivedAt *int64) error {
+	_, err := sq.Update("promos").
+		Set("archived_at", archivedAt).
+		Where(sq.Eq{"promo_id": promoID}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	return err
+}
+
+// DeletePromo removes the promo with its codes, generation jobs, likes and
+// comments. Activations and redemptions are history, so a promo that was
+// activated or redeemed is only deleted, together with them, once it has been
+// archived.
+func (pr *PostgresRepo) DeletePromo(ctx context.Context, companyID, promoID string) error {
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// Keeps generation jobs of the promo from adding codes after it is gone.
+	if err := lockCompanyCodes(ctx, tx, companyID); err != nil {
+		return err
+	}
+	var archivedAt *int64
+	var activated bool
+	err = sq.Select("archived_at", "EXISTS(SELECT 1 FROM activations WHERE activations.promo_id = promos.promo_id) OR EXISTS(SELECT 1 FROM redemptions WHERE redemptions.promo_id = promos.promo_id)").
+		From("promos").
+		Where(sq.Eq{"promo_id": promoID}).
+		Suffix("FOR UPDATE").
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		QueryRowContext(ctx).
+		Scan(&archivedAt, &activated)
+	if err != nil {
+		return err
+	}
+	if activated && archivedAt == nil {
+		return service.ErrPromoActivated
+	}
+	for _, table := range []string{"activations", "redemptions", "promosstat", "comments", "promo_codes", "code_generation_jobs", "promo_transitions", "promos"} {
+		_, err := sq.Delete(table).
+			Where(sq.Eq{"promo_id": promoID}).
+			PlaceholderFormat(sq.Dollar).
+			RunWith(tx).
+			ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SchedulePromo sets when the promo gets published, nil turning it back into a
+// draft. It returns false when the promo was already published at now.
+func (pr *PostgresRepo) SchedulePromo(ctx context.Context, promoID string, publishAt *int64, now int64) (bool, error) {
+	res, err := sq.Update("promos").
+		Set("publish_at", publishAt).
+		Where(sq.Eq{"promo_id": promoID}).
+		Where(sq.Or{sq.Eq{"publish_at": nil}, sq.Gt{"publish_at": now}}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		ExecContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// finishReservations ends the open reservations matched by where, whose
+// placeholders start at $3, returning their codes to the pool and their uses to
+// the promos. Activity of the affected promos is synced right after.
+func finishReservations(ctx context.Context, tx *sql.Tx, status string, now int64, where string, args ...interface{}) (int64, error) {
+	q := fmt.Sprintf(`WITH finished AS (
+	UPDATE activations SET status = $1, finished_at = $2
+	WHERE status = '%s' AND %s
+	RETURNING promo_id, code_id
+), codes AS (
+	UPDATE promo_codes SET status = '%s', user_id = NULL
+	WHERE id IN (SELECT code_id FROM finished) AND status = '%s'
+), uses AS (
+	UPDATE promos SET used_count = promos.used_count - f.n
+	FROM (SELECT promo_id, count(*) AS n FROM finished GROUP BY promo_id) AS f
+	WHERE promos.promo_id = f.promo_id
+)
+SELECT promo_id FROM finished`, models.ActivationReserved, where, models.PromoCodeAvailable, models.PromoCodeReserved)
+	rows, err := tx.QueryContext(ctx, q, append([]interface{}{status, now}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	var promoIDs []string
+	for rows.Next() {
+		var promoID string
+		if err := rows.Scan(&promoID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		promoIDs = append(promoIDs, promoID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(promoIDs) == 0 {
+		return 0, nil
+	}
+	if _, err := syncPromoActive(ctx, tx, now, promoIDs...); err != nil {
+		return 0, err
+	}
+	return int64(len(promoIDs)), nil
+}
+
+// ExpireReservations ends the reservations whose time ran out by now.
+func (pr *PostgresRepo) ExpireReservations(ctx context.Context, now int64) (int64, error) {
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	n, err := finishReservations(ctx, tx, models.ActivationExpired, now, "expires_at <= $3", now)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// NextReservationExpiry returns when the first open reservation expires, nil
+// when there is none.
+func (pr *PostgresRepo) NextReservationExpiry(ctx context.Context) (*int64, error) {
+	var next *int64
+	err := sq.Select("min(expires_at)").
+		From("activations").
+		Where(sq.Eq{"status": models.ActivationReserved}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx).
+		Scan(&next)
+	return next, err
+}
+
+// lockReservation locks the reservation of the user for update.
+func lockReservation(ctx context.Context, tx *sql.Tx, userID, reservationID string) (*models.Reservation, *int64, error) {
+	res := models.Reservation{ID: reservationID, UserID: userID}
+	var codeID *int64
+	err := sq.Select("promo_id", "status", "expires_at", "code_id").
+		Column("coalesce((SELECT code FROM promo_codes WHERE promo_codes.id = activations.code_id), (SELECT promo_common FROM promos WHERE promos.promo_id = activations.promo_id), '')").
+		From("activations").
+		Where(sq.Eq{"reservation_id": reservationID, "id": userID}).
+		Suffix("FOR UPDATE").
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		QueryRowContext(ctx).
+		Scan(&res.PromoID, &res.Status, &res.ExpiresAt, &codeID, &res.Code)
+	if err == sql.ErrNoRows {
+		return nil, nil, service.ErrReservationNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &res, codeID, nil
+}
+
+// ConfirmReservation turns the reservation into a confirmed activation.
+// Confirming twice is a no-op, a reservation past its expiry is expired instead.
+func (pr *PostgresRepo) ConfirmReservation(ctx context.Context, userID, reservationID string, now int64) (*models.Reservation, error) {
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	res, codeID, err := lockReservation(ctx, tx, userID, reservationID)
+	if err != nil {
+		return nil, err
+	}
+	switch res.Status {
+	case models.ActivationConfirmed:
+		return res, nil
+	case models.ActivationReserved:
+	default:
+		return nil, service.ErrReservationFinished
+	}
+	if res.ExpiresAt <= now {
+		// The scheduler has not got to it yet.
+		if _, err := finishReservations(ctx, tx, models.ActivationExpired, now, "reservation_id = $3", reservationID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, service.ErrReservationFinished
+	}
+	_, err = sq.Update("activations").
+		Set("status", models.ActivationConfirmed).
+		Set("finished_at", now).
+		Where(sq.Eq{"reservation_id": reservationID}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		ExecContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if codeID != nil {
+		_, err = sq.Update("promo_codes").
+			Set("status", models.PromoCodeActivated).
+			Set("activated_at", now).
+			Where(sq.Eq{"id": *codeID}).
+			PlaceholderFormat(sq.Dollar).
+			RunWith(tx).
+			ExecContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	res.Status = models.ActivationConfirmed
+	return res, tx.Commit()
+}
+
+// ReleaseReservation gives the held code back before the reservation expires.
+// Releasing twice is a no-op.
+func (pr *PostgresRepo) ReleaseReservation(ctx context.Context, userID, reservationID string, now int64) (*models.Reservation, error) {
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	res, _, err := lockReservation(ctx, tx, userID, reservationID)
+	if err != nil {
+		return nil, err
+	}
+	switch res.Status {
+	case models.ActivationReleased:
+		return res, nil
+	case models.ActivationReserved:
+	default:
+		return nil, service.ErrReservationFinished
+	}
+	if _, err := finishReservations(ctx, tx, models.ActivationReleased, now, "reservation_id = $3", reservationID); err != nil {
+		return nil, err
+	}
+	res.Status = models.ActivationReleased
+	return res, tx.Commit()
+}
+
+// lookupCode finds the code among the promos of the company. Unique codes win
+// over common ones, issued codes over the rest. With lock the code, or for a
+// common code its promo, stays locked until tx ends.
+func lookupCode(ctx context.Context, tx *sql.Tx, companyID, code string, lock bool) (*models.CodeLookup, error) {
+	res := models.CodeLookup{Code: code}
+	codeQuery := sq.Select("id", "promo_id", "status", "user_id", "activated_at").
+		From("promo_codes").
+		Where(sq.Eq{"company_id": companyID, "code": code}).
+		OrderBy(fmt.Sprintf("status = '%s' DESC", models.PromoCodeActivated), "activated_at DESC NULLS LAST", "id DESC").
+		Limit(1)
+	if lock {
+		codeQuery = codeQuery.Suffix("FOR UPDATE")
+	}
+	var codeID int64
+	err := codeQuery.
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		QueryRowContext(ctx).
+		Scan(&codeID, &res.PromoID, &res.CodeStatus, &res.UserID, &res.IssuedAt)
+	switch {
+	case err == nil:
+		res.CodeID = &codeID
+		if res.CodeStatus == models.PromoCodeActivated {
+			res.IssuedCount = 1
+		}
+	case err == sql.ErrNoRows:
+		err = sq.Select("promo_id").
+			From("promos").
+			Where(sq.Eq{"company_id": companyID, "mode": "COMMON", "promo_common": code}).
+			OrderBy("archived_at IS NULL DESC", "id DESC").
+			Limit(1).
+			PlaceholderFormat(sq.Dollar).
+			RunWith(tx).
+			QueryRowContext(ctx).
+			Scan(&res.PromoID)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	promoQuery := sq.Select("mode", "description", "active_until", "archived_at").
+		From("promos").
+		Where(sq.Eq{"promo_id": res.PromoID})
+	if lock && res.CodeID == nil {
+		// Redemptions of a common code are counted against its activations.
+		promoQuery = promoQuery.Suffix("FOR UPDATE")
+	}
+	err = promoQuery.
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		QueryRowContext(ctx).
+		Scan(&res.Mode, &res.Description, &res.ActiveUntil, &res.ArchivedAt)
+	if err != nil {
+		return nil, err
+	}
+	if res.CodeID == nil {
+		err = sq.Select("count(*)").
+			From("activations").
+			Where(sq.Eq{"promo_id": res.PromoID, "status": models.ActivationConfirmed}).
+			PlaceholderFormat(sq.Dollar).
+			RunWith(tx).
+			QueryRowContext(ctx).
+			Scan(&res.IssuedCount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = sq.Select("count(*)").
+		From("redemptions").
+		Where(sq.Eq{"promo_id": res.PromoID, "code": code}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		QueryRowContext(ctx).
+		Scan(&res.RedeemedCount)
+	if err != nil {
+		return nil, err
+	}
+	if res.RedeemedCount == 0 {
+		return &res, nil
+	}
+	var last models.Redemption
+	err = sq.Select("id", "company_id", "promo_id", "code", "code_id", "user_id", "store_id", "terminal_id", "redeemed_at").
+		From("redemptions").
+		Where(sq.Eq{"promo_id": res.PromoID, "code": code}).
+		OrderBy("id DESC").
+		Limit(1).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		QueryRowContext(ctx).
+		Scan(&last.ID, &last.CompanyID, &last.PromoID, &last.Code, &last.CodeID, &last.UserID, &last.StoreID, &last.TerminalID, &last.RedeemedAt)
+	if err != nil {
+		return nil, err
+	}
+	res.LastRedemption = &last
+	return &res, nil
+}
+
+func (pr *PostgresRepo) LookupCode(ctx context.Context, companyID, code string) (*models.CodeLookup, error) {
+	tx, err := pr.db.Db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return lookupCode(ctx, tx, companyID, code, false)
+}
+
+// RedeemCode records the redemption of the code at the store, as long as the
+// code is issued, not redeemed yet and its promo has not ended.
+func (pr *PostgresRepo) RedeemCode(ctx context.Context, redemption models.Redemption) (*models.CodeLookup, error) {
+	tx, err := pr.db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	lookup, err := lookupCode(ctx, tx, redemption.CompanyID, redemption.Code, true)
+	if err != nil {
+		return nil, err
+	}
+	if reason := lookup.DenyReason(redemption.RedeemedAt); reason != "" {
+		return lookup, &service.RedemptionDeniedError{Reason: reason}
+	}
+	redemption.PromoID = lookup.PromoID
+	redemption.CodeID = lookup.CodeID
+	redemption.UserID = lookup.UserID
+	err = sq.Insert("redemptions").
+		Columns("company_id", "promo_id", "code", "code_id", "user_id", "store_id", "terminal_id", "redeemed_at").
+		Values(redemption.CompanyID, redemption.PromoID, redemption.Code, redemption.CodeID, redemption.UserID, redemption.StoreID, redemption.TerminalID, redemption.RedeemedAt).
+		Suffix("RETURNING id").
+		PlaceholderFormat(sq.Dollar).
+		RunWith(tx).
+		QueryRowContext(ctx).
+		Scan(&redemption.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	lookup.RedeemedCount++
+	lookup.LastRedemption = &redemption
+	return lookup, nil
+}
+
+// GetQuotedCode finds a code the user presented at checkout: a unique code
+// issued to them or a common code of a visible promo, preferably one they
+// activated.
+func (pr *PostgresRepo) GetQuotedCode(ctx context.Context, userID, country string, age int, code string, now int64) (*models.QuotedCode, error) {
+	res := models.QuotedCode{Code: code}
+	err := sq.Select("promo_id", "EXISTS(SELECT 1 FROM redemptions WHERE redemptions.code_id = promo_codes.id)").
+		From("promo_codes").
+		Where(sq.Eq{"code": code, "user_id": userID, "status": []string{models.PromoCodeActivated, models.PromoCodeReserved}}).
+		OrderBy("id DESC").
+		Limit(1).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx).
+		Scan(&res.PromoID, &res.Redeemed)
+	switch {
+	case err == nil:
+		res.Issued = true
+	case err == sql.ErrNoRows:
+		err = sq.Select("promo_id").
+			Column(sq.Alias(sq.Expr("EXISTS(SELECT 1 FROM activations WHERE activations.promo_id = promos.promo_id AND activations.id = ? AND activations.status = ANY(?))",
+				userID, pq.Array([]string{models.ActivationReserved, models.ActivationConfirmed})), "issued")).
+			// Redemptions of a common code do not name the user, so, as in
+			// lookupCode, it is used up once they catch up with the confirmed
+			// activations.
+			Column(sq.Alias(sq.Expr(`EXISTS(SELECT 1 FROM activations WHERE activations.promo_id = promos.promo_id AND activations.id = ? AND activations.status = ?)
+	AND (SELECT count(*) FROM redemptions WHERE redemptions.promo_id = promos.promo_id AND redemptions.code = promos.promo_common)
+	>= (SELECT count(*) FROM activations WHERE activations.promo_id = promos.promo_id AND activations.status = ?)`,
+				userID, models.ActivationConfirmed, models.ActivationConfirmed), "redeemed")).
+			From("promos").
+			Where(sq.Eq{"mode": "COMMON", "promo_common": code, "archived_at": nil}).
+			Where(sq.LtOrEq{"publish_at": now}).
+			OrderBy("issued DESC", "id DESC").
+			Limit(1).
+			PlaceholderFormat(sq.Dollar).
+			RunWith(pr.db.Db).
+			QueryRowContext(ctx).
+			Scan(&res.PromoID, &res.Issued, &res.Redeemed)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	err = sq.Select("target", "active_from", "active_until", "archived_at", "publish_at", "benefit", scheduleOpenExpr).
+		Column(sq.Alias(promoTargets(country, age), "targeted")).
+		From("promos").
+		Where(sq.Eq{"promo_id": res.PromoID}).
+		PlaceholderFormat(sq.Dollar).
+		RunWith(pr.db.Db).
+		QueryRowContext(ctx).
+		Scan(&res.Target, &res.ActiveFrom, &res.ActiveUntil, &res.ArchivedAt, &res.PublishAt, &res.Benefit, &res.Open, &res.Targeted)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"solution/internal/models"
 	"solution/internal/service"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -193,9 +194,15 @@ func (rr *RedisRepo) AddUser(ctx context.Context, user *models.RedisUser) error
 	return nil
 }
 func (rr *RedisRepo) CacheFraud(ctx context.Context, userID, until string, value bool) error {
-	t, _ := time.Parse("2006-01-02T15:04:05.000Zhh:mm", until)
-	t = t.Add(3 * time.Hour)
-	err := rr.client.Set(ctx, userID+"_fraud", value, 0).Err()
+	// The antifraud service may leave out the offset, such times are UTC.
+	t, err := time.Parse(time.RFC3339Nano, until)
+	if err != nil {
+		t, err = time.Parse("2006-01-02T15:04:05.999999999", until)
+	}
+	if err != nil || !t.After(time.Now()) {
+		return nil
+	}
+	err = rr.client.Set(ctx, userID+"_fraud", value, 0).Err()
 	if err != nil {
 		return err
 	}
@@ -212,3 +219,94 @@ func (rr *RedisRepo) CheckFraud(ctx context.Context, userID string) (bool, error
 	}
 	return value, nil
 }
+func (rr *RedisRepo) CacheSession(ctx context.Context, session models.Session) error {
+	key := "session_" + session.ID
+	err := rr.client.HSet(ctx, key, map[string]interface{}{
+		"id":         session.ID,
+		"account_id": session.AccountID,
+		"role":       session.Role,
+		"expires_at": session.ExpiresAt,
+	}).Err()
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(time.Unix(session.ExpiresAt, 0))
+	if ttl > expiredTime {
+		ttl = expiredTime
+	}
+	return rr.client.Expire(ctx, key, ttl).Err()
+}
+func (rr *RedisRepo) GetSession(ctx context.Context, id string) (*models.Session, error) {
+	res := rr.client.HGetAll(ctx, "session_"+id)
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+	if len(res.Val()) == 0 {
+		return nil, redis.Nil
+	}
+	var session models.Session
+	if err := res.Scan(&session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+func (rr *RedisRepo) DeleteSessions(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, "session_"+id)
+	}
+	return rr.client.Del(ctx, keys...).Err()
+}
+
+// RegisterFailure counts a failed attempt under key and returns the number of
+// failures inside the window.
+func (rr *RedisRepo) RegisterFailure(ctx context.Context, key string, now time.Time, window time.Duration) (int64, error) {
+	var count *redis.IntCmd
+	_, err := rr.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = pipe.HIncrBy(ctx, key, "failures", 1)
+		pipe.HSet(ctx, key, "last_failed_at", now.UnixMilli())
+		pipe.Expire(ctx, key, window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+// GetFailures returns the failure count under key and the time of the last one.
+func (rr *RedisRepo) GetFailures(ctx context.Context, key string) (int64, time.Time, error) {
+	values, err := rr.client.HMGet(ctx, key, "failures", "last_failed_at").Result()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	var failures, lastFailedAt int64
+	if v, ok := values[0].(string); ok {
+		failures, _ = strconv.ParseInt(v, 10, 64)
+	}
+	if v, ok := values[1].(string); ok {
+		lastFailedAt, _ = strconv.ParseInt(v, 10, 64)
+	}
+	return failures, time.UnixMilli(lastFailedAt), nil
+}
+func (rr *RedisRepo) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	return rr.client.Set(ctx, key, 1, ttl).Err()
+}
+
+// LockTTL returns how long key stays locked, or 0 when it is not locked.
+func (rr *RedisRepo) LockTTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := rr.client.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+func (rr *RedisRepo) Del(ctx context.Context, keys ...string) error {
+	return rr.client.Del(ctx, keys...).Err()
+}
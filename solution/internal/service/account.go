@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"solution/internal/models"
+	"solution/internal/utils"
+	"time"
+)
+
+func (s *Service) CreateOneTimeToken(ctx context.Context, token models.OneTimeToken) error {
+	return s.postgresRepo.CreateOneTimeToken(ctx, token)
+}
+
+// GetUnverifiedEmail returns the email of an account that still has to be
+// confirmed.
+func (s *Service) GetUnverifiedEmail(ctx context.Context, accountID, role string) (string, error) {
+	email, verifiedAt, err := s.postgresRepo.GetEmailVerification(ctx, accountID, role)
+	if err != nil {
+		return "", err
+	}
+	if verifiedAt != nil {
+		return "", ErrEmailVerified
+	}
+	return email, nil
+}
+func (s *Service) VerifyEmail(ctx context.Context, hash []byte, roles []string) error {
+	now := time.Now().Unix()
+	token, err := s.postgresRepo.UseOneTimeToken(ctx, hash, models.TokenEmailVerification, roles, now)
+	if err == sql.ErrNoRows {
+		return ErrTokenInvalid
+	}
+	if err != nil {
+		return err
+	}
+	return s.postgresRepo.MarkEmailVerified(ctx, token.AccountID, token.Role, now)
+}
+
+// ResetPassword sets a new password for the owner of a reset token and signs
+// the account out everywhere.
+func (s *Service) ResetPassword(ctx context.Context, hash []byte, roles []string, password []byte) error {
+	token, err := s.postgresRepo.ResetPassword(ctx, hash, roles, password, time.Now().Unix())
+	if err == sql.ErrNoRows {
+		return ErrTokenInvalid
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.refreshAccountCache(ctx, token.AccountID, token.Role); err != nil {
+		return err
+	}
+	return s.RevokeAllSessions(ctx, token.AccountID, token.Role)
+}
+
+// refreshAccountCache reloads a cached account after its password changed,
+// since the cache carries the hash checked at sign-in.
+func (s *Service) refreshAccountCache(ctx context.Context, accountID, role string) error {
+	switch role {
+	case "company":
+		cmp, err := s.postgresRepo.GetCompanyById(ctx, models.Company{CompanyID: accountID})
+		if err != nil {
+			return err
+		}
+		return s.redisRepo.AddCompany(ctx, *cmp)
+	case "user":
+		usr, err := s.postgresRepo.GetUserById(ctx, models.User{ID: &accountID})
+		if err != nil {
+			return err
+		}
+		redisusr := models.RedisUser{ID: &accountID, Name: usr.Name,
+			SurName: usr.SurName, Email: usr.Email, AvatarUrl: usr.AvatarUrl, Password: usr.Password}
+		if usr.Other != nil {
+			redisusr.Age = usr.Other.Age
+			redisusr.Country = usr.Other.Country
+		}
+		return s.redisRepo.AddUser(ctx, &redisusr)
+	}
+	return nil
+}
+
+// RehashLegacyPasswords replaces every password still encrypted with
+// legacyKey by a bcrypt hash, so the key can be retired. It returns how many
+// passwords were rehashed and how many could not be decrypted with the key.
+func (s *Service) RehashLegacyPasswords(ctx context.Context, legacyKey []byte) (rehashed, failed int, err error) {
+	passwords, err := s.postgresRepo.GetLegacyPasswords(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, password := range passwords {
+		hash, err := utils.RehashLegacyPassword(password.Password, legacyKey)
+		if err != nil {
+			failed++
+			continue
+		}
+		replaced, err := s.postgresRepo.ReplaceLegacyPassword(ctx, password, hash)
+		if err != nil {
+			return rehashed, failed, err
+		}
+		if !replaced {
+			// Changed meanwhile, e.g. rehashed at sign-in.
+			continue
+		}
+		rehashed++
+		if err := s.refreshAccountCache(ctx, password.AccountID, password.Role); err != nil {
+			return rehashed, failed, err
+		}
+	}
+	return rehashed, failed, nil
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"io"
+	"solution/internal/models"
+	"strings"
+	"time"
+)
+
+const (
+	maxImportRows     = 1000000
+	maxImportReported = 1000
+	minCodeLength     = 3
+	maxCodeLength     = 30
+)
+
+// codeImporter checks codes one by one and streams the valid ones to the
+// repository, which adds them in one transaction and checks them for repeats.
+type codeImporter struct {
+	s         *Service
+	companyID string
+	promoID   string
+	duplicate string
+	report    *models.ImportCodesReport
+}
+
+func (s *Service) newCodeImporter(ctx context.Context, companyID, promoID, duplicate string) (*codeImporter, error) {
+	promo, err := s.companyPromo(ctx, companyID, promoID)
+	if err != nil {
+		return nil, err
+	}
+	if *promo.Mode != "UNIQUE" {
+		return nil, ErrPromoNotUnique
+	}
+	return &codeImporter{
+		s:         s,
+		companyID: companyID,
+		promoID:   promoID,
+		duplicate: duplicate,
+		report:    &models.ImportCodesReport{Errors: []models.ImportRowError{}},
+	}, nil
+}
+func (im *codeImporter) reject(line int, code, reason string) {
+	im.report.Reject(line, code, reason, maxImportReported)
+}
+
+// run passes the codes read gives it to the repository, rejecting those of
+// the wrong length on the way.
+func (im *codeImporter) run(ctx context.Context, read func(add func(line int, code string) error) error) (*models.ImportCodesReport, error) {
+	stored, err := im.s.postgresRepo.ImportPromoCodes(ctx, im.companyID, im.promoID, im.duplicate, maxImportReported,
+		func(store func(line int, code string) error) error {
+			return read(func(line int, code string) error {
+				code = strings.TrimSpace(code)
+				if len(code) < minCodeLength || len(code) > maxCodeLength {
+					im.reject(line, code, models.ImportInvalidLength)
+					return nil
+				}
+				return store(line, code)
+			})
+		})
+	if err != nil {
+		return nil, err
+	}
+	im.report.Merge(stored, maxImportReported)
+	return im.report, nil
+}
+
+// ImportCodes reads codes from the first column of a CSV stream and adds them
+// to a UNIQUE promo in one transaction. Rows that cannot be added are listed
+// in the report, in line order, instead of failing the whole file.
+func (s *Service) ImportCodes(ctx context.Context, companyID, promoID string, r io.Reader) (*models.ImportCodesReport, error) {
+	im, err := s.newCodeImporter(ctx, companyID, promoID, models.ImportDuplicateInFile)
+	if err != nil {
+		return nil, err
+	}
+	return im.run(ctx, func(add func(line int, code string) error) error {
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.ReuseRecord = true
+		for rows := 0; ; rows++ {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return nil
+			}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				im.reject(parseErr.Line, "", models.ImportMalformedRow)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			line, _ := reader.FieldPos(0)
+			if rows >= maxImportRows {
+				im.reject(line, "", models.ImportTooManyRows)
+				return nil
+			}
+			if rows == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "code") {
+				continue
+			}
+			if err := add(line, record[0]); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// AppendCodes adds codes to a live UNIQUE promo, so it can keep its likes and
+// comments after the first batch runs out.
+func (s *Service) AppendCodes(ctx context.Context, companyID, promoID string, codes []string) (*models.ImportCodesReport, error) {
+	im, err := s.newCodeImporter(ctx, companyID, promoID, models.ImportDuplicateInList)
+	if err != nil {
+		return nil, err
+	}
+	return im.run(ctx, func(add func(line int, code string) error) error {
+		for i, code := range codes {
+			if err := add(i+1, code); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// InvalidateCodes retires codes nobody has redeemed yet.
+func (s *Service) InvalidateCodes(ctx context.Context, companyID, promoID string, codes []string) (*models.InvalidateCodesResponse, error) {
+	promo, err := s.companyPromo(ctx, companyID, promoID)
+	if err != nil {
+		return nil, err
+	}
+	if *promo.Mode != "UNIQUE" {
+		return nil, ErrPromoNotUnique
+	}
+	invalidated, err := s.postgresRepo.InvalidatePromoCodes(ctx, promoID, codes, time.Now().Unix())
+	if err == sql.ErrNoRows {
+		return nil, ErrPromoNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[string]bool, len(invalidated))
+	for _, code := range invalidated {
+		done[code] = true
+	}
+	res := &models.InvalidateCodesResponse{Invalidated: len(invalidated), Skipped: []string{}}
+	for _, code := range codes {
+		if !done[code] {
+			res.Skipped = append(res.Skipped, code)
+			done[code] = true
+		}
+	}
+	return res, nil
+}
+
+// ExportCodes writes every code of a UNIQUE promo to fn.
+func (s *Service) ExportCodes(ctx context.Context, companyID, promoID string, fn func(models.PromoCode) error) error {
+	promo, err := s.companyPromo(ctx, companyID, promoID)
+	if err != nil {
+		return err
+	}
+	if *promo.Mode != "UNIQUE" {
+		return ErrPromoNotUnique
+	}
+	return s.postgresRepo.ExportPromoCodes(ctx, promoID, fn)
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"solution/internal/models"
+	"solution/internal/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// SyncGenerationLimit is the largest request generated before responding;
+	// bigger ones run in the background and are polled through the job.
+	SyncGenerationLimit = 5000
+	generationBatch     = 5000
+)
+
+func (s *Service) companyPromo(ctx context.Context, companyID, promoID string) (*models.Promo, error) {
+	promo, err := s.postgresRepo.GetPromoById(ctx, models.Promo{PromoId: &promoID})
+	if err == sql.ErrNoRows {
+		return nil, ErrPromoNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if *promo.CompanyId != companyID {
+		return nil, ErrNoPermission
+	}
+	return promo, nil
+}
+
+// GenerateCodes adds count codes made from pattern to a UNIQUE promo.
+func (s *Service) GenerateCodes(ctx context.Context, companyID, promoID string, pattern utils.CodePattern, count int) (*models.CodeGenerationJob, error) {
+	promo, err := s.companyPromo(ctx, companyID, promoID)
+	if err != nil {
+		return nil, err
+	}
+	if *promo.Mode != "UNIQUE" {
+		return nil, ErrPromoNotUnique
+	}
+	job, err := newGenerationJob(companyID, promoID, pattern, count)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.postgresRepo.CreateGenerationJob(ctx, *job); err != nil {
+		return nil, err
+	}
+	return s.startGenerationJob(ctx, job)
+}
+
+// newGenerationJob describes a job adding count codes made from pattern to the
+// promo, so it can be checked before anything is stored.
+func newGenerationJob(companyID, promoID string, pattern utils.CodePattern, count int) (*models.CodeGenerationJob, error) {
+	if !pattern.Fits(count) {
+		return nil, ErrCodeSpaceExhausted
+	}
+	now := time.Now().Unix()
+	return &models.CodeGenerationJob{
+		ID:         uuid.NewString(),
+		PromoID:    promoID,
+		CompanyID:  companyID,
+		Prefix:     pattern.Prefix,
+		Length:     pattern.Length,
+		Alphabet:   pattern.Alphabet,
+		CheckDigit: pattern.CheckDigit,
+		Total:      count,
+		Status:     models.JobPending,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}, nil
+}
+
+// startGenerationJob runs a stored job. Jobs up to SyncGenerationLimit finish
+// before it returns, and fail with an error when no codes could be added.
+func (s *Service) startGenerationJob(ctx context.Context, job *models.CodeGenerationJob) (*models.CodeGenerationJob, error) {
+	if job.Total > SyncGenerationLimit {
+		go s.runGenerationJob(context.Background(), *job)
+		return job, nil
+	}
+	s.runGenerationJob(ctx, *job)
+	job, err := s.postgresRepo.GetGenerationJob(ctx, job.ID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != models.JobFailed {
+		return job, nil
+	}
+	if job.Error != nil && *job.Error == ErrCodeSpaceExhausted.Error() {
+		return nil, ErrCodeSpaceExhausted
+	}
+	return nil, ErrGenerationFailed
+}
+
+// runGenerationJob generates the remaining codes of job in batches. Progress is
+// committed with every batch, so an interrupted job resumes where it stopped.
+func (s *Service) runGenerationJob(ctx context.Context, job models.CodeGenerationJob) {
+	pattern := utils.CodePattern{Prefix: job.Prefix, Length: job.Length, Alphabet: job.Alphabet, CheckDigit: job.CheckDigit}
+	for job.Generated < job.Total {
+		n := min(generationBatch, job.Total-job.Generated)
+		ok, err := s.postgresRepo.AddGeneratedCodes(ctx, job, n, pattern.Generate, time.Now().Unix())
+		if err != nil {
+			s.postgresRepo.FailGenerationJob(context.Background(), job.ID, err.Error(), time.Now().Unix())
+			return
+		}
+		if !ok {
+			return
+		}
+		job.Generated += n
+	}
+}
+
+// ResumeGenerationJobs continues the jobs left unfinished by a previous run.
+func (s *Service) ResumeGenerationJobs(ctx context.Context) error {
+	jobs, err := s.postgresRepo.GetUnfinishedGenerationJobs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		go s.runGenerationJob(context.Background(), job)
+	}
+	return nil
+}
+func (s *Service) GetGenerationJob(ctx context.Context, companyID, promoID, jobID string) (*models.CodeGenerationJob, error) {
+	job, err := s.postgresRepo.GetGenerationJob(ctx, jobID)
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if job.CompanyID != companyID || job.PromoID != promoID {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
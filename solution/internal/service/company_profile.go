@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"solution/internal/models"
+	"solution/internal/utils"
+	"time"
+)
+
+func (s *Service) GetCompanyProfile(ctx context.Context, companyID string) (*models.CompanyProfileResponse, error) {
+	cmp, err := s.postgresRepo.GetCompanyById(ctx, models.Company{CompanyID: companyID})
+	if err != nil {
+		return nil, err
+	}
+	return &models.CompanyProfileResponse{
+		CompanyID: cmp.CompanyID,
+		Name:      cmp.Name,
+		Email:     cmp.Email,
+		TimeZone:  utils.Location(cmp.TimeZone).String(),
+	}, nil
+}
+
+// UpdateCompanyTimeZone changes the zone promo dates are read and shown in.
+// Stored promo bounds are instants and keep their meaning.
+func (s *Service) UpdateCompanyTimeZone(ctx context.Context, companyID, timeZone string) (*models.CompanyProfileResponse, error) {
+	if err := s.postgresRepo.UpdateCompanyTimeZone(ctx, companyID, timeZone); err != nil {
+		return nil, err
+	}
+	return s.GetCompanyProfile(ctx, companyID)
+}
+
+// CompanyLocation is the zone the company gives promo dates in.
+func (s *Service) CompanyLocation(ctx context.Context, companyID string) (*time.Location, error) {
+	cmp, err := s.postgresRepo.GetCompanyById(ctx, models.Company{CompanyID: companyID})
+	if err != nil {
+		return nil, err
+	}
+	return utils.Location(cmp.TimeZone), nil
+}
@@ -8,4 +8,50 @@ var (
 	ErrNoPermission = errors.New("no permission")
 	ErrPromoNotFound = errors.New("promo id not fount")
 	ErrInvalidMaxCount = errors.New("max count for unique is 1")
+	ErrSessionInvalid = errors.New("session expired or revoked")
+	ErrSessionNotFound = errors.New("session not found")
+	ErrAPIKeyInvalid = errors.New("api key invalid or revoked")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrMemberNotFound = errors.New("member not found")
+	ErrInviteInvalid = errors.New("invite expired or already accepted")
+	ErrTokenInvalid = errors.New("token expired or already used")
+	ErrEmailVerified = errors.New("email already verified")
+	ErrAccountLocked = errors.New("account temporarily locked")
+	ErrSignInDelayed = errors.New("too many sign in attempts")
+	ErrTwoFactorEnabled = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication not enabled")
+	ErrTwoFactorCodeInvalid = errors.New("two-factor code invalid or already used")
+	ErrStepUpRequired = errors.New("two-factor confirmation required")
+	ErrPromoNotUnique = errors.New("promo mode is not UNIQUE")
+	ErrCodeSpaceExhausted = errors.New("code pattern cannot produce enough unique codes")
+	ErrJobNotFound = errors.New("job not found")
+	ErrGenerationFailed = errors.New("code generation failed")
+	ErrCodeTaken = errors.New("code already used by another promo of the company")
+	ErrPromoActivated = errors.New("activated promo must be archived before deletion")
+	ErrPromoPublished = errors.New("promo already published")
+	ErrReservationNotFound = errors.New("reservation not found")
+	ErrReservationFinished = errors.New("reservation already released or expired")
+	ErrQuoteTotalMismatch = errors.New("cart total does not match its items")
 )
+
+// ActivationDeniedError is returned when the activation policy of a promo
+// forbids the user another activation.
+type ActivationDeniedError struct {
+	Reason string
+	// RetryAfter is in seconds, 0 when the user may never activate again.
+	RetryAfter int64
+}
+
+func (e *ActivationDeniedError) Error() string {
+	return "activation denied: " + e.Reason
+}
+
+// RedemptionDeniedError is returned when a code can't be redeemed at the point
+// of sale.
+type RedemptionDeniedError struct {
+	Reason string
+}
+
+func (e *RedemptionDeniedError) Error() string {
+	return "redemption denied: " + e.Reason
+}
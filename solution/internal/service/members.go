@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"solution/internal/models"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func (s *Service) InviteMember(ctx context.Context, member models.CompanyMember) error {
+	taken, err := s.postgresRepo.TestMemberRegistration(ctx, member.Email)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrEmailRegistrated
+	}
+	id, err := s.redisRepo.GetString(ctx, member.Email)
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if id != "" {
+		return ErrEmailRegistrated
+	}
+	taken, err = s.postgresRepo.TestCompanyRegistration(ctx, models.Company{Email: member.Email})
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrEmailRegistrated
+	}
+	return s.postgresRepo.AddMember(ctx, member)
+}
+func (s *Service) AcceptInvite(ctx context.Context, inviteHash, password []byte) (*models.CompanyMember, error) {
+	member, err := s.postgresRepo.AcceptInvite(ctx, inviteHash, password, time.Now().Unix())
+	if err == sql.ErrNoRows {
+		return nil, ErrInviteInvalid
+	}
+	return member, err
+}
+func (s *Service) MemberSignIn(ctx context.Context, email string) (*models.CompanyMember, error) {
+	member, err := s.postgresRepo.GetMemberByEmail(ctx, email)
+	if err == sql.ErrNoRows {
+		return nil, ErrEmailNotRegistrated
+	}
+	return member, err
+}
+func (s *Service) GetMember(ctx context.Context, id string) (*models.CompanyMember, error) {
+	member, err := s.postgresRepo.GetMemberById(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil, ErrMemberNotFound
+	}
+	return member, err
+}
+func (s *Service) GetMembers(ctx context.Context, companyID string) ([]models.CompanyMember, error) {
+	return s.postgresRepo.GetMembers(ctx, companyID)
+}
+
+// UpdateMemberRole changes the role and signs the member out, so the next
+// token carries the new role.
+func (s *Service) UpdateMemberRole(ctx context.Context, companyID, id, role string) (*models.CompanyMember, error) {
+	member, err := s.postgresRepo.UpdateMemberRole(ctx, companyID, id, role)
+	if err == sql.ErrNoRows {
+		return nil, ErrMemberNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return member, s.RevokeAllSessions(ctx, id, "member")
+}
+func (s *Service) RemoveMember(ctx context.Context, companyID, id string) error {
+	removed, err := s.postgresRepo.RemoveMember(ctx, companyID, id, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return ErrMemberNotFound
+	}
+	return s.RevokeAllSessions(ctx, id, "member")
+}
+func (s *Service) AddAuditRecord(ctx context.Context, record models.AuditRecord) error {
+	return s.postgresRepo.AddAuditRecord(ctx, record)
+}
+func (s *Service) GetAuditLog(ctx context.Context, companyID string, limit, offset int) ([]models.AuditRecord, int, error) {
+	return s.postgresRepo.GetAuditLog(ctx, companyID, limit, offset)
+}
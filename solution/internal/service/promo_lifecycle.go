@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"solution/internal/models"
+	"time"
+)
+
+// ArchivePromo hides the promo from users while keeping it and its stats for
+// the company.
+func (s *Service) ArchivePromo(ctx context.Context, companyID, promoID string) (*models.GetPromoResponse, error) {
+	now := time.Now().Unix()
+	return s.setPromoArchived(ctx, companyID, promoID, &now)
+}
+func (s *Service) UnarchivePromo(ctx context.Context, companyID, promoID string) (*models.GetPromoResponse, error) {
+	return s.setPromoArchived(ctx, companyID, promoID, nil)
+}
+func (s *Service) setPromoArchived(ctx context.Context, companyID, promoID string, archivedAt *int64) (*models.GetPromoResponse, error) {
+	promo, err := s.companyPromo(ctx, companyID, promoID)
+	if err != nil {
+		return nil, err
+	}
+	// Archiving twice keeps the original archive time.
+	if (promo.ArchivedAt == nil) != (archivedAt == nil) {
+		if err := s.postgresRepo.SetPromoArchived(ctx, promoID, archivedAt); err != nil {
+			return nil, err
+		}
+	}
+	return s.postgresRepo.GetPromo(ctx, models.Promo{PromoId: &promoID, CompanyId: &companyID})
+}
+
+// DeletePromo removes the promo for good. Promos that were already activated
+// have to be archived first.
+func (s *Service) DeletePromo(ctx context.Context, companyID, promoID string) error {
+	if _, err := s.companyPromo(ctx, companyID, promoID); err != nil {
+		return err
+	}
+	if err := s.postgresRepo.DeletePromo(ctx, companyID, promoID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrPromoNotFound
+		}
+		return err
+	}
+	return s.redisRepo.Del(ctx, promoID)
+}
+
+// PublishPromo publishes the promo at publishAt, or right away when it is nil
+// or already passed.
+func (s *Service) PublishPromo(ctx context.Context, companyID, promoID string, publishAt *int64) (*models.GetPromoResponse, error) {
+	promo, err := s.companyPromo(ctx, companyID, promoID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	if publishAt == nil || *publishAt < now {
+		publishAt = &now
+	}
+	published := models.PublicationState(promo.PublishAt, now) == models.PublicationPublished
+	// Publishing a published promo again is a no-op, rescheduling it is not.
+	if published && *publishAt > now {
+		return nil, ErrPromoPublished
+	}
+	if !published {
+		ok, err := s.postgresRepo.SchedulePromo(ctx, promoID, publishAt, now)
+		if err != nil {
+			return nil, err
+		}
+		if !ok && *publishAt > now {
+			return nil, ErrPromoPublished
+		}
+	}
+	return s.postgresRepo.GetPromo(ctx, models.Promo{PromoId: &promoID, CompanyId: &companyID})
+}
+
+// UnpublishPromo turns a draft or scheduled promo back into a draft.
+func (s *Service) UnpublishPromo(ctx context.Context, companyID, promoID string) (*models.GetPromoResponse, error) {
+	if _, err := s.companyPromo(ctx, companyID, promoID); err != nil {
+		return nil, err
+	}
+	ok, err := s.postgresRepo.SchedulePromo(ctx, promoID, nil, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrPromoPublished
+	}
+	return s.postgresRepo.GetPromo(ctx, models.Promo{PromoId: &promoID, CompanyId: &companyID})
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"math/big"
+	"solution/internal/models"
+	"strings"
+	"time"
+)
+
+// Quote prices the cart with the codes applied in the order given. Every code
+// goes through the targeting and activity rules of its promo and has to be
+// activated by the user first; rejected codes are reported with the reason.
+// A code never takes a line below zero.
+func (s *Service) Quote(ctx context.Context, req models.QuoteRequest) (*models.QuoteResponse, error) {
+	user, err := s.GetUser(ctx, models.User{ID: &req.UserID})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNoPermission
+		}
+		return nil, err
+	}
+	resp := models.QuoteResponse{
+		Currency: *req.Currency,
+		Lines:    make([]models.QuoteLine, 0, len(req.Items)),
+		Applied:  []models.QuoteApplied{},
+		Rejected: []models.QuoteRejected{},
+	}
+	for _, item := range req.Items {
+		amount := *item.Quantity * *item.UnitPrice
+		resp.Lines = append(resp.Lines, models.QuoteLine{
+			SKU:       *item.SKU,
+			Category:  item.Category,
+			Quantity:  *item.Quantity,
+			UnitPrice: *item.UnitPrice,
+			Amount:    amount,
+			Total:     amount,
+		})
+		resp.Subtotal += amount
+	}
+	if req.Total != nil && *req.Total != resp.Subtotal {
+		return nil, ErrQuoteTotalMismatch
+	}
+	now := time.Now().Unix()
+	applied := make(map[string]bool)
+	for _, code := range req.Codes {
+		quoted, err := s.postgresRepo.GetQuotedCode(ctx, req.UserID, strings.ToLower(*user.Other.Country), *user.Other.Age, code, now)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		reason := models.QuoteNotFound
+		var discount int64
+		if quoted != nil {
+			reason = quoteRejection(quoted, now, applied)
+			if reason == "" {
+				reason, discount = applyBenefit(*quoted.Benefit, quoted.Target, resp.Subtotal, *req.Currency, resp.Lines)
+			}
+		}
+		if reason != "" {
+			resp.Rejected = append(resp.Rejected, models.QuoteRejected{Code: code, Reason: reason})
+			continue
+		}
+		applied[quoted.PromoID] = true
+		resp.Discount += discount
+		resp.Applied = append(resp.Applied, models.QuoteApplied{
+			Code:     code,
+			PromoID:  quoted.PromoID,
+			Benefit:  *quoted.Benefit,
+			Discount: discount,
+		})
+	}
+	resp.Total = resp.Subtotal - resp.Discount
+	return &resp, nil
+}
+
+// quoteRejection checks the code against the rules of its promo at now.
+func quoteRejection(quoted *models.QuotedCode, now int64, applied map[string]bool) string {
+	promo := models.Promo{ArchivedAt: quoted.ArchivedAt, PublishAt: quoted.PublishAt}
+	switch {
+	case !promo.Visible(now):
+		return models.QuoteNotFound
+	case !quoted.Targeted:
+		return models.QuoteNotTargeted
+	case !quoted.Issued:
+		return models.QuoteNotActivated
+	case quoted.Redeemed:
+		return models.QuoteRedeemed
+	case quoted.ActiveFrom != nil && *quoted.ActiveFrom > now:
+		return models.QuoteNotStarted
+	case quoted.ActiveUntil != nil && *quoted.ActiveUntil < now:
+		return models.QuoteExpired
+	case !quoted.Open:
+		return models.QuoteOutsideSchedule
+	case applied[quoted.PromoID]:
+		return models.QuoteDuplicatePromo
+	case quoted.Benefit == nil:
+		return models.QuoteNoBenefit
+	}
+	return ""
+}
+
+// applyBenefit takes the benefit off the lines, in place, and returns the
+// discount, or why the benefit does not apply to the cart.
+func applyBenefit(benefit models.Benefit, target models.Target, subtotal int64, currency string, lines []models.QuoteLine) (string, int64) {
+	if benefit.Currency != "" && benefit.Currency != currency {
+		return models.QuoteCurrency, 0
+	}
+	if subtotal < benefit.MinOrder {
+		return models.QuoteMinOrder, 0
+	}
+	if benefit.Type == models.BenefitFreeItem {
+		for i := range lines {
+			if lines[i].SKU == benefit.SKU && lines[i].Total > 0 {
+				discount := min(lines[i].UnitPrice, lines[i].Total)
+				lines[i].Discount += discount
+				lines[i].Total -= discount
+				return "", discount
+			}
+		}
+		return models.QuoteItemNotInCart, 0
+	}
+	// Promos aimed at categories only discount lines of those categories.
+	var eligible []int
+	var base int64
+	for i, line := range lines {
+		if line.Total > 0 && inCategories(line.Category, target.Categories) {
+			eligible = append(eligible, i)
+			base += line.Total
+		}
+	}
+	if base == 0 {
+		return models.QuoteNotApplicable, 0
+	}
+	var discount int64
+	switch benefit.Type {
+	case models.BenefitPercent:
+		discount = base * int64(benefit.Percent) / 100
+		if benefit.MaxDiscount > 0 {
+			discount = min(discount, benefit.MaxDiscount)
+		}
+	case models.BenefitFixed:
+		discount = min(benefit.Amount, base)
+	}
+	if discount == 0 {
+		return models.QuoteNotApplicable, 0
+	}
+	spreadDiscount(lines, eligible, base, discount)
+	return "", discount
+}
+
+// spreadDiscount splits discount, at most base, over the eligible lines in
+// proportion to what is left of them. Rounding leftovers go to the first
+// lines that can take them.
+func spreadDiscount(lines []models.QuoteLine, eligible []int, base, discount int64) {
+	left := discount
+	for _, i := range eligible {
+		share := new(big.Int).Mul(big.NewInt(lines[i].Total), big.NewInt(discount))
+		part := share.Div(share, big.NewInt(base)).Int64()
+		lines[i].Discount += part
+		lines[i].Total -= part
+		left -= part
+	}
+	for _, i := range eligible {
+		if left == 0 {
+			break
+		}
+		part := min(left, lines[i].Total)
+		lines[i].Discount += part
+		lines[i].Total -= part
+		left -= part
+	}
+}
+
+func inCategories(category *string, categories []string) bool {
+	if len(categories) == 0 {
+		return true
+	}
+	if category == nil {
+		return false
+	}
+	for _, c := range categories {
+		if strings.EqualFold(c, *category) {
+			return true
+		}
+	}
+	return false
+}
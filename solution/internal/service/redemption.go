@@ -0,0 +1,33 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"solution/internal/models"
+	"time"
+)
+
+// LookupCode tells the company what it knows about one of its codes.
+func (s *Service) LookupCode(ctx context.Context, companyID, code string) (*models.CodeLookup, error) {
+	lookup, err := s.postgresRepo.LookupCode(ctx, companyID, code)
+	if err == sql.ErrNoRows {
+		return nil, ErrPromoNotFound
+	}
+	return lookup, err
+}
+
+// RedeemCode marks the code as used at the store. The lookup is returned
+// along with a RedemptionDeniedError too, so the reason can be shown.
+func (s *Service) RedeemCode(ctx context.Context, companyID string, req models.RedeemCodeRequest) (*models.CodeLookup, error) {
+	lookup, err := s.postgresRepo.RedeemCode(ctx, models.Redemption{
+		CompanyID:  companyID,
+		Code:       *req.Code,
+		StoreID:    *req.StoreID,
+		TerminalID: req.TerminalID,
+		RedeemedAt: time.Now().Unix(),
+	})
+	if err == sql.ErrNoRows {
+		return nil, ErrPromoNotFound
+	}
+	return lookup, err
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"solution/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ReserveActivation holds a code of the promo for the user for ttl. The
+// reservation passes the same checks as an activation and counts as one until
+// it is released or expires.
+func (s *Service) ReserveActivation(ctx context.Context, promo models.ActivateRequest, ttl time.Duration) (*models.Reservation, error) {
+	reservation := models.Reservation{
+		ID:        uuid.NewString(),
+		PromoID:   *promo.PromoID,
+		UserID:    *promo.UserID,
+		Status:    models.ActivationReserved,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	}
+	promo.Reservation = &reservation
+	code, err := s.UserActivatePromo(ctx, promo)
+	if err != nil {
+		return nil, err
+	}
+	reservation.Code = code
+	s.wakeScheduler()
+	return &reservation, nil
+}
+
+// ConfirmReservation finalizes the reservation into an activation.
+func (s *Service) ConfirmReservation(ctx context.Context, req models.ReservationRequest) (*models.Reservation, error) {
+	return s.postgresRepo.ConfirmReservation(ctx, req.UserID, *req.ID, time.Now().Unix())
+}
+
+// ReleaseReservation returns the held code to the pool.
+func (s *Service) ReleaseReservation(ctx context.Context, req models.ReservationRequest) (*models.Reservation, error) {
+	return s.postgresRepo.ReleaseReservation(ctx, req.UserID, *req.ID, time.Now().Unix())
+}
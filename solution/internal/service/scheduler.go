@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"solution/internal/models"
+	"solution/pkg/logger"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// schedulerInterval caps how long the scheduler sleeps, so it also catches
+// flips nothing woke it up for.
+const schedulerInterval = time.Minute
+
+// RunScheduler keeps the active flag of promos in line with their dates and
+// remaining uses, and expires reservations, until ctx is done. It wakes up at
+// the next start or end of a promo or reservation expiry, or earlier when
+// promos are created or edited or codes are reserved.
+func (s *Service) RunScheduler(ctx context.Context) {
+	for {
+		timer := time.NewTimer(s.syncPromos(ctx))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-s.schedulerWake:
+			timer.Stop()
+		}
+	}
+}
+
+// syncPromos expires the reservations and flips the promos due at now and
+// returns how long to sleep.
+func (s *Service) syncPromos(ctx context.Context) time.Duration {
+	now := time.Now().Unix()
+	if _, err := s.postgresRepo.ExpireReservations(ctx, now); err != nil {
+		logger.GetLoggerFromCtx(ctx).Error(ctx, "failed expire reservations", zap.Error(err))
+		return schedulerInterval
+	}
+	if _, err := s.postgresRepo.SyncPromoActive(ctx, now); err != nil {
+		logger.GetLoggerFromCtx(ctx).Error(ctx, "failed sync promo activity", zap.Error(err))
+		return schedulerInterval
+	}
+	next, err := s.postgresRepo.NextPromoBoundary(ctx, now)
+	if err != nil {
+		logger.GetLoggerFromCtx(ctx).Error(ctx, "failed find next promo boundary", zap.Error(err))
+		return schedulerInterval
+	}
+	expiry, err := s.postgresRepo.NextReservationExpiry(ctx)
+	if err != nil {
+		logger.GetLoggerFromCtx(ctx).Error(ctx, "failed find next reservation expiry", zap.Error(err))
+		return schedulerInterval
+	}
+	if next == nil || (expiry != nil && *expiry < *next) {
+		next = expiry
+	}
+	if next == nil {
+		return schedulerInterval
+	}
+	return min(time.Until(time.Unix(*next, 0)), schedulerInterval)
+}
+
+func (s *Service) wakeScheduler() {
+	select {
+	case s.schedulerWake <- struct{}{}:
+	default:
+	}
+}
+
+// GetPromoTransitions lists the flips of the active flag of a promo, newest
+// first.
+func (s *Service) GetPromoTransitions(ctx context.Context, companyID, promoID string, limit, offset int) ([]models.PromoTransition, int, error) {
+	if _, err := s.companyPromo(ctx, companyID, promoID); err != nil {
+		return nil, 0, err
+	}
+	return s.postgresRepo.GetPromoTransitions(ctx, promoID, limit, offset)
+}
@@ -3,7 +3,9 @@ package service
 import (
 	"context"
 	"database/sql"
+	"slices"
 	"solution/internal/models"
+	"solution/internal/utils"
 	"sort"
 	"time"
 
@@ -15,12 +17,25 @@ type PostgresRepo interface {
 	GetCompanyByEmail(ctx context.Context, company models.Company) (*models.Company, error)
 	GetCompanyById(ctx context.Context, company models.Company) (*models.Company, error)
 	AddCompany(ctx context.Context, company models.Company) error
-	CreatePromo(ctx context.Context, promo *models.Promo) error
+	UpdateCompanyPassword(ctx context.Context, company models.Company) error
+	UpdateCompanyTimeZone(ctx context.Context, companyID, timeZone string) error
+	SyncPromoActive(ctx context.Context, now int64) (int64, error)
+	NextPromoBoundary(ctx context.Context, now int64) (*int64, error)
+	GetPromoTransitions(ctx context.Context, promoID string, limit, offset int) ([]models.PromoTransition, int, error)
+	ConfirmReservation(ctx context.Context, userID, reservationID string, now int64) (*models.Reservation, error)
+	ReleaseReservation(ctx context.Context, userID, reservationID string, now int64) (*models.Reservation, error)
+	ExpireReservations(ctx context.Context, now int64) (int64, error)
+	NextReservationExpiry(ctx context.Context) (*int64, error)
+	LookupCode(ctx context.Context, companyID, code string) (*models.CodeLookup, error)
+	RedeemCode(ctx context.Context, redemption models.Redemption) (*models.CodeLookup, error)
+	GetQuotedCode(ctx context.Context, userID, country string, age int, code string, now int64) (*models.QuotedCode, error)
+	CreatePromo(ctx context.Context, promo *models.Promo, job *models.CodeGenerationJob) error
 	GetPromos(ctx context.Context, sortRules *models.CompanySort) ([]models.GetPromoResponse, int, error)
 	GetPromo(ctx context.Context, promo models.Promo) (*models.GetPromoResponse, error)
 	GetPromoById(ctx context.Context, promo models.Promo) (*models.Promo, error)
 	GetPromoStat(ctx context.Context, promo models.GetPromoStatRequest) (*models.GetPromoStatResponse, error)
 	EditPromo(ctx context.Context, promo *models.Promo) (*models.GetPromoResponse, error)
+	GetPromoCode(ctx context.Context, promoID, code string) (*models.PromoCode, error)
 	TestUserRegistration(ctx context.Context, user models.User) (bool, error)
 	AddUser(ctx context.Context, user models.User) error
 	GetUserByEmail(ctx context.Context, User models.User) (*models.User, error)
@@ -39,6 +54,54 @@ type PostgresRepo interface {
 	CheckISLiked(ctx context.Context, promo models.UserPromoRequest) (bool, error)
 	CheckComment(ctx context.Context, comment models.UserCheckComments) (bool, error)
 	GetUserHistory(ctx context.Context, sortRules *models.HistorySort) ([]models.FeedUserResponse, int, error)
+	CreateSession(ctx context.Context, session models.Session) error
+	GetSession(ctx context.Context, id string) (*models.Session, error)
+	RotateSession(ctx context.Context, id string, roles []string, oldHash, newHash []byte, now, expiresAt int64) (*models.Session, error)
+	GetSessions(ctx context.Context, accountID, role string, now int64) ([]models.Session, error)
+	RevokeSession(ctx context.Context, id, accountID, role string, now int64) (bool, error)
+	RevokeSessions(ctx context.Context, accountID, role string, now int64) ([]string, error)
+	CreateAPIKey(ctx context.Context, key models.APIKey) error
+	GetAPIKeys(ctx context.Context, companyID string) ([]models.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash []byte) (*models.APIKey, error)
+	TouchAPIKey(ctx context.Context, id string, now int64) error
+	RevokeAPIKey(ctx context.Context, id, companyID string, now int64) (bool, error)
+	TestMemberRegistration(ctx context.Context, email string) (bool, error)
+	AddMember(ctx context.Context, member models.CompanyMember) error
+	AcceptInvite(ctx context.Context, inviteHash, password []byte, now int64) (*models.CompanyMember, error)
+	GetMemberByEmail(ctx context.Context, email string) (*models.CompanyMember, error)
+	GetMemberById(ctx context.Context, id string) (*models.CompanyMember, error)
+	GetMembers(ctx context.Context, companyID string) ([]models.CompanyMember, error)
+	UpdateMemberRole(ctx context.Context, companyID, id, role string) (*models.CompanyMember, error)
+	RemoveMember(ctx context.Context, companyID, id string, now int64) (bool, error)
+	AddAuditRecord(ctx context.Context, record models.AuditRecord) error
+	GetAuditLog(ctx context.Context, companyID string, limit, offset int) ([]models.AuditRecord, int, error)
+	CreateOneTimeToken(ctx context.Context, token models.OneTimeToken) error
+	UseOneTimeToken(ctx context.Context, hash []byte, purpose string, roles []string, now int64) (*models.OneTimeToken, error)
+	ResetPassword(ctx context.Context, hash []byte, roles []string, password []byte, now int64) (*models.OneTimeToken, error)
+	GetLegacyPasswords(ctx context.Context) ([]models.LegacyPassword, error)
+	ReplaceLegacyPassword(ctx context.Context, password models.LegacyPassword, hash []byte) (bool, error)
+	GetEmailVerification(ctx context.Context, accountID, role string) (string, *int64, error)
+	MarkEmailVerified(ctx context.Context, accountID, role string, now int64) error
+	AddSecurityEvent(ctx context.Context, event models.SecurityEvent) error
+	ConfirmSession(ctx context.Context, id string, now int64) error
+	SaveTwoFactor(ctx context.Context, tf models.TwoFactor) (bool, error)
+	GetTwoFactor(ctx context.Context, accountID, role string) (*models.TwoFactor, error)
+	UseTOTPStep(ctx context.Context, accountID, role string, step int64) (bool, error)
+	EnableTwoFactor(ctx context.Context, accountID, role string, step int64, hashes [][]byte, now int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, accountID, role string, hashes [][]byte) error
+	UseRecoveryCode(ctx context.Context, accountID, role string, hash []byte, now int64) (bool, error)
+	DeleteTwoFactor(ctx context.Context, accountID, role string) error
+	CreateGenerationJob(ctx context.Context, job models.CodeGenerationJob) error
+	GetGenerationJob(ctx context.Context, id string) (*models.CodeGenerationJob, error)
+	GetUnfinishedGenerationJobs(ctx context.Context) ([]models.CodeGenerationJob, error)
+	FailGenerationJob(ctx context.Context, id, reason string, now int64) error
+	AddGeneratedCodes(ctx context.Context, job models.CodeGenerationJob, n int, generate func() (string, error), now int64) (bool, error)
+	ImportPromoCodes(ctx context.Context, companyID, promoID, duplicate string, limit int, read func(add func(line int, code string) error) error) (*models.ImportCodesReport, error)
+	ExportPromoCodes(ctx context.Context, promoID string, fn func(models.PromoCode) error) error
+	InvalidatePromoCodes(ctx context.Context, promoID string, codes []string, now int64) ([]string, error)
+	SetPromoArchived(ctx context.Context, promoID string, archivedAt *int64) error
+	DeletePromo(ctx context.Context, companyID, promoID string) error
+	SchedulePromo(ctx context.Context, promoID string, publishAt *int64, now int64) (bool, error)
 }
 type RedisRepo interface {
 	HGetAll(ctx context.Context, key string) (interface{}, error)
@@ -59,14 +122,23 @@ type RedisRepo interface {
 	GetUserById(ctx context.Context, User models.User) (*models.User, error)
 	CacheFraud(ctx context.Context, userID, until string, value bool) error
 	CheckFraud(ctx context.Context, userID string) (bool, error)
+	CacheSession(ctx context.Context, session models.Session) error
+	GetSession(ctx context.Context, id string) (*models.Session, error)
+	DeleteSessions(ctx context.Context, ids ...string) error
+	RegisterFailure(ctx context.Context, key string, now time.Time, window time.Duration) (int64, error)
+	GetFailures(ctx context.Context, key string) (int64, time.Time, error)
+	Lock(ctx context.Context, key string, ttl time.Duration) error
+	LockTTL(ctx context.Context, key string) (time.Duration, error)
+	Del(ctx context.Context, keys ...string) error
 }
 type Service struct {
-	redisRepo    RedisRepo
-	postgresRepo PostgresRepo
+	redisRepo     RedisRepo
+	postgresRepo  PostgresRepo
+	schedulerWake chan struct{}
 }
 
 func New(redisRepo RedisRepo, postgresRepo PostgresRepo) *Service {
-	return &Service{redisRepo: redisRepo, postgresRepo: postgresRepo}
+	return &Service{redisRepo: redisRepo, postgresRepo: postgresRepo, schedulerWake: make(chan struct{}, 1)}
 }
 func (s *Service) CompanySignUp(ctx context.Context, company models.Company) error {
 	id, redisErr := s.redisRepo.GetString(ctx, company.Email)
@@ -76,6 +148,13 @@ func (s *Service) CompanySignUp(ctx context.Context, company models.Company) err
 	if id != "" {
 		return ErrEmailRegistrated
 	}
+	member, err := s.postgresRepo.TestMemberRegistration(ctx, company.Email)
+	if err != nil {
+		return err
+	}
+	if member {
+		return ErrEmailRegistrated
+	}
 	registrated2, err := s.postgresRepo.TestCompanyRegistration(ctx, company)
 	if registrated2 {
 		if id == "" {
@@ -120,39 +199,184 @@ func (s *Service) CompanySignIn(ctx context.Context, company models.Company) (*m
 	}
 	return nil, ErrEmailNotRegistrated
 }
-func (s *Service) UpdateToken(ctx context.Context, id, token string) error {
-	return s.redisRepo.HSet(ctx, id, map[string]string{"token": token})
+func (s *Service) UpdateCompanyPassword(ctx context.Context, company models.Company) error {
+	err := s.postgresRepo.UpdateCompanyPassword(ctx, company)
+	if err != nil {
+		return err
+	}
+	return s.redisRepo.AddCompany(ctx, company)
+}
+func (s *Service) CreateSession(ctx context.Context, session models.Session) error {
+	err := s.postgresRepo.CreateSession(ctx, session)
+	if err != nil {
+		return err
+	}
+	return s.redisRepo.CacheSession(ctx, session)
+}
+
+// RefreshSession exchanges the refresh token hashed as oldHash for newHash.
+// Presenting an already rotated token means it leaked, so the whole session
+// is revoked.
+func (s *Service) RefreshSession(ctx context.Context, id string, roles []string, oldHash, newHash []byte, expiresAt int64) (*models.Session, error) {
+	now := time.Now().Unix()
+	session, err := s.postgresRepo.RotateSession(ctx, id, roles, oldHash, newHash, now, expiresAt)
+	if err == nil {
+		return session, s.redisRepo.CacheSession(ctx, *session)
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+	stored, err := s.postgresRepo.GetSession(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(roles, stored.Role) && stored.RevokedAt == nil && stored.ExpiresAt > now {
+		if _, err := s.postgresRepo.RevokeSession(ctx, stored.ID, stored.AccountID, stored.Role, now); err != nil {
+			return nil, err
+		}
+		if err := s.redisRepo.DeleteSessions(ctx, stored.ID); err != nil {
+			return nil, err
+		}
+	}
+	return nil, ErrSessionInvalid
 }
 
-func (s *Service) GetToken(ctx context.Context, id string) (string, error) {
-	return s.redisRepo.HGetString(ctx, id, "token")
+// CheckSession makes sure the session of an access token is still alive.
+// Postgres is the source of truth, Redis only caches live sessions.
+func (s *Service) CheckSession(ctx context.Context, id, accountID, role string) error {
+	session, err := s.redisRepo.GetSession(ctx, id)
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if session == nil {
+		session, err = s.postgresRepo.GetSession(ctx, id)
+		if err == sql.ErrNoRows {
+			return ErrSessionInvalid
+		}
+		if err != nil {
+			return err
+		}
+		if session.RevokedAt != nil || session.ExpiresAt <= time.Now().Unix() {
+			return ErrSessionInvalid
+		}
+		if err := s.redisRepo.CacheSession(ctx, *session); err != nil {
+			return err
+		}
+	}
+	if session.AccountID != accountID || session.Role != role || session.ExpiresAt <= time.Now().Unix() {
+		return ErrSessionInvalid
+	}
+	return nil
+}
+func (s *Service) GetSessions(ctx context.Context, accountID, role string) ([]models.Session, error) {
+	return s.postgresRepo.GetSessions(ctx, accountID, role, time.Now().Unix())
+}
+func (s *Service) RevokeSession(ctx context.Context, id, accountID, role string) error {
+	revoked, err := s.postgresRepo.RevokeSession(ctx, id, accountID, role, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrSessionNotFound
+	}
+	return s.redisRepo.DeleteSessions(ctx, id)
+}
+
+// RevokeAllSessions signs the account out on every device.
+func (s *Service) RevokeAllSessions(ctx context.Context, accountID, role string) error {
+	ids, err := s.postgresRepo.RevokeSessions(ctx, accountID, role, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	return s.redisRepo.DeleteSessions(ctx, ids...)
+}
+func (s *Service) CreateAPIKey(ctx context.Context, key models.APIKey) error {
+	return s.postgresRepo.CreateAPIKey(ctx, key)
+}
+func (s *Service) GetAPIKeys(ctx context.Context, companyID string) ([]models.APIKey, error) {
+	return s.postgresRepo.GetAPIKeys(ctx, companyID)
+}
+
+// apiKeyTouchInterval limits last_used_at writes for busy keys.
+const apiKeyTouchInterval = int64(60)
+
+func (s *Service) AuthenticateAPIKey(ctx context.Context, hash []byte) (*models.APIKey, error) {
+	key, err := s.postgresRepo.GetAPIKeyByHash(ctx, hash)
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	if key.LastUsedAt == nil || now-*key.LastUsedAt >= apiKeyTouchInterval {
+		if err := s.postgresRepo.TouchAPIKey(ctx, key.ID, now); err != nil {
+			return nil, err
+		}
+		key.LastUsedAt = &now
+	}
+	return key, nil
 }
-func (s *Service) CreatePromo(ctx context.Context, promo *models.Promo) error {
+func (s *Service) RevokeAPIKey(ctx context.Context, id, companyID string) error {
+	revoked, err := s.postgresRepo.RevokeAPIKey(ctx, id, companyID, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// CreatePromo stores the promo. A count above zero also generates that many
+// codes made from pattern, and a promo whose codes could not be generated is
+// not kept.
+func (s *Service) CreatePromo(ctx context.Context, promo *models.Promo, pattern utils.CodePattern, count int) (*models.CodeGenerationJob, error) {
+	var job *models.CodeGenerationJob
 	var err error
+	if count > 0 {
+		job, err = newGenerationJob(*promo.CompanyId, *promo.PromoId, pattern, count)
+		if err != nil {
+			return nil, err
+		}
+	}
 	cmp, err := s.redisRepo.GetCompanyById(ctx, models.Company{CompanyID: *promo.CompanyId})
 	if err != nil && err != redis.Nil {
-		return err
+		return nil, err
 	}
 	if cmp == nil {
 		cmp, err = s.postgresRepo.GetCompanyById(ctx, models.Company{CompanyID: *promo.CompanyId})
 		if err != nil {
-			return err
+			return nil, err
 		}
 		err = s.redisRepo.AddCompany(ctx, *cmp)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 	promo.CompanyName = &cmp.Name
-	err = s.postgresRepo.CreatePromo(ctx, promo)
+	err = s.postgresRepo.CreatePromo(ctx, promo, job)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	if job != nil {
+		job, err = s.startGenerationJob(ctx, job)
+		if err != nil {
+			if delErr := s.postgresRepo.DeletePromo(ctx, *promo.CompanyId, *promo.PromoId); delErr != nil {
+				return nil, delErr
+			}
+			return nil, err
+		}
+	}
+	s.wakeScheduler()
 	err = s.redisRepo.HSet(ctx, *promo.PromoId, map[string]interface{}{"likes": *promo.LikeCount, "used": *promo.UsedCount, "active": *promo.Active, "company_id": *promo.CompanyId})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return job, nil
 }
 func (s *Service) GetPromos(ctx context.Context, sortRules *models.CompanySort) ([]models.GetPromoResponse, int, error) {
 	return s.postgresRepo.GetPromos(ctx, sortRules)
@@ -199,32 +423,14 @@ func (s *Service) EditPromo(ctx context.Context, promo *models.Promo) (*models.G
 	if *getted.CompanyId != *promo.CompanyId {
 		return nil, ErrNoPermission
 	}
-	var count, tFrom, tUntil bool = *getted.Active, *getted.Active, *getted.Active
-	if *getted.Mode == "UNIQUE" {
-		if promo.MaxCount != nil {
-			if *promo.MaxCount != 1 {
-				return nil, ErrInvalidMaxCount
-			}
-		}
-		count = len(getted.PromoUnique) > len(getted.UsedPromoUnique)
-	} else {
-		if promo.MaxCount != nil {
-			count = *promo.MaxCount > *getted.UsedCount
-		}
+	if *getted.Mode == "UNIQUE" && promo.MaxCount != nil && *promo.MaxCount != 1 {
+		return nil, ErrInvalidMaxCount
 	}
-
-	if promo.ActiveUntil != nil {
-		tUntil = *promo.ActiveUntil >= time.Now().UTC().Add(3*time.Hour).Unix()
-	}
-	if promo.ActiveFrom != nil {
-		tFrom = *promo.ActiveFrom <= time.Now().UTC().Add(3*time.Hour).Unix()
-	}
-	active := count && tFrom && tUntil
-	promo.Active = &active
 	edited, err := s.postgresRepo.EditPromo(ctx, promo)
 	if err != nil {
 		return nil, err
 	}
+	s.wakeScheduler()
 	if redisErr == redis.Nil {
 		err = s.redisRepo.HSet(ctx, *edited.PromoId, map[string]interface{}{"likes": *edited.LikeCount, "used": *edited.UsedCount, "active": *edited.Active, "company_id": *edited.CompanyId})
 		if err != nil {
@@ -233,6 +439,26 @@ func (s *Service) EditPromo(ctx context.Context, promo *models.Promo) (*models.G
 	}
 	return edited, nil
 }
+func (s *Service) GetPromoCode(ctx context.Context, companyID, promoID, code string) (*models.PromoCode, error) {
+	promo, err := s.postgresRepo.GetPromoById(ctx, models.Promo{PromoId: &promoID})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrPromoNotFound
+		}
+		return nil, err
+	}
+	if *promo.CompanyId != companyID {
+		return nil, ErrNoPermission
+	}
+	promoCode, err := s.postgresRepo.GetPromoCode(ctx, promoID, code)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrPromoNotFound
+		}
+		return nil, err
+	}
+	return promoCode, nil
+}
 func (s *Service) GetPromoStat(ctx context.Context, promo models.GetPromoStatRequest) (*models.GetPromoStatResponse, error) {
 	company, err := s.redisRepo.GetCompanyById(ctx, models.Company{CompanyID: *promo.CompanyID})
 	if err != nil {
@@ -418,6 +644,9 @@ func (s *Service) UserLikePromo(ctx context.Context, promo models.UserLikedPromo
 		}
 		return err
 	}
+	if !promocode.Visible(time.Now().Unix()) {
+		return ErrPromoNotFound
+	}
 	tru := true
 	likeCount := *promocode.LikeCount + 1
 	promo.LikeCount = &likeCount
@@ -468,6 +697,9 @@ func (s *Service) UserCreateComment(ctx context.Context, comment models.UserComm
 		}
 		return nil, err
 	}
+	if !promo.Visible(time.Now().Unix()) {
+		return nil, ErrPromoNotFound
+	}
 	user, err := s.GetUser(ctx, models.User{ID: comment.UserID})
 	if err != nil {
 		return nil, err
@@ -566,13 +798,16 @@ func (s *Service) Cache(ctx context.Context, userID, until string, value bool) e
 	return s.redisRepo.CacheFraud(ctx, userID, until, value)
 }
 func (s *Service) UserActivatePromo(ctx context.Context, promo models.ActivateRequest) (string, error) {
-	_, err := s.postgresRepo.GetPromoById(ctx, models.Promo{PromoId: promo.PromoID})
+	promocode, err := s.postgresRepo.GetPromoById(ctx, models.Promo{PromoId: promo.PromoID})
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return "", ErrPromoNotFound
 		}
 		return "", err
 	}
+	if !promocode.Visible(time.Now().Unix()) {
+		return "", ErrPromoNotFound
+	}
 	user, err := s.GetUser(ctx, models.User{ID: promo.UserID})
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"solution/internal/models"
+	"strings"
+	"time"
+)
+
+// SignInPolicy controls how failed sign-in attempts are throttled. After
+// DelayAfter failures every next attempt has to wait BaseDelay doubled per
+// failure (up to MaxDelay); LockAfter failures lock the account and
+// IPLockAfter failures lock the client address for LockDuration.
+var SignInPolicy = struct {
+	Window       time.Duration
+	DelayAfter   int64
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockAfter    int64
+	IPLockAfter  int64
+	LockDuration time.Duration
+}{
+	Window:       15 * time.Minute,
+	DelayAfter:   3,
+	BaseDelay:    time.Second,
+	MaxDelay:     30 * time.Second,
+	LockAfter:    10,
+	IPLockAfter:  50,
+	LockDuration: 15 * time.Minute,
+}
+
+func signInAccountKey(scope, email string) string {
+	return "signin_" + scope + "_" + strings.ToLower(email)
+}
+func signInIPKey(ip string) string {
+	return "signin_ip_" + ip
+}
+func lockKey(key string) string {
+	return "lock_" + key
+}
+func signInDelay(failures int64) time.Duration {
+	if failures < SignInPolicy.DelayAfter {
+		return 0
+	}
+	delay := SignInPolicy.BaseDelay
+	for i := SignInPolicy.DelayAfter; i < failures && delay < SignInPolicy.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, SignInPolicy.MaxDelay)
+}
+
+// CheckSignIn reports whether a sign-in attempt may proceed. When it may not,
+// the returned duration tells the client how long to wait.
+func (s *Service) CheckSignIn(ctx context.Context, scope, email, ip string) (time.Duration, error) {
+	for _, key := range []string{signInAccountKey(scope, email), signInIPKey(ip)} {
+		ttl, err := s.redisRepo.LockTTL(ctx, lockKey(key))
+		if err != nil {
+			return 0, err
+		}
+		if ttl > 0 {
+			return ttl, ErrAccountLocked
+		}
+	}
+	failures, lastFailedAt, err := s.redisRepo.GetFailures(ctx, signInAccountKey(scope, email))
+	if err != nil {
+		return 0, err
+	}
+	wait := time.Until(lastFailedAt.Add(signInDelay(failures)))
+	if wait > 0 {
+		return wait, ErrSignInDelayed
+	}
+	return 0, nil
+}
+
+// SignInFailed counts a failed attempt for the account and the client address
+// and returns the security event when one of them got locked.
+func (s *Service) SignInFailed(ctx context.Context, scope, email, ip string) (*models.SecurityEvent, error) {
+	now := time.Now()
+	accountKey := signInAccountKey(scope, email)
+	failures, err := s.redisRepo.RegisterFailure(ctx, accountKey, now, SignInPolicy.Window)
+	if err != nil {
+		return nil, err
+	}
+	ipFailures, err := s.redisRepo.RegisterFailure(ctx, signInIPKey(ip), now, SignInPolicy.Window)
+	if err != nil {
+		return nil, err
+	}
+	var event *models.SecurityEvent
+	switch {
+	case failures >= SignInPolicy.LockAfter:
+		if err := s.redisRepo.Lock(ctx, lockKey(accountKey), SignInPolicy.LockDuration); err != nil {
+			return nil, err
+		}
+		if err := s.redisRepo.Del(ctx, accountKey); err != nil {
+			return nil, err
+		}
+		event = &models.SecurityEvent{Event: models.SecurityLockout, Scope: &scope, Email: &email, IP: &ip}
+	case ipFailures >= SignInPolicy.IPLockAfter:
+		if err := s.redisRepo.Lock(ctx, lockKey(signInIPKey(ip)), SignInPolicy.LockDuration); err != nil {
+			return nil, err
+		}
+		if err := s.redisRepo.Del(ctx, signInIPKey(ip)); err != nil {
+			return nil, err
+		}
+		event = &models.SecurityEvent{Event: models.SecurityIPLockout, Scope: &scope, Email: &email, IP: &ip}
+	default:
+		return nil, nil
+	}
+	event.CreatedAt = now.Unix()
+	return event, s.postgresRepo.AddSecurityEvent(ctx, *event)
+}
+func (s *Service) SignInSucceeded(ctx context.Context, scope, email string) error {
+	return s.redisRepo.Del(ctx, signInAccountKey(scope, email))
+}
+
+// UnlockAccount lifts a lockout and forgets the failed attempts of an account
+// and, when ip is set, of that client address. adminIP is where the unlock
+// came from.
+func (s *Service) UnlockAccount(ctx context.Context, scope, email, ip, adminIP string) error {
+	accountKey := signInAccountKey(scope, email)
+	keys := []string{accountKey, lockKey(accountKey)}
+	event := models.SecurityEvent{
+		Event:     models.SecurityUnlock,
+		Scope:     &scope,
+		Email:     &email,
+		CreatedAt: time.Now().Unix(),
+	}
+	if ip != "" {
+		keys = append(keys, signInIPKey(ip), lockKey(signInIPKey(ip)))
+		event.IP = &ip
+	}
+	if err := s.redisRepo.Del(ctx, keys...); err != nil {
+		return err
+	}
+	details := "unlocked from " + adminIP
+	event.Details = &details
+	return s.postgresRepo.AddSecurityEvent(ctx, event)
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"solution/internal/models"
+	"solution/internal/utils"
+	"time"
+)
+
+const (
+	totpIssuer = "PROD"
+	// StepUpTTL is how long a session stays confirmed after entering a
+	// two-factor code.
+	StepUpTTL = 5 * time.Minute
+)
+
+func (s *Service) accountEmail(ctx context.Context, accountID, role string) (string, error) {
+	if role == "member" {
+		member, err := s.GetMember(ctx, accountID)
+		if err != nil {
+			return "", err
+		}
+		return member.Email, nil
+	}
+	email, _, err := s.postgresRepo.GetEmailVerification(ctx, accountID, role)
+	return email, err
+}
+func (s *Service) TwoFactorEnabled(ctx context.Context, accountID, role string) (bool, error) {
+	tf, err := s.postgresRepo.GetTwoFactor(ctx, accountID, role)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return tf.EnabledAt != nil, nil
+}
+
+// EnrollTwoFactor starts enrollment with a new secret. It is not required at
+// sign in until EnableTwoFactor confirms a code generated from it.
+func (s *Service) EnrollTwoFactor(ctx context.Context, accountID, role string) (*models.TwoFactorEnrollResponse, error) {
+	email, err := s.accountEmail(ctx, accountID, role)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := utils.NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	saved, err := s.postgresRepo.SaveTwoFactor(ctx, models.TwoFactor{
+		AccountID: accountID,
+		Role:      role,
+		Secret:    secret,
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !saved {
+		return nil, ErrTwoFactorEnabled
+	}
+	return &models.TwoFactorEnrollResponse{
+		Secret: secret,
+		URI:    utils.TOTPURI(totpIssuer, email, secret),
+	}, nil
+}
+func (s *Service) EnableTwoFactor(ctx context.Context, accountID, role, code string, recoveryHashes [][]byte) error {
+	tf, err := s.postgresRepo.GetTwoFactor(ctx, accountID, role)
+	if err == sql.ErrNoRows {
+		return ErrTwoFactorNotEnabled
+	}
+	if err != nil {
+		return err
+	}
+	if tf.EnabledAt != nil {
+		return ErrTwoFactorEnabled
+	}
+	step, ok := utils.ValidateTOTP(tf.Secret, code, time.Now())
+	if !ok {
+		return ErrTwoFactorCodeInvalid
+	}
+	enabled, err := s.postgresRepo.EnableTwoFactor(ctx, accountID, role, step, recoveryHashes, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrTwoFactorCodeInvalid
+	}
+	return nil
+}
+
+// VerifyTwoFactor accepts either a current TOTP code or an unused recovery
+// code. Each of them works only once.
+func (s *Service) VerifyTwoFactor(ctx context.Context, accountID, role, code string) error {
+	tf, err := s.postgresRepo.GetTwoFactor(ctx, accountID, role)
+	if err == sql.ErrNoRows {
+		return ErrTwoFactorNotEnabled
+	}
+	if err != nil {
+		return err
+	}
+	if tf.EnabledAt == nil {
+		return ErrTwoFactorNotEnabled
+	}
+	if step, ok := utils.ValidateTOTP(tf.Secret, code, time.Now()); ok {
+		used, err := s.postgresRepo.UseTOTPStep(ctx, accountID, role, step)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrTwoFactorCodeInvalid
+		}
+		return nil
+	}
+	used, err := s.postgresRepo.UseRecoveryCode(ctx, accountID, role, utils.HashRecoveryCode(code), time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrTwoFactorCodeInvalid
+	}
+	return nil
+}
+func (s *Service) DisableTwoFactor(ctx context.Context, accountID, role, code string) error {
+	if err := s.VerifyTwoFactor(ctx, accountID, role, code); err != nil {
+		return err
+	}
+	return s.postgresRepo.DeleteTwoFactor(ctx, accountID, role)
+}
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, accountID, role, code string, recoveryHashes [][]byte) error {
+	if err := s.VerifyTwoFactor(ctx, accountID, role, code); err != nil {
+		return err
+	}
+	return s.postgresRepo.ReplaceRecoveryCodes(ctx, accountID, role, recoveryHashes)
+}
+
+// TwoFactorSignIn finishes a sign in started with a password. The mfa token is
+// spent even when the code is wrong, so guessing has to start over from the
+// throttled password step.
+func (s *Service) TwoFactorSignIn(ctx context.Context, hash []byte, code string) (*models.OneTimeToken, error) {
+	token, err := s.postgresRepo.UseOneTimeToken(ctx, hash, models.TokenTwoFactor, []string{"company", "member"}, time.Now().Unix())
+	if err == sql.ErrNoRows {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.VerifyTwoFactor(ctx, token.AccountID, token.Role, code); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+func (s *Service) ConfirmSession(ctx context.Context, id string) error {
+	return s.postgresRepo.ConfirmSession(ctx, id, time.Now().Unix())
+}
+
+// CheckStepUp requires accounts with two-factor authentication to have
+// confirmed the session with a code within StepUpTTL.
+func (s *Service) CheckStepUp(ctx context.Context, sessionID, accountID, role string) error {
+	enabled, err := s.TwoFactorEnabled(ctx, accountID, role)
+	if err != nil || !enabled {
+		return err
+	}
+	session, err := s.postgresRepo.GetSession(ctx, sessionID)
+	if err == sql.ErrNoRows {
+		return ErrSessionInvalid
+	}
+	if err != nil {
+		return err
+	}
+	if session.ConfirmedAt == nil || time.Since(time.Unix(*session.ConfirmedAt, 0)) > StepUpTTL {
+		return ErrStepUpRequired
+	}
+	return nil
+}
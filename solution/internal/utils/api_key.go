@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const APIKeyPrefix = "pk_"
+
+// NewAPIKey returns a key of the form "pk_<prefix>_<secret>", its public
+// prefix and the hash that is stored instead of the key.
+func NewAPIKey() (string, string, []byte, error) {
+	buf := make([]byte, 36)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", nil, err
+	}
+	prefix := hex.EncodeToString(buf[:4])
+	key := APIKeyPrefix + prefix + "_" + hex.EncodeToString(buf[4:])
+	return key, prefix, HashAPIKey(key), nil
+}
+func HashAPIKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
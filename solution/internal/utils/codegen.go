@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"crypto/rand"
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// DefaultCodeAlphabet leaves out characters that are easy to confuse, like 0/O
+// and 1/I.
+const DefaultCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+var ErrInvalidCodePattern = errors.New("invalid code pattern")
+
+// CodePattern describes generated codes: Prefix followed by Length random
+// characters from Alphabet and, optionally, a Luhn mod N check character.
+type CodePattern struct {
+	Prefix     string `json:"prefix,omitempty"`
+	Length     int    `json:"length"`
+	Alphabet   string `json:"alphabet,omitempty"`
+	CheckDigit bool   `json:"check_digit,omitempty"`
+}
+
+func NewCodePattern(prefix string, length int, alphabet string, checkDigit bool) (CodePattern, error) {
+	if alphabet == "" {
+		alphabet = DefaultCodeAlphabet
+	}
+	p := CodePattern{Prefix: prefix, Length: length, Alphabet: alphabet, CheckDigit: checkDigit}
+	if length <= 0 || len(alphabet) < 2 {
+		return p, ErrInvalidCodePattern
+	}
+	for i := 0; i < len(alphabet); i++ {
+		if alphabet[i] <= ' ' || alphabet[i] > '~' || strings.IndexByte(alphabet, alphabet[i]) != i {
+			return p, ErrInvalidCodePattern
+		}
+	}
+	return p, nil
+}
+
+// CodeLength is the length of every code produced by the pattern.
+func (p CodePattern) CodeLength() int {
+	n := len(p.Prefix) + p.Length
+	if p.CheckDigit {
+		n++
+	}
+	return n
+}
+
+// Capacity is the number of distinct codes the pattern can produce.
+func (p CodePattern) Capacity() float64 {
+	return math.Pow(float64(len(p.Alphabet)), float64(p.Length))
+}
+
+// Fits reports whether count random codes stay cheap to find, which holds
+// while most of the space is free.
+func (p CodePattern) Fits(count int) bool {
+	return float64(count) <= p.Capacity()/2
+}
+func (p CodePattern) Generate() (string, error) {
+	max := big.NewInt(int64(len(p.Alphabet)))
+	body := make([]byte, p.Length)
+	for i := range body {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		body[i] = p.Alphabet[n.Int64()]
+	}
+	code := p.Prefix + string(body)
+	if p.CheckDigit {
+		code += string(p.Alphabet[luhnCheck(p.Alphabet, string(body))])
+	}
+	return code, nil
+}
+
+// Valid reports whether code could have been produced by the pattern,
+// including a correct check character.
+func (p CodePattern) Valid(code string) bool {
+	if len(code) != p.CodeLength() || !strings.HasPrefix(code, p.Prefix) {
+		return false
+	}
+	body := code[len(p.Prefix):]
+	for i := 0; i < len(body); i++ {
+		if strings.IndexByte(p.Alphabet, body[i]) < 0 {
+			return false
+		}
+	}
+	if !p.CheckDigit {
+		return true
+	}
+	return p.Alphabet[luhnCheck(p.Alphabet, body[:p.Length])] == body[p.Length]
+}
+
+// luhnCheck returns the index of the Luhn mod N check character for body.
+func luhnCheck(alphabet, body string) int {
+	n := len(alphabet)
+	sum := 0
+	factor := 2
+	for i := len(body) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(alphabet, body[i])
+		sum += addend/n + addend%n
+		if factor == 2 {
+			factor = 1
+		} else {
+			factor = 2
+		}
+	}
+	return (n - sum%n) % n
+}
@@ -10,6 +10,8 @@ import (
 
 const keyLength = 32
 
+// Encrypt and Decrypt are only kept to read passwords stored before
+// HashPassword was introduced; do not use them for new credentials.
 func Encrypt(data, key []byte) ([]byte, error) {
 	if len(key) != keyLength {
 		return nil, errors.New("invalid key length has been transmitted")
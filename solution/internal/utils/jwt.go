@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"errors"
 	"fmt"
 	"time"
 
@@ -9,34 +8,69 @@ import (
 )
 
 type JWTClaims struct {
-	ID   string `json:"id"`
-	Role string `json:"role"`
+	ID        string `json:"id"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid"`
+	// MemberID and MemberRole are set for team members acting on behalf of
+	// the company with ID.
+	MemberID   string `json:"mid,omitempty"`
+	MemberRole string `json:"mrole,omitempty"`
+	// APIKeyID and Scopes are set when the request is authenticated with an
+	// API key instead of a session token.
+	APIKeyID string   `json:"-"`
+	Scopes   []string `json:"-"`
 	jwt.RegisteredClaims
 }
 
-func CreateToken(id, role string, signingKey string) (string, error) {
-
-	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, JWTClaims{
-		ID:   id,
-		Role: role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
-	})
-	token, err := claims.SignedString([]byte(signingKey))
+func CreateToken(claims JWTClaims, keys *KeySet, ttl time.Duration) (string, error) {
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+	}
+	t := jwt.NewWithClaims(keys.method, claims)
+	t.Header["kid"] = keys.kid
+	token, err := t.SignedString(keys.signingKey)
 	if err != nil {
 		return "", err
 	}
 	return token, nil
 }
-func VerifyToken(tokenString, signingKey string) (*JWTClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{},func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
+
+// SessionAccount returns the account id and role the session is stored under.
+func (c *JWTClaims) SessionAccount() (string, string) {
+	if c.MemberID != "" {
+		return c.MemberID, "member"
+	}
+	return c.ID, c.Role
+}
+
+// IsOwner reports whether the caller signed in as the company itself or as a
+// member with the owner role.
+func (c *JWTClaims) IsOwner() bool {
+	if c.APIKeyID != "" {
+		return false
+	}
+	return c.MemberID == "" || c.MemberRole == "owner"
+}
+
+// HasScope reports whether the caller may use a route that needs any of the
+// scopes. The company account itself is not limited by scopes.
+func (c *JWTClaims) HasScope(scopes ...string) bool {
+	if c.Scopes == nil {
+		return true
+	}
+	for _, have := range c.Scopes {
+		for _, want := range scopes {
+			if have == want {
+				return true
+			}
 		}
-		return []byte(signingKey), nil
-	})
+	}
+	return false
+}
+func VerifyToken(tokenString string, keys *KeySet) (*JWTClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, keys.publicKey,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}))
 	if err != nil {
 		return nil, err
 	}
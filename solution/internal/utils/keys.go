@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeySet signs access tokens with one private key and verifies them with any
+// of its public keys, so old keys keep working during a rotation window.
+type KeySet struct {
+	kid        string
+	signingKey crypto.Signer
+	method     jwt.SigningMethod
+	verifyKeys map[string]crypto.PublicKey
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewKeySet builds a key set around an RSA or Ed25519 signing key. An empty
+// kid is replaced by the key thumbprint.
+func NewKeySet(kid string, signingKey crypto.Signer, verifyKeys map[string]crypto.PublicKey) (*KeySet, error) {
+	method, err := signingMethod(signingKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	if kid == "" {
+		kid, err = KeyID(signingKey.Public())
+		if err != nil {
+			return nil, err
+		}
+	}
+	ks := &KeySet{
+		kid:        kid,
+		signingKey: signingKey,
+		method:     method,
+		verifyKeys: map[string]crypto.PublicKey{kid: signingKey.Public()},
+	}
+	for id, key := range verifyKeys {
+		if _, err := signingMethod(key); err != nil {
+			return nil, fmt.Errorf("verification key %q: %w", id, err)
+		}
+		if _, ok := ks.verifyKeys[id]; !ok {
+			ks.verifyKeys[id] = key
+		}
+	}
+	return ks, nil
+}
+
+// LoadKeySet reads a PEM private key and PEM public keys indexed by kid.
+func LoadKeySet(privateKeyFile, kid string, verifyKeyFiles map[string]string) (*KeySet, error) {
+	data, err := os.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	signingKey, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	verifyKeys := make(map[string]crypto.PublicKey, len(verifyKeyFiles))
+	for id, file := range verifyKeyFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("verification key %q: %w", id, err)
+		}
+		verifyKeys[id] = key
+	}
+	return NewKeySet(kid, signingKey, verifyKeys)
+}
+
+// GenerateKeySet creates a throwaway Ed25519 key. Tokens signed with it do
+// not survive a restart and are not accepted by other replicas.
+func GenerateKeySet() (*KeySet, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySet("", key, nil)
+}
+
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// KeyID derives a stable kid from the public key.
+func KeyID(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12]), nil
+}
+
+func (ks *KeySet) KeyID() string {
+	return ks.kid
+}
+func (ks *KeySet) Algorithm() string {
+	return ks.method.Alg()
+}
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.verifyKeys))}
+	for kid, key := range ks.verifyKeys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				Alg: jwt.SigningMethodRS256.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "OKP",
+				Kid: kid,
+				Use: "sig",
+				Alg: jwt.SigningMethodEdDSA.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(k),
+			})
+		}
+	}
+	return jwks
+}
+
+func (ks *KeySet) publicKey(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	key, ok := ks.verifyKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	method, err := signingMethod(key)
+	if err != nil {
+		return nil, err
+	}
+	if t.Method.Alg() != method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key, nil
+}
+
+func signingMethod(key crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
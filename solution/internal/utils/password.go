@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"crypto/subtle"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHashCost is the bcrypt work factor for new hashes. Raising it makes
+// CheckPassword report older hashes for rehashing on the next sign-in.
+const PasswordHashCost = 12
+
+func HashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), PasswordHashCost)
+}
+
+// CheckPassword verifies password against a stored bcrypt hash or, for accounts
+// created before hashing was introduced, against an AES-GCM ciphertext made with
+// legacyKey. needsRehash is set when the stored value should be replaced by a
+// fresh HashPassword result.
+func CheckPassword(stored []byte, password string, legacyKey []byte) (ok, needsRehash bool) {
+	if cost, err := bcrypt.Cost(stored); err == nil {
+		if bcrypt.CompareHashAndPassword(stored, []byte(password)) != nil {
+			return false, false
+		}
+		return true, cost < PasswordHashCost
+	}
+	decrypted, err := Decrypt(stored, legacyKey)
+	if err != nil {
+		return false, false
+	}
+	if subtle.ConstantTimeCompare(decrypted, []byte(password)) != 1 {
+		return false, false
+	}
+	return true, true
+}
+
+// RehashLegacyPassword turns an AES-GCM ciphertext made with legacyKey into a
+// bcrypt hash of the same password.
+func RehashLegacyPassword(stored, legacyKey []byte) ([]byte, error) {
+	decrypted, err := Decrypt(stored, legacyKey)
+	if err != nil {
+		return nil, err
+	}
+	return bcrypt.GenerateFromPassword(decrypted, PasswordHashCost)
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// NewRefreshToken returns an opaque "<session id>.<secret>" token and the hash
+// of its secret. Only the hash is stored, the token itself is shown once.
+func NewRefreshToken(sessionID string) (string, []byte, error) {
+	secret, hash, err := NewSecretToken()
+	if err != nil {
+		return "", nil, err
+	}
+	return sessionID + "." + secret, hash, nil
+}
+
+// NewSecretToken returns a random single-use token, e.g. for invites, and the
+// hash to store for it.
+func NewSecretToken() (string, []byte, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	return encoded, HashSecretToken(encoded), nil
+}
+
+// ParseRefreshToken splits a token made by NewRefreshToken into the session id
+// and the hash of the secret.
+func ParseRefreshToken(token string) (string, []byte, error) {
+	sessionID, secret, ok := strings.Cut(token, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return "", nil, ErrInvalidRefreshToken
+	}
+	return sessionID, HashSecretToken(secret), nil
+}
+
+func HashSecretToken(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
@@ -0,0 +1,42 @@
+package utils
+
+import "time"
+
+// DefaultTimeZone is the zone of companies that did not choose one; the service
+// used to run on Moscow time.
+const DefaultTimeZone = "Europe/Moscow"
+
+// Location loads the named zone, falling back to DefaultTimeZone.
+func Location(name string) *time.Location {
+	if name != "" {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	loc, err := time.LoadLocation(DefaultTimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// ParseDate reads either a TimeFormat day in loc or an RFC 3339 instant. A day
+// stands for its first second, or for its last one when endOfDay is set.
+func ParseDate(value string, loc *time.Location, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(TimeFormat, value, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Second)
+	}
+	return t, nil
+}
+
+// FormatDate returns the day of the instant in loc.
+func FormatDate(ts int64, loc *time.Location) string {
+	return time.Unix(ts, 0).In(loc).Format(TimeFormat)
+}
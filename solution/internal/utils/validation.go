@@ -14,7 +14,8 @@ var Validate = validator.New(validator.WithRequiredStructEnabled())
 func PasswordValidationFunc(fl validator.FieldLevel) bool {
 	var digit, upper, lower, symbol bool
 	password := fl.Field().String()
-	if len(password) < 8 && len(password) > 60 {
+	// bcrypt rejects passwords over 72 bytes, so the bound is in bytes.
+	if len(password) < 8 || len(password) > 72 {
 		return false
 	}
 	symbol = strings.ContainsAny(password, "@$!%*?&")
@@ -26,10 +27,8 @@ func PasswordValidationFunc(fl validator.FieldLevel) bool {
 
 func DateValidationFunc(fl validator.FieldLevel) bool {
 	date := fl.Field().String()
-	if _, err := time.Parse(TimeFormat, date); err != nil {
-		return false
-	}
-	return true
+	_, err := ParseDate(date, time.UTC, false)
+	return err == nil
 }
 func CountryValidationFunc(fl validator.FieldLevel) bool {
 	type Cntry struct {
@@ -0,0 +1,6 @@
+DROP TABLE if exists comments;
+DROP TABLE if exists promosstat;
+DROP TABLE if exists activations;
+DROP TABLE if exists promos;
+DROP TABLE if exists users;
+DROP TABLE if exists companies;
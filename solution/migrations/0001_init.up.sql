@@ -0,0 +1,81 @@
+CREATE TABLE if not exists companies
+(
+    company_id uuid NOT NULL,
+    name character varying(50) NOT NULL,
+    email character varying(120) NOT NULL,
+    password bytea NOT NULL,
+    PRIMARY KEY (company_id, email)
+);
+
+-- An early schema file named the column company_name; bring such databases in line.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'companies' AND column_name = 'company_name') THEN
+        ALTER TABLE companies RENAME COLUMN company_name TO name;
+    END IF;
+END $$;
+
+CREATE TABLE if not exists users
+(
+    id uuid NOT NULL,
+    name character varying(120) NOT NULL,
+    surname character varying(140) NOT NULL,
+    email character varying(120) NOT NULL,
+    avatar_url text,
+    other jsonb NOT NULL,
+    password bytea NOT NULL,
+    PRIMARY KEY (id, email)
+);
+
+CREATE TABLE if not exists promos
+(
+    id serial NOT NULL,
+    description text NOT NULL,
+    image_url text,
+    target jsonb,
+    max_count integer NOT NULL,
+    active_from bigint,
+    active_until bigint,
+    mode character varying(16) NOT NULL,
+    promo_common character varying(64),
+    promo_unique character varying(64)[],
+    used_promo_unique character varying(64)[],
+    promo_id uuid NOT NULL,
+    company_id uuid NOT NULL,
+    company_name character varying(64) NOT NULL,
+    like_count integer NOT NULL,
+    used_count integer NOT NULL,
+    comment_count int NOT NULL,
+    active boolean NOT NULL,
+    PRIMARY KEY (promo_id)
+);
+
+CREATE TABLE if not exists activations
+(
+    seq_id serial NOT NULL,
+    activate_time bigint,
+    country character varying(4) NOT NULL,
+    promo_id uuid NOT NULL,
+    id uuid NOT NULL,
+    PRIMARY KEY (seq_id)
+);
+
+CREATE TABLE if not exists promosstat
+(
+    promo_id uuid NOT NULL,
+    id uuid NOT NULL,
+    is_liked_by_user boolean NOT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE if not exists comments
+(
+    serial_number serial NOT NULL,
+    id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    promo_id uuid NOT NULL,
+    text text NOT NULL,
+    date varchar(50) NOT NULL,
+    author jsonb NOT NULL,
+    PRIMARY KEY (serial_number, id)
+);
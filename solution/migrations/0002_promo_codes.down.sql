@@ -0,0 +1,9 @@
+ALTER TABLE promos ADD COLUMN if not exists promo_unique character varying(64)[];
+ALTER TABLE promos ADD COLUMN if not exists used_promo_unique character varying(64)[];
+
+UPDATE promos p SET
+    promo_unique = coalesce((SELECT array_agg(code ORDER BY id) FROM promo_codes WHERE promo_id = p.promo_id), '{}'),
+    used_promo_unique = coalesce((SELECT array_agg(code ORDER BY id) FROM promo_codes WHERE promo_id = p.promo_id AND status <> 'AVAILABLE'), '{}')
+WHERE p.mode = 'UNIQUE';
+
+DROP TABLE if exists promo_codes;
@@ -0,0 +1,32 @@
+CREATE TABLE if not exists promo_codes
+(
+    id bigserial NOT NULL,
+    promo_id uuid NOT NULL,
+    code character varying(64) NOT NULL,
+    status character varying(16) NOT NULL,
+    user_id uuid,
+    activated_at bigint,
+    PRIMARY KEY (id)
+);
+CREATE INDEX if not exists promo_codes_promo_id_status_idx ON promo_codes (promo_id, status, id);
+CREATE INDEX if not exists promo_codes_code_idx ON promo_codes (code);
+
+-- UNIQUE codes used to live in arrays on promos. The first occurrences of every
+-- code that also appears in used_promo_unique are carried over as ACTIVATED.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'promos' AND column_name = 'promo_unique') THEN
+        INSERT INTO promo_codes (promo_id, code, status)
+        SELECT p.promo_id, c.code,
+            CASE WHEN c.occurrence <= (SELECT count(*) FROM unnest(p.used_promo_unique) AS u(code) WHERE u.code = c.code)
+                THEN 'ACTIVATED' ELSE 'AVAILABLE' END
+        FROM promos p
+        CROSS JOIN LATERAL (
+            SELECT t.code, t.ord, row_number() OVER (PARTITION BY t.code ORDER BY t.ord) AS occurrence
+            FROM unnest(p.promo_unique) WITH ORDINALITY AS t(code, ord)
+        ) c
+        WHERE p.mode = 'UNIQUE'
+        ORDER BY p.id, c.ord;
+        ALTER TABLE promos DROP COLUMN promo_unique, DROP COLUMN used_promo_unique;
+    END IF;
+END $$;
@@ -0,0 +1,15 @@
+CREATE TABLE if not exists sessions
+(
+    id uuid NOT NULL,
+    account_id uuid NOT NULL,
+    role character varying(16) NOT NULL,
+    refresh_hash bytea NOT NULL,
+    user_agent text,
+    ip character varying(64),
+    created_at bigint NOT NULL,
+    last_used_at bigint NOT NULL,
+    expires_at bigint NOT NULL,
+    revoked_at bigint,
+    PRIMARY KEY (id)
+);
+CREATE INDEX if not exists sessions_account_id_idx ON sessions (account_id, role);
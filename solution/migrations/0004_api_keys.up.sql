@@ -0,0 +1,15 @@
+CREATE TABLE if not exists api_keys
+(
+    id uuid NOT NULL,
+    company_id uuid NOT NULL,
+    name character varying(100) NOT NULL,
+    prefix character varying(16) NOT NULL,
+    key_hash bytea NOT NULL,
+    scopes text[] NOT NULL,
+    created_at bigint NOT NULL,
+    last_used_at bigint,
+    revoked_at bigint,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX if not exists api_keys_key_hash_idx ON api_keys (key_hash);
+CREATE INDEX if not exists api_keys_company_id_idx ON api_keys (company_id);
@@ -0,0 +1,2 @@
+DROP TABLE if exists audit_log;
+DROP TABLE if exists company_members;
@@ -0,0 +1,32 @@
+CREATE TABLE if not exists company_members
+(
+    id uuid NOT NULL,
+    company_id uuid NOT NULL,
+    email character varying(120) NOT NULL,
+    name character varying(120) NOT NULL,
+    role character varying(16) NOT NULL CHECK (role IN ('owner', 'editor', 'analyst')),
+    password bytea,
+    invite_hash bytea,
+    invite_expires_at bigint,
+    invited_by uuid,
+    created_at bigint NOT NULL,
+    joined_at bigint,
+    removed_at bigint,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX if not exists company_members_email_idx ON company_members (email) WHERE removed_at IS NULL;
+CREATE UNIQUE INDEX if not exists company_members_invite_hash_idx ON company_members (invite_hash);
+CREATE INDEX if not exists company_members_company_id_idx ON company_members (company_id);
+
+CREATE TABLE if not exists audit_log
+(
+    id bigserial NOT NULL,
+    company_id uuid NOT NULL,
+    member_id uuid,
+    api_key_id uuid,
+    action character varying(64) NOT NULL,
+    target_id character varying(64),
+    created_at bigint NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX if not exists audit_log_company_id_idx ON audit_log (company_id, created_at DESC);
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN if exists email_verified_at;
+ALTER TABLE companies DROP COLUMN if exists email_verified_at;
+DROP TABLE if exists one_time_tokens;
@@ -0,0 +1,15 @@
+CREATE TABLE if not exists one_time_tokens
+(
+    token_hash bytea NOT NULL,
+    purpose character varying(32) NOT NULL,
+    account_id uuid NOT NULL,
+    role character varying(16) NOT NULL,
+    created_at bigint NOT NULL,
+    expires_at bigint NOT NULL,
+    used_at bigint,
+    PRIMARY KEY (token_hash)
+);
+CREATE INDEX if not exists one_time_tokens_account_idx ON one_time_tokens (account_id, purpose);
+
+ALTER TABLE companies ADD COLUMN if not exists email_verified_at bigint;
+ALTER TABLE users ADD COLUMN if not exists email_verified_at bigint;
@@ -0,0 +1,12 @@
+CREATE TABLE if not exists security_events
+(
+    id bigserial NOT NULL,
+    event character varying(64) NOT NULL,
+    scope character varying(16),
+    email character varying(120),
+    ip character varying(64),
+    details text,
+    created_at bigint NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX if not exists security_events_email_idx ON security_events (email, created_at DESC);
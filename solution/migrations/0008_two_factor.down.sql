@@ -0,0 +1,3 @@
+ALTER TABLE sessions DROP COLUMN if exists confirmed_at;
+DROP TABLE if exists recovery_codes;
+DROP TABLE if exists two_factor;
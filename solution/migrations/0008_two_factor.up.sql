@@ -0,0 +1,21 @@
+CREATE TABLE if not exists two_factor
+(
+    account_id uuid NOT NULL,
+    role character varying(16) NOT NULL,
+    secret character varying(64) NOT NULL,
+    last_step bigint NOT NULL DEFAULT 0,
+    created_at bigint NOT NULL,
+    enabled_at bigint,
+    PRIMARY KEY (account_id, role)
+);
+CREATE TABLE if not exists recovery_codes
+(
+    code_hash bytea NOT NULL,
+    account_id uuid NOT NULL,
+    role character varying(16) NOT NULL,
+    used_at bigint,
+    PRIMARY KEY (code_hash)
+);
+CREATE INDEX if not exists recovery_codes_account_idx ON recovery_codes (account_id, role);
+
+ALTER TABLE sessions ADD COLUMN if not exists confirmed_at bigint;
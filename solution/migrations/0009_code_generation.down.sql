@@ -0,0 +1,3 @@
+DROP TABLE if exists code_generation_jobs;
+DROP INDEX if exists promo_codes_company_code_idx;
+ALTER TABLE promo_codes DROP COLUMN if exists company_id;
@@ -0,0 +1,25 @@
+ALTER TABLE promo_codes ADD COLUMN if not exists company_id uuid;
+UPDATE promo_codes SET company_id = promos.company_id
+FROM promos
+WHERE promos.promo_id = promo_codes.promo_id AND promo_codes.company_id IS NULL;
+CREATE INDEX if not exists promo_codes_company_code_idx ON promo_codes (company_id, code);
+
+CREATE TABLE if not exists code_generation_jobs
+(
+    id uuid NOT NULL,
+    promo_id uuid NOT NULL,
+    company_id uuid NOT NULL,
+    prefix character varying(20) NOT NULL DEFAULT '',
+    length integer NOT NULL,
+    alphabet character varying(64) NOT NULL,
+    check_digit boolean NOT NULL DEFAULT false,
+    total integer NOT NULL,
+    generated integer NOT NULL DEFAULT 0,
+    status character varying(16) NOT NULL,
+    error text,
+    created_at bigint NOT NULL,
+    updated_at bigint NOT NULL,
+    finished_at bigint,
+    PRIMARY KEY (id)
+);
+CREATE INDEX if not exists code_generation_jobs_unfinished_idx ON code_generation_jobs (status) WHERE status IN ('PENDING', 'RUNNING');
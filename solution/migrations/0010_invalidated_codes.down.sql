@@ -0,0 +1,2 @@
+UPDATE promo_codes SET status = 'AVAILABLE' WHERE status = 'INVALIDATED';
+ALTER TABLE promo_codes DROP COLUMN if exists invalidated_at;
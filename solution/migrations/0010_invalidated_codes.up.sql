@@ -0,0 +1 @@
+ALTER TABLE promo_codes ADD COLUMN if not exists invalidated_at bigint;
@@ -0,0 +1,3 @@
+DROP INDEX if exists activations_promo_id_idx;
+DROP INDEX if exists promos_company_archived_idx;
+ALTER TABLE promos DROP COLUMN if exists archived_at;
@@ -0,0 +1,3 @@
+ALTER TABLE promos ADD COLUMN if not exists archived_at bigint;
+CREATE INDEX if not exists promos_company_archived_idx ON promos (company_id, archived_at);
+CREATE INDEX if not exists activations_promo_id_idx ON activations (promo_id);
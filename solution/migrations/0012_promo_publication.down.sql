@@ -0,0 +1,2 @@
+DROP INDEX if exists promos_publish_at_idx;
+ALTER TABLE promos DROP COLUMN if exists publish_at;
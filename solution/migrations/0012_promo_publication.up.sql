@@ -0,0 +1,4 @@
+ALTER TABLE promos ADD COLUMN if not exists publish_at bigint;
+-- Promos created before drafts existed were visible right away.
+UPDATE promos SET publish_at = extract(epoch FROM now())::bigint WHERE publish_at IS NULL;
+CREATE INDEX if not exists promos_publish_at_idx ON promos (publish_at);
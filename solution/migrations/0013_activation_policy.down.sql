@@ -0,0 +1,2 @@
+DROP INDEX if exists activations_promo_user_idx;
+ALTER TABLE promos DROP COLUMN if exists activation_policy;
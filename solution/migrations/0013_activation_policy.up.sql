@@ -0,0 +1,2 @@
+ALTER TABLE promos ADD COLUMN if not exists activation_policy jsonb;
+CREATE INDEX if not exists activations_promo_user_idx ON activations (promo_id, id, activate_time);
@@ -0,0 +1 @@
+ALTER TABLE promos DROP COLUMN if exists schedule;
@@ -0,0 +1 @@
+ALTER TABLE promos ADD COLUMN if not exists schedule jsonb;
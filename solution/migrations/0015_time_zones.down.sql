@@ -0,0 +1,13 @@
+UPDATE promos p
+SET active_from = CASE WHEN p.active_from IS NULL THEN NULL ELSE
+        extract(epoch FROM (to_timestamp(p.active_from) AT TIME ZONE c.time_zone)::date::timestamp AT TIME ZONE 'UTC')::bigint END,
+    active_until = CASE WHEN p.active_until IS NULL THEN NULL ELSE
+        extract(epoch FROM (to_timestamp(p.active_until) AT TIME ZONE c.time_zone)::date::timestamp AT TIME ZONE 'UTC')::bigint END
+FROM companies c
+WHERE c.company_id = p.company_id AND (p.active_from IS NOT NULL OR p.active_until IS NOT NULL);
+UPDATE comments
+SET date = to_char((date::timestamptz + interval '3 hours') AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+WHERE date ~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}';
+UPDATE promo_codes SET activated_at = activated_at + 10800 WHERE activated_at IS NOT NULL;
+UPDATE activations SET activate_time = activate_time + 10800 WHERE activate_time IS NOT NULL;
+ALTER TABLE companies DROP COLUMN if exists time_zone;
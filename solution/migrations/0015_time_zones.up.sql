@@ -0,0 +1,18 @@
+ALTER TABLE companies ADD COLUMN if not exists time_zone varchar(64) NOT NULL DEFAULT 'Europe/Moscow';
+
+-- Timestamps used to be taken on a clock shifted to UTC+3; make them real instants.
+UPDATE activations SET activate_time = activate_time - 10800 WHERE activate_time IS NOT NULL;
+UPDATE promo_codes SET activated_at = activated_at - 10800 WHERE activated_at IS NOT NULL;
+UPDATE comments
+SET date = to_char((date::timestamptz - interval '3 hours') AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+WHERE date ~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}';
+
+-- Dates were stored as midnight UTC of the day; active_from is now the start of
+-- that day in the company zone and active_until its last second.
+UPDATE promos p
+SET active_from = CASE WHEN p.active_from IS NULL THEN NULL ELSE
+        extract(epoch FROM (to_timestamp(p.active_from) AT TIME ZONE 'UTC')::date::timestamp AT TIME ZONE c.time_zone)::bigint END,
+    active_until = CASE WHEN p.active_until IS NULL THEN NULL ELSE
+        extract(epoch FROM ((to_timestamp(p.active_until) AT TIME ZONE 'UTC')::date + 1)::timestamp AT TIME ZONE c.time_zone)::bigint - 1 END
+FROM companies c
+WHERE c.company_id = p.company_id AND (p.active_from IS NOT NULL OR p.active_until IS NOT NULL);
@@ -0,0 +1,3 @@
+DROP INDEX if exists promos_active_until_idx;
+DROP INDEX if exists promos_active_from_idx;
+DROP TABLE if exists promo_transitions;
@@ -0,0 +1,12 @@
+CREATE TABLE if not exists promo_transitions
+(
+    id bigserial NOT NULL,
+    promo_id uuid NOT NULL,
+    active boolean NOT NULL,
+    reason character varying(16) NOT NULL,
+    created_at bigint NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX if not exists promo_transitions_promo_id_idx ON promo_transitions (promo_id, id);
+CREATE INDEX if not exists promos_active_from_idx ON promos (active_from);
+CREATE INDEX if not exists promos_active_until_idx ON promos (active_until);
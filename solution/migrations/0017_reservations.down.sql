@@ -0,0 +1,14 @@
+-- Held codes go back to the pool, reservations that never got confirmed are dropped.
+UPDATE promo_codes SET status = 'AVAILABLE', user_id = NULL
+WHERE id IN (SELECT code_id FROM activations WHERE status = 'RESERVED' AND code_id IS NOT NULL);
+UPDATE promos SET used_count = used_count - r.n
+FROM (SELECT promo_id, count(*) AS n FROM activations WHERE status = 'RESERVED' GROUP BY promo_id) AS r
+WHERE promos.promo_id = r.promo_id;
+DELETE FROM activations WHERE status <> 'CONFIRMED';
+DROP INDEX if exists activations_reserved_expires_idx;
+DROP INDEX if exists activations_reservation_id_idx;
+ALTER TABLE activations DROP COLUMN if exists finished_at;
+ALTER TABLE activations DROP COLUMN if exists expires_at;
+ALTER TABLE activations DROP COLUMN if exists code_id;
+ALTER TABLE activations DROP COLUMN if exists reservation_id;
+ALTER TABLE activations DROP COLUMN if exists status;
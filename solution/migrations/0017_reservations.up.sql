@@ -0,0 +1,7 @@
+ALTER TABLE activations ADD COLUMN if not exists status character varying(16) NOT NULL DEFAULT 'CONFIRMED';
+ALTER TABLE activations ADD COLUMN if not exists reservation_id uuid;
+ALTER TABLE activations ADD COLUMN if not exists code_id bigint;
+ALTER TABLE activations ADD COLUMN if not exists expires_at bigint;
+ALTER TABLE activations ADD COLUMN if not exists finished_at bigint;
+CREATE UNIQUE INDEX if not exists activations_reservation_id_idx ON activations (reservation_id);
+CREATE INDEX if not exists activations_reserved_expires_idx ON activations (expires_at) WHERE status = 'RESERVED';
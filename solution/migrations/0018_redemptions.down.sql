@@ -0,0 +1,3 @@
+DROP INDEX if exists redemptions_promo_code_idx;
+DROP INDEX if exists redemptions_code_id_idx;
+DROP TABLE if exists redemptions;
@@ -0,0 +1,16 @@
+CREATE TABLE if not exists redemptions
+(
+    id bigserial NOT NULL,
+    company_id uuid NOT NULL,
+    promo_id uuid NOT NULL,
+    code character varying(64) NOT NULL,
+    code_id bigint,
+    user_id uuid,
+    store_id character varying(64) NOT NULL,
+    terminal_id character varying(64),
+    redeemed_at bigint NOT NULL,
+    PRIMARY KEY (id)
+);
+-- A unique code can be redeemed once, common codes as many times as they were issued.
+CREATE UNIQUE INDEX if not exists redemptions_code_id_idx ON redemptions (code_id);
+CREATE INDEX if not exists redemptions_promo_code_idx ON redemptions (promo_id, code, id);
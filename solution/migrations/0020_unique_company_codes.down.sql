@@ -0,0 +1,2 @@
+DROP INDEX if exists promo_codes_company_code_key;
+CREATE INDEX if not exists promo_codes_company_code_idx ON promo_codes (company_id, code);
@@ -0,0 +1,10 @@
+-- Codes listed at promo creation were not checked against the other promos of
+-- the company. Drop the repeats nobody was issued, keeping the oldest one when
+-- none was; repeats issued to several users have to be resolved by hand first.
+DELETE FROM promo_codes d
+USING promo_codes k
+WHERE d.company_id = k.company_id AND d.code = k.code AND d.id <> k.id
+  AND d.status IN ('AVAILABLE', 'INVALIDATED')
+  AND (k.status NOT IN ('AVAILABLE', 'INVALIDATED') OR k.id < d.id);
+DROP INDEX if exists promo_codes_company_code_idx;
+CREATE UNIQUE INDEX if not exists promo_codes_company_code_key ON promo_codes (company_id, code);
@@ -0,0 +1,8 @@
+// Package migrations embeds numbered schema migrations: NNNN_name.up.sql applies
+// a change and NNNN_name.down.sql reverts it.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
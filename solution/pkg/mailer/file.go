@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"solution/pkg/logger"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// FileMailer appends every message to a local file instead of sending it.
+type FileMailer struct {
+	mu   sync.Mutex
+	path string
+	from string
+}
+
+func NewFile(path, from string) *FileMailer {
+	return &FileMailer{path: path, from: from}
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "Date: %s\nFrom: %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format(time.RFC3339), m.from, msg.To, msg.Subject, msg.Body)
+	return err
+}
+
+// LogMailer writes messages to the service log. Bodies carry one-time tokens,
+// so only their size is logged.
+type LogMailer struct {
+	l    logger.Logger
+	from string
+}
+
+func NewLog(l logger.Logger, from string) *LogMailer {
+	return &LogMailer{l: l, from: from}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	m.l.Info(ctx, "mail", zap.String("from", m.from), zap.String("to", msg.To), zap.String("subject", msg.Subject), zap.Int("body_bytes", len(msg.Body)))
+	return nil
+}
@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"solution/pkg/logger"
+)
+
+type MailerConfig struct {
+	// Driver is one of "smtp", "file" or "log". The log driver leaves message
+	// bodies out; "file" keeps them for reading links in development.
+	Driver       string `env:"MAIL_DRIVER" env-default:"log"`
+	From         string `env:"MAIL_FROM" env-default:"no-reply@promo.local"`
+	SMTPHost     string `env:"SMTP_HOST"`
+	SMTPPort     string `env:"SMTP_PORT" env-default:"587"`
+	SMTPUsername string `env:"SMTP_USERNAME"`
+	SMTPPassword string `env:"SMTP_PASSWORD"`
+	FilePath     string `env:"MAIL_FILE_PATH" env-default:"mail.log"`
+}
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+func New(cfg MailerConfig, l logger.Logger) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTP(cfg), nil
+	case "file":
+		return NewFile(cfg.FilePath, cfg.From), nil
+	case "log":
+		return NewLog(l, cfg.From), nil
+	}
+	return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+}
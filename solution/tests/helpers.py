@@ -0,0 +1,88 @@
+"""Внешние функции для tavern-тестов."""
+import base64
+import hashlib
+import hmac
+import json
+import os
+import struct
+import time
+from datetime import datetime, timedelta, timezone
+
+import rsa
+
+KEYS_DIR = os.path.join(os.path.dirname(__file__), "components", "keys")
+
+
+def _b64url(data: bytes) -> str:
+    return base64.urlsafe_b64encode(data).rstrip(b"=").decode()
+
+
+def _b64url_decode(data: str) -> bytes:
+    return base64.urlsafe_b64decode(data + "=" * (-len(data) % 4))
+
+
+def root_url(response):
+    """JWKS и /internal лежат вне /api, поэтому выводим корень из адреса ответа."""
+    return {"root_url": response.url.split("/api/", 1)[0]}
+
+
+def near_future(response, **offsets):
+    """Моменты через указанное число секунд в RFC 3339, например
+    near_future(starts=2) сохраняет starts. Нужны, чтобы дождаться границы
+    действия промокода, которую отрабатывает планировщик."""
+    now = datetime.now(timezone.utc).replace(microsecond=0)
+    return {
+        name: (now + timedelta(seconds=int(seconds))).strftime("%Y-%m-%dT%H:%M:%SZ")
+        for name, seconds in offsets.items()
+    }
+
+
+def closing_window(response):
+    """Окно расписания в UTC, которое открыто сейчас и закрывается в начале
+    следующей минуты."""
+    now = datetime.now(timezone.utc).replace(second=0, microsecond=0)
+    return {
+        "window_from": (now - timedelta(minutes=1)).strftime("%H:%M"),
+        "window_until": (now + timedelta(minutes=1)).strftime("%H:%M"),
+    }
+
+
+def check_jwks(response, kids):
+    """Проверяет, что JWKS публикует ровно ключи с указанными kid."""
+    published = sorted(key["kid"] for key in response.json()["keys"])
+    assert published == sorted(kids), published
+
+
+def resign_token(response, token, kid, key="previous.pem"):
+    """Переподписывает полезную нагрузку токена RS256-ключом из components/keys."""
+    with open(os.path.join(KEYS_DIR, key), "rb") as f:
+        private_key = rsa.PrivateKey.load_pkcs1(f.read())
+    payload = json.loads(_b64url_decode(token.split(".")[1]))
+    header = {"alg": "RS256", "typ": "JWT", "kid": kid}
+    signing_input = ".".join(
+        _b64url(json.dumps(part, separators=(",", ":")).encode())
+        for part in (header, payload)
+    )
+    signature = rsa.sign(signing_input.encode(), private_key, "SHA-256")
+    return {"resigned_token": f"{signing_input}.{_b64url(signature)}"}
+
+
+def _totp(secret, offset=0):
+    key = base64.b32decode(secret.upper() + "=" * (-len(secret) % 8))
+    step = int(time.time()) // 30 + int(offset)
+    digest = hmac.new(key, struct.pack(">q", step), hashlib.sha1).digest()
+    start = digest[-1] & 0x0F
+    value = struct.unpack(">I", digest[start:start + 4])[0] & 0x7FFFFFFF
+    return f"{value % 1000000:06d}"
+
+
+def enrolled_totp(response):
+    """Сохраняет секрет из ответа на подключение 2FA и текущий код по нему."""
+    secret = response.json()["secret"]
+    return {"totp_secret": secret, "totp_code": _totp(secret)}
+
+
+def totp(response, secret, offset=0):
+    """Код для шага offset относительно текущего. Сервер принимает соседние
+    шаги, а каждый шаг только один раз, поэтому следующий код берут с offset=1."""
+    return {"totp_code": _totp(secret, offset)}
@@ -0,0 +1,179 @@
+"""Параллельная активация промокодов.
+
+Тесты одновременно отправляют много запросов на
+POST /user/promo/{id}/activate и проверяют, что сервис не выдаёт
+один UNIQUE промокод дважды и не превышает max_count у COMMON промокода.
+"""
+
+import os
+import uuid
+from concurrent.futures import ThreadPoolExecutor
+
+import pytest
+import requests
+
+BASE_URL = os.environ.get("BASE_URL", "http://localhost:8080/api")
+
+WORKERS = 32
+TIMEOUT = 30
+
+
+def _unique_email(prefix: str) -> str:
+    return f"{prefix}-{uuid.uuid4().hex[:12]}@concurrency.test"
+
+
+def _sign_up_company() -> str:
+    resp = requests.post(
+        f"{BASE_URL}/business/auth/sign-up",
+        json={
+            "name": "Параллельная компания",
+            "email": _unique_email("company"),
+            "password": "SuperStrongPassword2000!",
+        },
+        timeout=TIMEOUT,
+    )
+    assert resp.status_code == 200, resp.text
+    return resp.json()["token"]
+
+
+def _sign_up_user(index: int) -> str:
+    resp = requests.post(
+        f"{BASE_URL}/user/auth/sign-up",
+        json={
+            "name": f"User{index}",
+            "surname": "Concurrent",
+            "email": _unique_email(f"user{index}"),
+            "password": "HardPASSword1!",
+            "other": {"age": 30, "country": "ru"},
+        },
+        timeout=TIMEOUT,
+    )
+    assert resp.status_code == 200, resp.text
+    return resp.json()["token"]
+
+
+def _create_promo(company_token: str, body: dict) -> str:
+    resp = requests.post(
+        f"{BASE_URL}/business/promo",
+        headers={"Authorization": f"Bearer {company_token}"},
+        json={**body, "publish": True},
+        timeout=TIMEOUT,
+    )
+    assert resp.status_code == 201, resp.text
+    return resp.json()["id"]
+
+
+def _activate(promo_id: str, user_token: str) -> requests.Response:
+    return requests.post(
+        f"{BASE_URL}/user/promo/{promo_id}/activate",
+        headers={"Authorization": f"Bearer {user_token}"},
+        timeout=TIMEOUT,
+    )
+
+
+def _activate_parallel(promo_id: str, user_tokens: list[str]) -> list[requests.Response]:
+    with ThreadPoolExecutor(max_workers=WORKERS) as pool:
+        return list(pool.map(lambda token: _activate(promo_id, token), user_tokens))
+
+
+def _get_promo(company_token: str, promo_id: str) -> dict:
+    resp = requests.get(
+        f"{BASE_URL}/business/promo/{promo_id}",
+        headers={"Authorization": f"Bearer {company_token}"},
+        timeout=TIMEOUT,
+    )
+    assert resp.status_code == 200, resp.text
+    return resp.json()
+
+
+def _get_stat(company_token: str, promo_id: str) -> dict:
+    resp = requests.get(
+        f"{BASE_URL}/business/promo/{promo_id}/stat",
+        headers={"Authorization": f"Bearer {company_token}"},
+        timeout=TIMEOUT,
+    )
+    assert resp.status_code == 200, resp.text
+    return resp.json()
+
+
+@pytest.fixture(scope="module")
+def company_token() -> str:
+    return _sign_up_company()
+
+
+@pytest.fixture(scope="module")
+def user_tokens() -> list[str]:
+    with ThreadPoolExecutor(max_workers=WORKERS) as pool:
+        return list(pool.map(_sign_up_user, range(60)))
+
+
+def test_common_promo_never_exceeds_max_count(company_token, user_tokens):
+    max_count = 25
+    promo_id = _create_promo(
+        company_token,
+        {
+            "description": "Параллельная активация COMMON промокода",
+            "target": {},
+            "max_count": max_count,
+            "mode": "COMMON",
+            "promo_common": "race-common",
+        },
+    )
+
+    responses = _activate_parallel(promo_id, user_tokens)
+
+    succeeded = [r for r in responses if r.status_code == 200]
+    assert len(succeeded) == max_count
+    assert all(r.json()["promo"] == "race-common" for r in succeeded)
+    assert all(r.status_code == 403 for r in responses if r.status_code != 200)
+
+    promo = _get_promo(company_token, promo_id)
+    assert promo["used_count"] == max_count
+    assert promo["active"] is False
+    assert _get_stat(company_token, promo_id)["activations_count"] == max_count
+
+
+def test_common_promo_same_user_parallel(company_token, user_tokens):
+    max_count = 10
+    promo_id = _create_promo(
+        company_token,
+        {
+            "description": "Один пользователь активирует COMMON параллельно",
+            "target": {},
+            "max_count": max_count,
+            "mode": "COMMON",
+            "promo_common": "race-single",
+        },
+    )
+
+    responses = _activate_parallel(promo_id, [user_tokens[0]] * 40)
+
+    assert sum(r.status_code == 200 for r in responses) == max_count
+    assert _get_promo(company_token, promo_id)["used_count"] == max_count
+
+
+def test_unique_promo_never_issues_code_twice(company_token, user_tokens):
+    codes = [f"race-{i:03d}" for i in range(40)]
+    promo_id = _create_promo(
+        company_token,
+        {
+            "description": "Параллельная активация UNIQUE промокода",
+            "target": {},
+            "max_count": 1,
+            "mode": "UNIQUE",
+            "promo_unique": codes,
+        },
+    )
+
+    responses = _activate_parallel(promo_id, user_tokens)
+
+    issued = [r.json()["promo"] for r in responses if r.status_code == 200]
+    assert len(issued) == len(codes)
+    assert len(set(issued)) == len(issued)
+    assert set(issued) == set(codes)
+    assert all(r.status_code == 403 for r in responses if r.status_code != 200)
+
+    promo = _get_promo(company_token, promo_id)
+    assert promo["used_count"] == len(codes)
+    assert promo["active"] is False
+    assert _get_stat(company_token, promo_id)["activations_count"] == len(codes)